@@ -46,7 +46,7 @@ func main() {
 	}
 
 	if flag.NArg() < 2 {
-		fmt.Println("USAGE: gengo [-out=] [-import_path=] msg|srv <NAME> [<FILE>]")
+		fmt.Println("USAGE: gengo [-out=] [-import_path=] msg|srv|action <NAME> [<FILE>]")
 		os.Exit(-1)
 	}
 
@@ -120,6 +120,38 @@ func main() {
 			fmt.Println(err)
 			os.Exit(-1)
 		}
+	} else if mode == "action" {
+		var spec *libgengo.ActionSpec
+		var err error
+		if flag.NArg() == 2 {
+			spec, err = context.LoadAction(fullname)
+		} else {
+			spec, err = context.LoadActionFromFile(flag.Arg(2), fullname)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		actionCode, msgCodes, err := libgengo.GenerateAction(context, spec)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+
+		err = writeCode(fullname, actionCode)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+
+		msgSpecs := []*libgengo.MsgSpec{spec.Goal, spec.Feedback, spec.Result, spec.ActionGoal, spec.ActionFeedback, spec.ActionResult, spec.Action}
+		for i, msgSpec := range msgSpecs {
+			err = writeCode(msgSpec.FullName, msgCodes[i])
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+		}
 	} else {
 		fmt.Println("USAGE: gengo <MSG>")
 		os.Exit(-1)
@@ -240,3 +240,61 @@ func TestMD5_sensor_msgs(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadAction(t *testing.T) {
+	const fibonacci string = `#goal definition
+int32 order
+---
+#result definition
+int32[] sequence
+---
+#feedback
+int32[] sequence
+`
+	var expected = map[string]string{
+		"actionlib_tutorials/FibonacciGoal":           "6889063349a00b249bd1661df429d822",
+		"actionlib_tutorials/FibonacciResult":         "b81e37d2a31925a0e8ae261a8699cb79",
+		"actionlib_tutorials/FibonacciFeedback":       "b81e37d2a31925a0e8ae261a8699cb79",
+		"actionlib_tutorials/FibonacciActionGoal":     "006871c7fa1d0e3d5fe2226bf17b2a94",
+		"actionlib_tutorials/FibonacciActionResult":   "bee73a9fe29ae25e966e105f5553dd03",
+		"actionlib_tutorials/FibonacciActionFeedback": "73b8497a9f629a31c0020900e4148f07",
+		"actionlib_tutorials/FibonacciAction":         "f59df5767bf7634684781c92598b2406",
+	}
+
+	ctx, e := libgengo.NewMsgContext([]string{})
+	if e != nil {
+		t.Fatalf("Failed to create MsgContext.")
+	}
+	// Dependencies of the derived messages, so that the test does not need a ROS installation.
+	var deps = []struct {
+		fullname string
+		text     string
+	}{
+		{"std_msgs/Header", "uint32 seq\ntime stamp\nstring frame_id\n"},
+		{"actionlib_msgs/GoalID", "time stamp\nstring id\n"},
+		{"actionlib_msgs/GoalStatus", "GoalID goal_id\nuint8 status\nuint8 PENDING=0\nuint8 ACTIVE=1\nuint8 PREEMPTED=2\n" +
+			"uint8 SUCCEEDED=3\nuint8 ABORTED=4\nuint8 REJECTED=5\nuint8 PREEMPTING=6\nuint8 RECALLING=7\n" +
+			"uint8 RECALLED=8\nuint8 LOST=9\nstring text\n"},
+	}
+	for _, dep := range deps {
+		if _, e := ctx.LoadMsgFromString(dep.text, dep.fullname); e != nil {
+			t.Fatalf("Failed to parse %s: %v", dep.fullname, e)
+		}
+	}
+
+	spec, e := ctx.LoadActionFromString(fibonacci, "actionlib_tutorials/Fibonacci")
+	if e != nil {
+		t.Fatalf("Failed to parse: %v", e)
+	}
+	assertEqual(t, spec.MD5Sum, expected["actionlib_tutorials/FibonacciAction"])
+	for _, msgSpec := range []*libgengo.MsgSpec{spec.Goal, spec.Result, spec.Feedback,
+		spec.ActionGoal, spec.ActionResult, spec.ActionFeedback, spec.Action} {
+		t.Run(msgSpec.ShortName, func(t *testing.T) {
+			assertEqual(t, msgSpec.MD5Sum, expected[msgSpec.FullName])
+		})
+	}
+
+	if _, e := ctx.LoadActionFromString("int32 order\n---\nint32[] sequence\n", "actionlib_tutorials/Broken"); e == nil {
+		t.Errorf("An action with a missing section should fail to parse")
+	}
+}
@@ -131,9 +131,63 @@ func findAllServices(rosPkgPaths []string) (map[string]string, error) {
 	return srvs, nil
 }
 
+func findAllActions(rosPkgPaths []string) (map[string]string, error) {
+	actions := make(map[string]string)
+
+	recurseDirForActions := func(path string, info os.FileInfo, err error) error {
+		// Check whether we could open the path ok.
+		if err != nil {
+			// Just skip this item and try the next one.
+			return nil
+		}
+
+		// Check whether this path is a directory.
+		if !info.IsDir() {
+			// It's not a directory, so we skip it; we only care about directories.
+			return nil
+		}
+
+		// Check whether this directory is a ROS package.
+		if isRosPackage(path) {
+			// It's a ROS package.
+			pkgName := filepath.Base(path)
+			actionPath := filepath.Join(path, ActionDir)
+			actionPaths, err := filepath.Glob(actionPath + "/*" + ExtAction)
+			if err != nil {
+				return nil
+			}
+			for _, a := range actionPaths {
+				basename := filepath.Base(a)
+				rootname := strings.TrimSuffix(basename, ExtAction)
+				fullname := pkgName + "/" + rootname
+				actions[fullname] = a
+			}
+
+			// No point checking INSIDE this one, since it's already a package.
+			return filepath.SkipDir
+		}
+
+		// Else just keep walking.
+		return nil
+	}
+
+	// Iterate over the list of paths to search.
+	for _, p := range rosPkgPaths {
+		err := filepath.Walk(p, recurseDirForActions)
+		if err != nil {
+			// If someone complains, then we just skip searching the reset of this path.
+			continue
+		}
+	}
+
+	// Return whatever we found.
+	return actions, nil
+}
+
 type MsgContext struct {
 	msgPathMap      map[string]string
 	srvPathMap      map[string]string
+	actionPathMap   map[string]string
 	msgRegistry     map[string]*MsgSpec
 	msgRegistryLock sync.RWMutex
 }
@@ -151,6 +205,12 @@ func NewMsgContext(rosPkgPaths []string) (*MsgContext, error) {
 		return nil, err
 	}
 	ctx.srvPathMap = srvs
+
+	actions, err := findAllActions(rosPkgPaths)
+	if err != nil {
+		return nil, err
+	}
+	ctx.actionPathMap = actions
 	ctx.msgRegistry = make(map[string]*MsgSpec)
 	return ctx, nil
 }
@@ -288,6 +348,77 @@ func (ctx *MsgContext) LoadSrv(fullname string) (*SrvSpec, error) {
 	}
 }
 
+// Header of the messages which genmsg derives from an action definition.
+const actionAutogeneratedHeader = "# ====== DO NOT MODIFY! AUTOGENERATED FROM AN ACTION DEFINITION ======\n"
+
+// LoadActionFromString parses an action definition and derives the seven messages which make up
+// the action, in the same way as genmsg: <Name>Goal, <Name>Result and <Name>Feedback hold the three
+// sections of the definition, and <Name>ActionGoal, <Name>ActionResult, <Name>ActionFeedback and
+// <Name>Action wrap them for the wire.  All of them are registered in the context.
+func (ctx *MsgContext) LoadActionFromString(text string, fullname string) (*ActionSpec, error) {
+	packageName, shortName, err := packageResourceName(fullname)
+	if err != nil {
+		return nil, err
+	}
+
+	components := strings.Split(text, IoDelim)
+	if len(components) != 3 {
+		return nil, fmt.Errorf("Syntax error: an action requires exactly two '---' separators")
+	}
+
+	spec := &ActionSpec{Package: packageName, ShortName: shortName, FullName: fullname, Text: text}
+
+	derived := []struct {
+		spec **MsgSpec
+		name string
+		text string
+	}{
+		{&spec.Goal, shortName + "Goal", actionAutogeneratedHeader + components[0]},
+		{&spec.Result, shortName + "Result", actionAutogeneratedHeader + components[1]},
+		{&spec.Feedback, shortName + "Feedback", actionAutogeneratedHeader + components[2]},
+		{&spec.ActionGoal, shortName + "ActionGoal", actionAutogeneratedHeader +
+			"\nHeader header\nactionlib_msgs/GoalID goal_id\n" + shortName + "Goal goal\n"},
+		{&spec.ActionResult, shortName + "ActionResult", actionAutogeneratedHeader +
+			"\nHeader header\nactionlib_msgs/GoalStatus status\n" + shortName + "Result result\n"},
+		{&spec.ActionFeedback, shortName + "ActionFeedback", actionAutogeneratedHeader +
+			"\nHeader header\nactionlib_msgs/GoalStatus status\n" + shortName + "Feedback feedback\n"},
+		{&spec.Action, shortName + "Action", actionAutogeneratedHeader + "\n" +
+			shortName + "ActionGoal action_goal\n" + shortName + "ActionResult action_result\n" + shortName + "ActionFeedback action_feedback\n"},
+	}
+	for _, d := range derived {
+		msgSpec, err := ctx.LoadMsgFromString(d.text, packageName+Sep+d.name)
+		if err != nil {
+			return nil, err
+		}
+		*d.spec = msgSpec
+	}
+	spec.MD5Sum = spec.Action.MD5Sum
+
+	return spec, nil
+}
+
+func (ctx *MsgContext) LoadActionFromFile(filePath string, fullname string) (*ActionSpec, error) {
+	bytes, e := ioutil.ReadFile(filePath)
+	if e != nil {
+		return nil, e
+	}
+	text := string(bytes)
+	return ctx.LoadActionFromString(text, fullname)
+}
+
+func (ctx *MsgContext) LoadAction(fullname string) (*ActionSpec, error) {
+	if path, ok := ctx.actionPathMap[fullname]; ok {
+		spec, err := ctx.LoadActionFromFile(path, fullname)
+		if err != nil {
+			return nil, err
+		} else {
+			return spec, nil
+		}
+	} else {
+		return nil, fmt.Errorf("Action definition of `%s` is not found", fullname)
+	}
+}
+
 func (ctx *MsgContext) ComputeMD5Text(spec *MsgSpec) (string, error) {
 	var buf bytes.Buffer
 	for _, c := range spec.Constants {
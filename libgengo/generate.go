@@ -19,8 +19,6 @@ import (
 {{- range .Imports }}
 	"{{ . }}"
 {{- end }}
-
-    "github.com/edwinhayes/rosgo/ros"
 )
 
 {{- if gt (len .Constants) 0 }}
@@ -288,6 +286,48 @@ func (s *{{ .ShortName }}) ReqMessage() ros.Message { return &s.Request }
 func (s *{{ .ShortName }}) ResMessage() ros.Message { return &s.Response }
 `
 
+var actionTemplate = `
+// Package {{ .Package }} is automatically generated from the action definition "{{ .FullName }}.action"
+package {{ .Package }}
+import (
+    "github.com/edwinhayes/rosgo/ros"
+)
+
+// Action type metadata
+type _Action{{ .ShortName }} struct {
+    name string
+    md5sum string
+    goalType ros.MessageType
+    feedbackType ros.MessageType
+    resultType ros.MessageType
+    actionGoalType ros.MessageType
+    actionFeedbackType ros.MessageType
+    actionResultType ros.MessageType
+}
+
+func (t *_Action{{ .ShortName }}) Name() string { return t.name }
+func (t *_Action{{ .ShortName }}) MD5Sum() string { return t.md5sum }
+func (t *_Action{{ .ShortName }}) GoalType() ros.MessageType { return t.goalType }
+func (t *_Action{{ .ShortName }}) FeedbackType() ros.MessageType { return t.feedbackType }
+func (t *_Action{{ .ShortName }}) ResultType() ros.MessageType { return t.resultType }
+func (t *_Action{{ .ShortName }}) ActionGoalType() ros.MessageType { return t.actionGoalType }
+func (t *_Action{{ .ShortName }}) ActionFeedbackType() ros.MessageType { return t.actionFeedbackType }
+func (t *_Action{{ .ShortName }}) ActionResultType() ros.MessageType { return t.actionResultType }
+
+var (
+    Action{{ .ShortName }} = &_Action{{ .ShortName }} {
+        "{{ .Action.FullName }}",
+        "{{ .MD5Sum }}",
+        Msg{{ .ShortName }}Goal,
+        Msg{{ .ShortName }}Feedback,
+        Msg{{ .ShortName }}Result,
+        Msg{{ .ShortName }}ActionGoal,
+        Msg{{ .ShortName }}ActionFeedback,
+        Msg{{ .ShortName }}ActionResult,
+    }
+)
+`
+
 type MsgGen struct {
 	MsgSpec
 	BinaryRequired bool
@@ -372,3 +412,29 @@ func GenerateService(context *MsgContext, spec *SrvSpec) (string, string, string
 	}
 	return buffer.String(), reqCode, resCode, err
 }
+
+// GenerateAction generates the code of the action type, followed by the code of its seven messages in the
+// order Goal, Feedback, Result, ActionGoal, ActionFeedback, ActionResult and Action.
+func GenerateAction(context *MsgContext, spec *ActionSpec) (string, []string, error) {
+	var msgCodes []string
+	for _, msgSpec := range []*MsgSpec{spec.Goal, spec.Feedback, spec.Result, spec.ActionGoal, spec.ActionFeedback, spec.ActionResult, spec.Action} {
+		code, err := GenerateMessage(context, msgSpec)
+		if err != nil {
+			return "", nil, err
+		}
+		msgCodes = append(msgCodes, code)
+	}
+
+	tmpl, err := template.New("action").Parse(actionTemplate)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var buffer bytes.Buffer
+
+	err = tmpl.Execute(&buffer, spec)
+	if err != nil {
+		return "", nil, err
+	}
+	return buffer.String(), msgCodes, err
+}
@@ -8,11 +8,13 @@ import (
 )
 
 const (
-	Sep    = "/"
-	MsgDir = "msg"
-	SrvDir = "srv"
-	ExtMsg = ".msg"
-	ExtSrv = ".msg"
+	Sep       = "/"
+	MsgDir    = "msg"
+	SrvDir    = "srv"
+	ActionDir = "action"
+	ExtMsg    = ".msg"
+	ExtSrv    = ".msg"
+	ExtAction = ".action"
 
 	ConstChar   = "="
 	CommentChar = "#"
@@ -0,0 +1,11 @@
+package libtest_master
+
+import (
+	"testing"
+)
+
+func Test(t *testing.T) {
+	RTTest(t)
+}
+
+// ALL DONE.
@@ -0,0 +1,79 @@
+package libtest_master
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edwinhayes/rosgo/libtest/msgs/std_msgs"
+	"github.com/edwinhayes/rosgo/master"
+	"github.com/edwinhayes/rosgo/ros"
+)
+
+// RTTest starts an in-process master and checks that rosgo nodes can use it in place of roscore:
+// a talker and a listener exchange a message, and parameters are set and read back.
+func RTTest(t *testing.T) {
+	m, err := master.NewMaster("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Shutdown()
+	args := []string{"__master:=" + m.URI(), "__ip:=127.0.0.1"}
+
+	talker, err := ros.NewNode("/talker", args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer talker.Shutdown()
+	listener, err := ros.NewNode("/listener", args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Shutdown()
+
+	received := make(chan string, 10)
+	if _, err := listener.NewSubscriber("/chatter", std_msgs.MsgString, func(msg *std_msgs.String) {
+		received <- msg.Data
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := talker.NewPublisher("/chatter", std_msgs.MsgString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(10 * time.Second)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case data := <-received:
+			if data != "hello" {
+				t.Errorf("Received %s", data)
+			}
+			done = true
+		case <-ticker.C:
+			pub.Publish(&std_msgs.String{Data: "hello"})
+			listener.SpinOnce()
+		case <-timeout:
+			t.Fatal("Timed out waiting for a message")
+		}
+	}
+
+	if err := talker.SetParam("/test_param", 42); err != nil {
+		t.Error("SetParam api call failed", err)
+	}
+	if param, err := listener.GetParam("/test_param"); err != nil {
+		t.Error("GetParam api call failed", err)
+	} else if value, ok := param.(int32); !ok || value != 42 {
+		t.Error("Test Param value is wrong", param)
+	}
+	if foundKey, err := listener.SearchParam("test_param"); err != nil || foundKey != "/test_param" {
+		t.Error("SearchParam api call failed", foundKey, err)
+	}
+	if err := listener.DeleteParam("/test_param"); err != nil {
+		t.Error("DeleteParam api call failed", err)
+	}
+	if hasParam, err := listener.HasParam("/test_param"); err != nil || hasParam {
+		t.Error("HasParam returned true for a deleted parameter", err)
+	}
+}
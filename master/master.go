@@ -0,0 +1,534 @@
+// Package master implements the ROS Master API and the parameter server in pure Go, so that ROS nodes can run
+// without a roscore; for example, tests can start a master on a random local port.
+package master
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	modular "github.com/edwinhayes/logrus-modular"
+	"github.com/edwinhayes/rosgo/xmlrpc"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	apiStatusError   int32 = -1
+	apiStatusFailure int32 = 0
+	apiStatusSuccess int32 = 1
+	// masterCallerID is used by the master when it calls the slave API of nodes.
+	masterCallerID = "/master"
+)
+
+// serviceProvider is a node which provides a service.
+type serviceProvider struct {
+	callerID   string
+	serviceAPI string
+}
+
+// Master is a ROS master, serving the Master API and the parameter server over XML-RPC.
+type Master struct {
+	uri       string
+	listener  net.Listener
+	server    *http.Server
+	handler   *xmlrpc.Handler
+	logger    modular.ModuleLogger
+	mutex     sync.Mutex
+	nodes     map[string]string   // Slave API URIs by caller id.
+	pubs      map[string][]string // Caller ids of the publishers of each topic.
+	subs      map[string][]string // Caller ids of the subscribers of each topic.
+	services  map[string]serviceProvider
+	types     map[string]string // Topic types.
+	params    paramTree
+	paramSubs map[string]map[string]bool // Caller ids of the subscribers of each parameter namespace.
+	notifier  notifier
+	closeOnce sync.Once
+}
+
+// NewMaster creates a master listening on address, e.g. ":11311"; the port of "127.0.0.1:0" is chosen by the
+// operating system.  The master serves requests until Shutdown is called.
+func NewMaster(address string) (*Master, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		if host, err = os.Hostname(); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	m := new(Master)
+	m.uri = fmt.Sprintf("http://%s/", net.JoinHostPort(host, port))
+	m.listener = listener
+	m.logger = modular.NewRootLogger(logger)
+	m.nodes = make(map[string]string)
+	m.pubs = make(map[string][]string)
+	m.subs = make(map[string][]string)
+	m.services = make(map[string]serviceProvider)
+	m.types = make(map[string]string)
+	m.params = make(paramTree)
+	m.paramSubs = make(map[string]map[string]bool)
+	m.notifier.pending = make(map[string][]func())
+	m.handler = xmlrpc.NewHandler(m.methods())
+	m.server = &http.Server{Handler: m.handler}
+	go m.server.Serve(listener)
+	m.logger.Debugf("Master started at %s", m.uri)
+	return m, nil
+}
+
+// URI returns the URI of the master, to be used as ROS_MASTER_URI.
+func (m *Master) URI() string {
+	return m.uri
+}
+
+// Logger returns the logger of the master.
+func (m *Master) Logger() *modular.ModuleLogger {
+	return &m.logger
+}
+
+// Shutdown stops serving requests, and closes the connections of the nodes, like an exiting roscore.  Nodes
+// registered with the master are not shut down.
+func (m *Master) Shutdown() {
+	m.closeOnce.Do(func() {
+		m.server.Close()
+		m.handler.WaitForShutdown()
+		m.notifier.close()
+		m.logger.Debug("Master shut down")
+	})
+}
+
+func (m *Master) methods() map[string]xmlrpc.Method {
+	return map[string]xmlrpc.Method{
+		"getUri": func(callerID string) (interface{}, error) {
+			return buildRosAPIResult(apiStatusSuccess, "", m.uri), nil
+		},
+		"getPid": func(callerID string) (interface{}, error) {
+			return buildRosAPIResult(apiStatusSuccess, "", os.Getpid()), nil
+		},
+		"registerService": func(callerID, service, serviceAPI, callerAPI string) (interface{}, error) {
+			return m.registerService(callerID, service, serviceAPI, callerAPI), nil
+		},
+		"unregisterService": func(callerID, service, serviceAPI string) (interface{}, error) {
+			return m.unregisterService(callerID, service, serviceAPI), nil
+		},
+		"registerSubscriber": func(callerID, topic, topicType, callerAPI string) (interface{}, error) {
+			return m.registerSubscriber(callerID, topic, topicType, callerAPI), nil
+		},
+		"unregisterSubscriber": func(callerID, topic, callerAPI string) (interface{}, error) {
+			return m.unregisterSubscriber(callerID, topic, callerAPI), nil
+		},
+		"registerPublisher": func(callerID, topic, topicType, callerAPI string) (interface{}, error) {
+			return m.registerPublisher(callerID, topic, topicType, callerAPI), nil
+		},
+		"unregisterPublisher": func(callerID, topic, callerAPI string) (interface{}, error) {
+			return m.unregisterPublisher(callerID, topic, callerAPI), nil
+		},
+		"lookupNode": func(callerID, nodeName string) (interface{}, error) {
+			return m.lookupNode(callerID, nodeName), nil
+		},
+		"lookupService": func(callerID, service string) (interface{}, error) {
+			return m.lookupService(callerID, service), nil
+		},
+		"getPublishedTopics": func(callerID, subgraph string) (interface{}, error) {
+			return m.getPublishedTopics(callerID, subgraph), nil
+		},
+		"getTopicTypes": func(callerID string) (interface{}, error) {
+			return m.getTopicTypes(callerID), nil
+		},
+		"getSystemState": func(callerID string) (interface{}, error) {
+			return m.getSystemState(callerID), nil
+		},
+		"deleteParam": func(callerID, key string) (interface{}, error) {
+			return m.deleteParam(callerID, key), nil
+		},
+		"setParam": func(callerID, key string, value interface{}) (interface{}, error) {
+			return m.setParam(callerID, key, value), nil
+		},
+		"getParam": func(callerID, key string) (interface{}, error) {
+			return m.getParam(callerID, key), nil
+		},
+		"searchParam": func(callerID, key string) (interface{}, error) {
+			return m.searchParam(callerID, key), nil
+		},
+		"subscribeParam": func(callerID, callerAPI, key string) (interface{}, error) {
+			return m.subscribeParam(callerID, callerAPI, key), nil
+		},
+		"unsubscribeParam": func(callerID, callerAPI, key string) (interface{}, error) {
+			return m.unsubscribeParam(callerID, callerAPI, key), nil
+		},
+		"hasParam": func(callerID, key string) (interface{}, error) {
+			return m.hasParam(callerID, key), nil
+		},
+		"getParamNames": func(callerID string) (interface{}, error) {
+			return m.getParamNames(callerID), nil
+		},
+	}
+}
+
+// Build XMLRPC ready array from ROS API result triplet.
+func buildRosAPIResult(code int32, message string, value interface{}) interface{} {
+	return []interface{}{code, message, value}
+}
+
+// registerNode records the slave API of a node, which must be locked.  A node registering with the name of
+// another node replaces it: the registrations of the old node are dropped, and it is asked to shut down.
+func (m *Master) registerNode(callerID, callerAPI string) {
+	if api, ok := m.nodes[callerID]; ok && api != callerAPI {
+		m.logger.Infof("Node %s re-registered from %s, shutting down %s", callerID, callerAPI, api)
+		m.dropNode(callerID)
+		m.notifier.call(api, "shutdown", masterCallerID, fmt.Sprintf("new node registered with same name [%s]", callerID))
+	}
+	m.nodes[callerID] = callerAPI
+}
+
+// dropNode removes a node and all of its registrations; the master must be locked.
+func (m *Master) dropNode(callerID string) {
+	for topic, ids := range m.pubs {
+		if contains(ids, callerID) {
+			m.pubs[topic] = remove(ids, callerID)
+			m.notifyPublisherUpdate(topic)
+		}
+	}
+	for topic, ids := range m.subs {
+		m.subs[topic] = remove(ids, callerID)
+	}
+	for service, provider := range m.services {
+		if provider.callerID == callerID {
+			delete(m.services, service)
+		}
+	}
+	for key, ids := range m.paramSubs {
+		delete(ids, callerID)
+		if len(ids) == 0 {
+			delete(m.paramSubs, key)
+		}
+	}
+	m.cleanup()
+	delete(m.nodes, callerID)
+}
+
+// cleanup removes empty registrations, and nodes without registrations; the master must be locked.
+func (m *Master) cleanup() {
+	registered := make(map[string]bool)
+	for topic, ids := range m.pubs {
+		if len(ids) == 0 {
+			delete(m.pubs, topic)
+		}
+		for _, id := range ids {
+			registered[id] = true
+		}
+	}
+	for topic, ids := range m.subs {
+		if len(ids) == 0 {
+			delete(m.subs, topic)
+		}
+		for _, id := range ids {
+			registered[id] = true
+		}
+	}
+	for _, provider := range m.services {
+		registered[provider.callerID] = true
+	}
+	for _, ids := range m.paramSubs {
+		for id := range ids {
+			registered[id] = true
+		}
+	}
+	for id := range m.nodes {
+		if !registered[id] {
+			delete(m.nodes, id)
+		}
+	}
+	for topic := range m.types {
+		if _, ok := m.pubs[topic]; ok {
+			continue
+		}
+		if _, ok := m.subs[topic]; ok {
+			continue
+		}
+		delete(m.types, topic)
+	}
+}
+
+// publisherAPIs returns the slave APIs of the publishers of topic; the master must be locked.
+func (m *Master) publisherAPIs(topic string) []string {
+	apis := []string{}
+	for _, id := range m.pubs[topic] {
+		apis = append(apis, m.nodes[id])
+	}
+	return apis
+}
+
+// notifyPublisherUpdate sends the publishers of topic to its subscribers; the master must be locked.
+func (m *Master) notifyPublisherUpdate(topic string) {
+	apis := m.publisherAPIs(topic)
+	for _, id := range m.subs[topic] {
+		m.notifier.call(m.nodes[id], "publisherUpdate", masterCallerID, topic, apis)
+	}
+}
+
+func (m *Master) registerService(callerID, service, serviceAPI, callerAPI string) interface{} {
+	service = resolveName(service, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.registerNode(callerID, callerAPI)
+	m.services[service] = serviceProvider{callerID, serviceAPI}
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("Registered [%s] as provider of [%s]", callerID, service), 1)
+}
+
+func (m *Master) unregisterService(callerID, service, serviceAPI string) interface{} {
+	service = resolveName(service, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if provider, ok := m.services[service]; !ok || provider.callerID != callerID || provider.serviceAPI != serviceAPI {
+		return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("[%s] is not a provider of [%s]", callerID, service), 0)
+	}
+	delete(m.services, service)
+	m.cleanup()
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("Unregistered [%s] as provider of [%s]", callerID, service), 1)
+}
+
+func (m *Master) registerSubscriber(callerID, topic, topicType, callerAPI string) interface{} {
+	topic = resolveName(topic, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.registerNode(callerID, callerAPI)
+	if !contains(m.subs[topic], callerID) {
+		m.subs[topic] = append(m.subs[topic], callerID)
+	}
+	if t, ok := m.types[topic]; !ok || t == "*" {
+		m.types[topic] = topicType
+	}
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("Subscribed to [%s]", topic), m.publisherAPIs(topic))
+}
+
+func (m *Master) unregisterSubscriber(callerID, topic, callerAPI string) interface{} {
+	topic = resolveName(topic, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !contains(m.subs[topic], callerID) || m.nodes[callerID] != callerAPI {
+		return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("[%s] is not a subscriber of [%s]", callerID, topic), 0)
+	}
+	m.subs[topic] = remove(m.subs[topic], callerID)
+	m.cleanup()
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("Unregistered [%s] as subscriber of [%s]", callerID, topic), 1)
+}
+
+func (m *Master) registerPublisher(callerID, topic, topicType, callerAPI string) interface{} {
+	topic = resolveName(topic, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.registerNode(callerID, callerAPI)
+	if !contains(m.pubs[topic], callerID) {
+		m.pubs[topic] = append(m.pubs[topic], callerID)
+	}
+	m.types[topic] = topicType
+	m.notifyPublisherUpdate(topic)
+	subAPIs := []string{}
+	for _, id := range m.subs[topic] {
+		subAPIs = append(subAPIs, m.nodes[id])
+	}
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("Registered [%s] as publisher of [%s]", callerID, topic), subAPIs)
+}
+
+func (m *Master) unregisterPublisher(callerID, topic, callerAPI string) interface{} {
+	topic = resolveName(topic, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !contains(m.pubs[topic], callerID) || m.nodes[callerID] != callerAPI {
+		return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("[%s] is not a publisher of [%s]", callerID, topic), 0)
+	}
+	m.pubs[topic] = remove(m.pubs[topic], callerID)
+	m.notifyPublisherUpdate(topic)
+	m.cleanup()
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("Unregistered [%s] as publisher of [%s]", callerID, topic), 1)
+}
+
+func (m *Master) lookupNode(callerID, nodeName string) interface{} {
+	nodeName = resolveName(nodeName, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if api, ok := m.nodes[nodeName]; ok {
+		return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("node api [%s]", api), api)
+	}
+	return buildRosAPIResult(apiStatusError, fmt.Sprintf("unknown node [%s]", nodeName), "")
+}
+
+func (m *Master) lookupService(callerID, service string) interface{} {
+	service = resolveName(service, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if provider, ok := m.services[service]; ok {
+		return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("rosrpc URI: [%s]", provider.serviceAPI), provider.serviceAPI)
+	}
+	return buildRosAPIResult(apiStatusError, fmt.Sprintf("no provider for [%s]", service), "")
+}
+
+func (m *Master) getPublishedTopics(callerID, subgraph string) interface{} {
+	prefix := ""
+	if subgraph != "" {
+		prefix = resolveName(subgraph, callerID)
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	topics := []interface{}{}
+	for _, topic := range sortedKeys(m.pubs) {
+		if strings.HasPrefix(topic, prefix) {
+			topics = append(topics, []interface{}{topic, m.types[topic]})
+		}
+	}
+	return buildRosAPIResult(apiStatusSuccess, "current topics", topics)
+}
+
+func (m *Master) getTopicTypes(callerID string) interface{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	types := []interface{}{}
+	for _, topic := range sortedKeys(m.types) {
+		types = append(types, []interface{}{topic, m.types[topic]})
+	}
+	return buildRosAPIResult(apiStatusSuccess, "current system state", types)
+}
+
+func (m *Master) getSystemState(callerID string) interface{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	state := func(registrations map[string][]string) []interface{} {
+		list := []interface{}{}
+		for _, name := range sortedKeys(registrations) {
+			list = append(list, []interface{}{name, append([]string{}, registrations[name]...)})
+		}
+		return list
+	}
+	services := make(map[string][]string)
+	for service, provider := range m.services {
+		services[service] = []string{provider.callerID}
+	}
+	return buildRosAPIResult(apiStatusSuccess, "current system state",
+		[]interface{}{state(m.pubs), state(m.subs), state(services)})
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, s string) []string {
+	result := []string{}
+	for _, item := range list {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string][]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resolveName resolves name in the namespace of the node callerID, e.g. "~foo" is "/ns/node/foo" and "foo" is
+// "/ns/foo" for the node "/ns/node".
+func resolveName(name, callerID string) string {
+	var resolved string
+	switch {
+	case strings.HasPrefix(name, "/"):
+		resolved = name
+	case strings.HasPrefix(name, "~"):
+		resolved = callerID + "/" + name[1:]
+	default:
+		ns := "/"
+		if i := strings.LastIndex(callerID, "/"); i > 0 {
+			ns = callerID[:i+1]
+		}
+		resolved = ns + name
+	}
+	return canonicalizeName(resolved)
+}
+
+// canonicalizeName removes repeated and trailing slashes from name.
+func canonicalizeName(name string) string {
+	var components []string
+	for _, c := range strings.Split(name, "/") {
+		if c != "" {
+			components = append(components, c)
+		}
+	}
+	return "/" + strings.Join(components, "/")
+}
+
+// notifier calls the slave API of nodes asynchronously.  Calls to the same node are made in order, so that a
+// node never sees a stale publisher list after a newer one.
+type notifier struct {
+	mutex   sync.Mutex
+	pending map[string][]func()
+	closed  bool
+}
+
+func (n *notifier) call(api string, method string, args ...interface{}) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed || api == "" {
+		return
+	}
+	queue, busy := n.pending[api]
+	n.pending[api] = append(queue, func() { xmlrpc.Call(api, method, args...) })
+	if !busy {
+		go n.drain(api)
+	}
+}
+
+// drain makes the pending calls to api, until there are none left.
+func (n *notifier) drain(api string) {
+	for {
+		n.mutex.Lock()
+		queue := n.pending[api]
+		if len(queue) == 0 || n.closed {
+			delete(n.pending, api)
+			n.mutex.Unlock()
+			return
+		}
+		n.pending[api] = queue[1:]
+		n.mutex.Unlock()
+		queue[0]()
+	}
+}
+
+func (n *notifier) close() {
+	n.mutex.Lock()
+	n.closed = true
+	n.mutex.Unlock()
+}
@@ -0,0 +1,288 @@
+package master
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/edwinhayes/rosgo/xmlrpc"
+)
+
+// fakeNode serves the parts of the slave API which the master calls, recording the calls it receives.
+type fakeNode struct {
+	server *httptest.Server
+	calls  chan []interface{}
+}
+
+func newFakeNode() *fakeNode {
+	n := &fakeNode{calls: make(chan []interface{}, 10)}
+	handler := xmlrpc.NewHandler(map[string]xmlrpc.Method{
+		"publisherUpdate": func(callerID string, topic string, publishers []interface{}) (interface{}, error) {
+			n.calls <- []interface{}{"publisherUpdate", topic, publishers}
+			return buildRosAPIResult(apiStatusSuccess, "", 0), nil
+		},
+		"paramUpdate": func(callerID string, key string, value interface{}) (interface{}, error) {
+			n.calls <- []interface{}{"paramUpdate", key, value}
+			return buildRosAPIResult(apiStatusSuccess, "", 0), nil
+		},
+		"shutdown": func(callerID string, msg string) (interface{}, error) {
+			n.calls <- []interface{}{"shutdown"}
+			return buildRosAPIResult(apiStatusSuccess, "", 0), nil
+		},
+	})
+	n.server = httptest.NewServer(handler)
+	return n
+}
+
+func (n *fakeNode) expectCall(t *testing.T, expected ...interface{}) {
+	t.Helper()
+	select {
+	case call := <-n.calls:
+		if !reflect.DeepEqual(call, expected) {
+			t.Errorf("expected call %v, got %v", expected, call)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("timed out waiting for %v", expected)
+	}
+}
+
+func (n *fakeNode) expectNoCall(t *testing.T) {
+	t.Helper()
+	select {
+	case call := <-n.calls:
+		t.Errorf("unexpected call %v", call)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// call calls the master API, failing the test unless the call succeeds with code.
+func call(t *testing.T, m *Master, code int32, method string, args ...interface{}) interface{} {
+	t.Helper()
+	result, err := xmlrpc.Call(m.URI(), method, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	xs := result.([]interface{})
+	if xs[0].(int32) != code {
+		t.Fatalf("%s%v: expected code %d, got %v", method, args, code, xs)
+	}
+	return xs[2]
+}
+
+func newTestMaster(t *testing.T) *Master {
+	m, err := NewMaster("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestResolveName(t *testing.T) {
+	var tests = []struct {
+		name     string
+		callerID string
+		expected string
+	}{
+		{"/foo", "/node", "/foo"},
+		{"foo", "/node", "/foo"},
+		{"foo", "/ns/node", "/ns/foo"},
+		{"~foo", "/ns/node", "/ns/node/foo"},
+		{"foo/bar/", "/ns/node", "/ns/foo/bar"},
+		{"//foo", "/node", "/foo"},
+		{"/", "/node", "/"},
+	}
+	for _, test := range tests {
+		if result := resolveName(test.name, test.callerID); result != test.expected {
+			t.Errorf("resolveName(%s, %s): expected %s, got %s", test.name, test.callerID, test.expected, result)
+		}
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	pub := newFakeNode()
+	defer pub.server.Close()
+	sub := newFakeNode()
+	defer sub.server.Close()
+
+	if uri := call(t, m, apiStatusSuccess, "getUri", "/test"); uri != m.URI() {
+		t.Errorf("getUri returned %v", uri)
+	}
+
+	publishers := call(t, m, apiStatusSuccess, "registerSubscriber", "/ns/listener", "chatter", "std_msgs/String", sub.server.URL)
+	if len(publishers.([]interface{})) != 0 {
+		t.Errorf("expected no publishers, got %v", publishers)
+	}
+	subscribers := call(t, m, apiStatusSuccess, "registerPublisher", "/ns/talker", "/ns/chatter", "std_msgs/String", pub.server.URL)
+	if !reflect.DeepEqual(subscribers, []interface{}{sub.server.URL}) {
+		t.Errorf("expected subscribers [%s], got %v", sub.server.URL, subscribers)
+	}
+	sub.expectCall(t, "publisherUpdate", "/ns/chatter", []interface{}{pub.server.URL})
+
+	call(t, m, apiStatusSuccess, "registerService", "/ns/talker", "/add", "rosrpc://localhost:1234", pub.server.URL)
+	if uri := call(t, m, apiStatusSuccess, "lookupService", "/test", "/add"); uri != "rosrpc://localhost:1234" {
+		t.Errorf("lookupService returned %v", uri)
+	}
+	call(t, m, apiStatusError, "lookupService", "/test", "/missing")
+	if uri := call(t, m, apiStatusSuccess, "lookupNode", "/test", "/ns/talker"); uri != pub.server.URL {
+		t.Errorf("lookupNode returned %v", uri)
+	}
+	call(t, m, apiStatusError, "lookupNode", "/test", "/missing")
+
+	topics := call(t, m, apiStatusSuccess, "getPublishedTopics", "/test", "")
+	if !reflect.DeepEqual(topics, []interface{}{[]interface{}{"/ns/chatter", "std_msgs/String"}}) {
+		t.Errorf("getPublishedTopics returned %v", topics)
+	}
+	topics = call(t, m, apiStatusSuccess, "getPublishedTopics", "/test", "/other")
+	if len(topics.([]interface{})) != 0 {
+		t.Errorf("getPublishedTopics returned %v for another subgraph", topics)
+	}
+	state := call(t, m, apiStatusSuccess, "getSystemState", "/test")
+	expected := []interface{}{
+		[]interface{}{[]interface{}{"/ns/chatter", []interface{}{"/ns/talker"}}},
+		[]interface{}{[]interface{}{"/ns/chatter", []interface{}{"/ns/listener"}}},
+		[]interface{}{[]interface{}{"/add", []interface{}{"/ns/talker"}}},
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Errorf("getSystemState returned %v", state)
+	}
+
+	if n := call(t, m, apiStatusSuccess, "unregisterPublisher", "/ns/talker", "chatter", pub.server.URL); n != int32(1) {
+		t.Errorf("unregisterPublisher returned %v", n)
+	}
+	sub.expectCall(t, "publisherUpdate", "/ns/chatter", []interface{}(nil))
+	if n := call(t, m, apiStatusSuccess, "unregisterPublisher", "/ns/talker", "chatter", pub.server.URL); n != int32(0) {
+		t.Errorf("unregisterPublisher returned %v for a topic which is not published", n)
+	}
+	call(t, m, apiStatusSuccess, "unregisterService", "/ns/talker", "/add", "rosrpc://localhost:1234")
+	// The talker has no registrations left.
+	call(t, m, apiStatusError, "lookupNode", "/test", "/ns/talker")
+
+	types := call(t, m, apiStatusSuccess, "getTopicTypes", "/test")
+	if !reflect.DeepEqual(types, []interface{}{[]interface{}{"/ns/chatter", "std_msgs/String"}}) {
+		t.Errorf("getTopicTypes returned %v", types)
+	}
+}
+
+func TestNodeReplacement(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	old := newFakeNode()
+	defer old.server.Close()
+	replacement := newFakeNode()
+	defer replacement.server.Close()
+
+	call(t, m, apiStatusSuccess, "registerPublisher", "/talker", "/chatter", "std_msgs/String", old.server.URL)
+	call(t, m, apiStatusSuccess, "registerService", "/talker", "/add", "rosrpc://localhost:1234", replacement.server.URL)
+	old.expectCall(t, "shutdown")
+	if uri := call(t, m, apiStatusSuccess, "lookupNode", "/test", "/talker"); uri != replacement.server.URL {
+		t.Errorf("lookupNode returned %v", uri)
+	}
+	topics := call(t, m, apiStatusSuccess, "getPublishedTopics", "/test", "")
+	if len(topics.([]interface{})) != 0 {
+		t.Errorf("publications of the replaced node were kept: %v", topics)
+	}
+}
+
+func TestParams(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+
+	call(t, m, apiStatusError, "getParam", "/test", "/foo")
+	call(t, m, apiStatusSuccess, "setParam", "/ns/node", "foo", int32(42))
+	call(t, m, apiStatusSuccess, "setParam", "/ns/node", "~bar", "private")
+	call(t, m, apiStatusSuccess, "setParam", "/test", "/dict", map[string]interface{}{"a": 1.5, "b": map[string]interface{}{"c": true}})
+
+	if value := call(t, m, apiStatusSuccess, "getParam", "/test", "/ns/foo"); value != int32(42) {
+		t.Errorf("getParam returned %v", value)
+	}
+	if value := call(t, m, apiStatusSuccess, "getParam", "/test", "/ns/node/bar"); value != "private" {
+		t.Errorf("getParam returned %v", value)
+	}
+	if value := call(t, m, apiStatusSuccess, "getParam", "/test", "/dict/b/c"); value != true {
+		t.Errorf("getParam returned %v", value)
+	}
+	ns := call(t, m, apiStatusSuccess, "getParam", "/test", "/ns")
+	if !reflect.DeepEqual(ns, map[string]interface{}{"foo": int32(42), "node": map[string]interface{}{"bar": "private"}}) {
+		t.Errorf("getParam returned %v for a namespace", ns)
+	}
+	if has := call(t, m, apiStatusSuccess, "hasParam", "/test", "/dict/a"); has != true {
+		t.Error("hasParam returned false for a parameter which is set")
+	}
+	if has := call(t, m, apiStatusSuccess, "hasParam", "/test", "/dict/z"); has != false {
+		t.Error("hasParam returned true for a parameter which is not set")
+	}
+
+	names := call(t, m, apiStatusSuccess, "getParamNames", "/test")
+	expected := []interface{}{"/dict/a", "/dict/b/c", "/ns/foo", "/ns/node/bar"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("getParamNames returned %v", names)
+	}
+
+	if key := call(t, m, apiStatusSuccess, "searchParam", "/ns/sub/node", "foo"); key != "/ns/foo" {
+		t.Errorf("searchParam returned %v", key)
+	}
+	if key := call(t, m, apiStatusSuccess, "searchParam", "/ns/node", "bar"); key != "/ns/node/bar" {
+		t.Errorf("searchParam returned %v", key)
+	}
+	if key := call(t, m, apiStatusSuccess, "searchParam", "/other/node", "dict/b"); key != "/dict/b" {
+		t.Errorf("searchParam returned %v", key)
+	}
+	call(t, m, apiStatusError, "searchParam", "/other/node", "foo")
+
+	call(t, m, apiStatusSuccess, "deleteParam", "/test", "/dict/b")
+	call(t, m, apiStatusError, "getParam", "/test", "/dict/b/c")
+	call(t, m, apiStatusError, "deleteParam", "/test", "/dict/b")
+}
+
+func TestSubscribeParam(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newFakeNode()
+	defer node.server.Close()
+
+	value := call(t, m, apiStatusSuccess, "subscribeParam", "/node", node.server.URL, "/robot/speed")
+	if !reflect.DeepEqual(value, map[string]interface{}{}) {
+		t.Errorf("subscribeParam returned %v for a parameter which is not set", value)
+	}
+
+	call(t, m, apiStatusSuccess, "setParam", "/test", "/robot/speed", 1.5)
+	node.expectCall(t, "paramUpdate", "/robot/speed/", 1.5)
+
+	// Updating the parent namespace sends the new value of the subscribed parameter.
+	call(t, m, apiStatusSuccess, "setParam", "/test", "/robot", map[string]interface{}{"speed": 2.5, "name": "r2"})
+	node.expectCall(t, "paramUpdate", "/robot/speed/", 2.5)
+
+	call(t, m, apiStatusSuccess, "setParam", "/test", "/robot/name", "r3")
+	node.expectNoCall(t)
+
+	call(t, m, apiStatusSuccess, "deleteParam", "/test", "/robot/speed")
+	node.expectCall(t, "paramUpdate", "/robot/speed/", map[string]interface{}{})
+
+	// Subscribers of a namespace are notified of the parameters inside it.
+	call(t, m, apiStatusSuccess, "subscribeParam", "/node", node.server.URL, "/robot")
+	call(t, m, apiStatusSuccess, "setParam", "/test", "/robot/name", "r4")
+	node.expectCall(t, "paramUpdate", "/robot/name/", "r4")
+
+	if n := call(t, m, apiStatusSuccess, "unsubscribeParam", "/node", node.server.URL, "/robot"); n != int32(1) {
+		t.Errorf("unsubscribeParam returned %v", n)
+	}
+	call(t, m, apiStatusSuccess, "unsubscribeParam", "/node", node.server.URL, "/robot/speed")
+	call(t, m, apiStatusSuccess, "setParam", "/test", "/robot/speed", 3.5)
+	node.expectNoCall(t)
+}
+
+func ExampleNewMaster() {
+	m, err := NewMaster("127.0.0.1:0")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer m.Shutdown()
+	// Nodes find the master with ROS_MASTER_URI, or the __master:= argument.
+	fmt.Println(len(m.URI()) > 0)
+	// Output: true
+}
@@ -0,0 +1,290 @@
+package master
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// paramTree holds the parameters in nested namespaces; a namespace is a map[string]interface{}, as decoded
+// from an XML-RPC struct.
+type paramTree map[string]interface{}
+
+func splitKey(key string) []string {
+	var components []string
+	for _, c := range strings.Split(key, "/") {
+		if c != "" {
+			components = append(components, c)
+		}
+	}
+	return components
+}
+
+// get returns a copy of the value of the canonical key; the value of a namespace is a map.
+func (t paramTree) get(key string) (interface{}, bool) {
+	var value interface{} = map[string]interface{}(t)
+	for _, c := range splitKey(key) {
+		ns, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = ns[c]; !ok {
+			return nil, false
+		}
+	}
+	return copyParam(value), true
+}
+
+// set sets the value of the canonical key, creating its parent namespaces.  Setting a namespace replaces all
+// of the parameters in it.
+func (t paramTree) set(key string, value interface{}) error {
+	components := splitKey(key)
+	if len(components) == 0 {
+		root, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot set root of parameter tree to non-dictionary")
+		}
+		for k := range t {
+			delete(t, k)
+		}
+		for k, v := range root {
+			t[k] = copyParam(v)
+		}
+		return nil
+	}
+	ns := map[string]interface{}(t)
+	for _, c := range components[:len(components)-1] {
+		child, ok := ns[c].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			ns[c] = child
+		}
+		ns = child
+	}
+	ns[components[len(components)-1]] = copyParam(value)
+	return nil
+}
+
+// delete removes the canonical key, reporting whether it was set.
+func (t paramTree) delete(key string) bool {
+	components := splitKey(key)
+	if len(components) == 0 {
+		return false
+	}
+	ns := map[string]interface{}(t)
+	for _, c := range components[:len(components)-1] {
+		child, ok := ns[c].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		ns = child
+	}
+	last := components[len(components)-1]
+	if _, ok := ns[last]; !ok {
+		return false
+	}
+	delete(ns, last)
+	return true
+}
+
+// names returns the keys of all parameters which are not namespaces.
+func (t paramTree) names() []string {
+	var names []string
+	var walk func(prefix string, ns map[string]interface{})
+	walk = func(prefix string, ns map[string]interface{}) {
+		for k, v := range ns {
+			if child, ok := v.(map[string]interface{}); ok {
+				walk(prefix+k+"/", child)
+			} else {
+				names = append(names, prefix+k)
+			}
+		}
+	}
+	walk("/", t)
+	sort.Strings(names)
+	return names
+}
+
+// search looks for key in the namespace of the node callerID and its parents, starting with the private
+// namespace of the node.  It returns the resolved key of the closest namespace containing the first component
+// of key.
+func (t paramTree) search(callerID, key string) (string, bool) {
+	if strings.HasPrefix(key, "/") {
+		_, ok := t.get(canonicalizeName(key))
+		return canonicalizeName(key), ok
+	}
+	components := splitKey(key)
+	if len(components) == 0 {
+		return "", false
+	}
+	namespaces := splitKey(callerID)
+	for i := len(namespaces); i >= 0; i-- {
+		ns := "/" + strings.Join(namespaces[:i], "/")
+		if _, ok := t.get(canonicalizeName(ns + "/" + components[0])); ok {
+			return canonicalizeName(ns + "/" + key), true
+		}
+	}
+	return "", false
+}
+
+func copyParam(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			c[k] = copyParam(x)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, x := range v {
+			c[i] = copyParam(x)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// nsKey returns the canonical key with a trailing slash, which is how parameter subscriptions are keyed and
+// reported by the ROS master.
+func nsKey(key string) string {
+	if key == "/" {
+		return key
+	}
+	return key + "/"
+}
+
+// notifyParamUpdate sends the new value of key to the nodes subscribed to it, to one of its parent namespaces,
+// or to a parameter inside it; the master must be locked.  A deleted parameter is reported as an empty map.
+func (m *Master) notifyParamUpdate(key string, value interface{}) {
+	updated := nsKey(key)
+	for _, subKey := range sortedParamSubKeys(m.paramSubs) {
+		var updateKey string
+		var updateValue interface{}
+		if strings.HasPrefix(updated, subKey) {
+			// The parameter is subscribed to, or is in a subscribed namespace.
+			updateKey, updateValue = updated, value
+		} else if strings.HasPrefix(subKey, updated) {
+			// The subscribed parameter is inside the updated namespace.
+			updateKey = subKey
+			var ok bool
+			if updateValue, ok = m.params.get(subKey); !ok {
+				updateValue = map[string]interface{}{}
+			}
+		} else {
+			continue
+		}
+		for _, id := range sortedSet(m.paramSubs[subKey]) {
+			m.notifier.call(m.nodes[id], "paramUpdate", masterCallerID, updateKey, copyParam(updateValue))
+		}
+	}
+}
+
+func sortedParamSubKeys(subs map[string]map[string]bool) []string {
+	keys := make([]string, 0, len(subs))
+	for k := range subs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedSet(set map[string]bool) []string {
+	items := make([]string, 0, len(set))
+	for k := range set {
+		items = append(items, k)
+	}
+	sort.Strings(items)
+	return items
+}
+
+func (m *Master) deleteParam(callerID, key string) interface{} {
+	key = resolveName(key, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.params.delete(key) {
+		return buildRosAPIResult(apiStatusError, fmt.Sprintf("parameter [%s] is not set", key), 0)
+	}
+	m.notifyParamUpdate(key, map[string]interface{}{})
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("parameter %s deleted", key), 0)
+}
+
+func (m *Master) setParam(callerID, key string, value interface{}) interface{} {
+	key = resolveName(key, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.params.set(key, value); err != nil {
+		return buildRosAPIResult(apiStatusError, err.Error(), 0)
+	}
+	m.notifyParamUpdate(key, value)
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("parameter %s set", key), 0)
+}
+
+func (m *Master) getParam(callerID, key string) interface{} {
+	key = resolveName(key, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value, ok := m.params.get(key)
+	if !ok {
+		return buildRosAPIResult(apiStatusError, fmt.Sprintf("Parameter [%s] is not set", key), 0)
+	}
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("Parameter [%s]", key), value)
+}
+
+func (m *Master) searchParam(callerID, key string) interface{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	found, ok := m.params.search(callerID, key)
+	if !ok {
+		return buildRosAPIResult(apiStatusError, fmt.Sprintf("Cannot find parameter [%s] in an upwards search", key), "")
+	}
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("Found [%s]", found), found)
+}
+
+func (m *Master) subscribeParam(callerID, callerAPI, key string) interface{} {
+	key = resolveName(key, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.registerNode(callerID, callerAPI)
+	subKey := nsKey(key)
+	if _, ok := m.paramSubs[subKey]; !ok {
+		m.paramSubs[subKey] = make(map[string]bool)
+	}
+	m.paramSubs[subKey][callerID] = true
+	value, ok := m.params.get(key)
+	if !ok {
+		value = map[string]interface{}{}
+	}
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("Subscribed to parameter [%s]", key), value)
+}
+
+func (m *Master) unsubscribeParam(callerID, callerAPI, key string) interface{} {
+	key = resolveName(key, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	subKey := nsKey(key)
+	if !m.paramSubs[subKey][callerID] || m.nodes[callerID] != callerAPI {
+		return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("[%s] is not subscribed to parameter [%s]", callerID, key), 0)
+	}
+	delete(m.paramSubs[subKey], callerID)
+	if len(m.paramSubs[subKey]) == 0 {
+		delete(m.paramSubs, subKey)
+	}
+	m.cleanup()
+	return buildRosAPIResult(apiStatusSuccess, fmt.Sprintf("Unsubscribed from parameter [%s]", key), 1)
+}
+
+func (m *Master) hasParam(callerID, key string) interface{} {
+	key = resolveName(key, callerID)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, ok := m.params.get(key)
+	return buildRosAPIResult(apiStatusSuccess, key, ok)
+}
+
+func (m *Master) getParamNames(callerID string) interface{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return buildRosAPIResult(apiStatusSuccess, "Parameter names", m.params.names())
+}
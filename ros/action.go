@@ -0,0 +1,357 @@
+package ros
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Goal states reported by action servers, as defined by actionlib_msgs/GoalStatus.
+const (
+	//GoalStatusPending means the goal has yet to be processed by the action server
+	GoalStatusPending uint8 = 0
+	//GoalStatusActive means the goal is currently being processed by the action server
+	GoalStatusActive uint8 = 1
+	//GoalStatusPreempted means the goal received a cancel request after it started executing and has completed its execution
+	GoalStatusPreempted uint8 = 2
+	//GoalStatusSucceeded means the goal was achieved successfully by the action server
+	GoalStatusSucceeded uint8 = 3
+	//GoalStatusAborted means the goal was aborted during execution by the action server
+	GoalStatusAborted uint8 = 4
+	//GoalStatusRejected means the goal was rejected by the action server without being processed
+	GoalStatusRejected uint8 = 5
+	//GoalStatusPreempting means the goal received a cancel request after it started executing and has not yet completed execution
+	GoalStatusPreempting uint8 = 6
+	//GoalStatusRecalling means the goal received a cancel request before it started executing, but the action server has not yet confirmed that the goal is canceled
+	GoalStatusRecalling uint8 = 7
+	//GoalStatusRecalled means the goal received a cancel request before it started executing and was successfully cancelled
+	GoalStatusRecalled uint8 = 8
+	//GoalStatusLost is used by an action client to indicate that a goal is no longer tracked by the action server
+	GoalStatusLost uint8 = 9
+)
+
+var goalStatusNames = map[uint8]string{
+	GoalStatusPending:    "PENDING",
+	GoalStatusActive:     "ACTIVE",
+	GoalStatusPreempted:  "PREEMPTED",
+	GoalStatusSucceeded:  "SUCCEEDED",
+	GoalStatusAborted:    "ABORTED",
+	GoalStatusRejected:   "REJECTED",
+	GoalStatusPreempting: "PREEMPTING",
+	GoalStatusRecalling:  "RECALLING",
+	GoalStatusRecalled:   "RECALLED",
+	GoalStatusLost:       "LOST",
+}
+
+// GoalStatusName returns the actionlib name of a goal state, e.g. "ACTIVE".
+func GoalStatusName(status uint8) string {
+	if name, ok := goalStatusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", status)
+}
+
+func isTerminalGoalStatus(status uint8) bool {
+	switch status {
+	case GoalStatusPreempted, GoalStatusSucceeded, GoalStatusAborted,
+		GoalStatusRejected, GoalStatusRecalled, GoalStatusLost:
+		return true
+	}
+	return false
+}
+
+// GoalID identifies a goal sent to an action server; it is the Go representation of actionlib_msgs/GoalID.
+type GoalID struct {
+	Stamp Time
+	ID    string
+}
+
+// GoalStatus is the state of a goal tracked by an action server; it is the Go representation of actionlib_msgs/GoalStatus.
+type GoalStatus struct {
+	GoalID GoalID
+	Status uint8
+	Text   string
+}
+
+// ActionType describes the messages which make up a ROS action.  An action named "pkg/Foo" is carried by the
+// messages pkg/FooGoal, pkg/FooResult and pkg/FooFeedback, which are wrapped by pkg/FooActionGoal,
+// pkg/FooActionResult and pkg/FooActionFeedback on the wire.
+type ActionType interface {
+	// Name returns the full name of the action message, e.g. "actionlib_tutorials/FibonacciAction".
+	Name() string
+	MD5Sum() string
+	GoalType() MessageType
+	FeedbackType() MessageType
+	ResultType() MessageType
+	ActionGoalType() MessageType
+	ActionFeedbackType() MessageType
+	ActionResultType() MessageType
+}
+
+type defaultActionType struct {
+	action         MessageType
+	goal           MessageType
+	feedback       MessageType
+	result         MessageType
+	actionGoal     MessageType
+	actionFeedback MessageType
+	actionResult   MessageType
+}
+
+// NewActionType builds an ActionType out of the seven message types of an action, such as those generated by gengo.
+func NewActionType(action, goal, feedback, result, actionGoal, actionFeedback, actionResult MessageType) ActionType {
+	return &defaultActionType{action, goal, feedback, result, actionGoal, actionFeedback, actionResult}
+}
+
+// NewDynamicActionType builds an ActionType for typeName from the ROS message definitions available at runtime,
+// as DynamicMessageType does for messages.  typeName should be a fully qualified action name such as
+// "actionlib_tutorials/Fibonacci"; the "Action" suffix is optional.
+func NewDynamicActionType(typeName string) (ActionType, error) {
+	baseName := strings.TrimSuffix(typeName, "Action")
+	// If the .action file is available, the seven messages of the action are derived from it.  Otherwise they
+	// must have been generated by catkin, and are looked up as ordinary messages.
+	if err := ensureContext(); err != nil {
+		return nil, err
+	}
+	_, loadErr := msgContext.LoadAction(baseName)
+	suffixes := []string{"Action", "Goal", "Feedback", "Result", "ActionGoal", "ActionFeedback", "ActionResult"}
+	types := make([]MessageType, len(suffixes))
+	for i, suffix := range suffixes {
+		t, err := NewDynamicMessageType(baseName + suffix)
+		if err != nil {
+			if loadErr != nil {
+				return nil, fmt.Errorf("failed to load action %s: %v; %v", baseName, loadErr, err)
+			}
+			return nil, err
+		}
+		types[i] = t
+	}
+	return NewActionType(types[0], types[1], types[2], types[3], types[4], types[5], types[6]), nil
+}
+
+func (t *defaultActionType) Name() string                    { return t.action.Name() }
+func (t *defaultActionType) MD5Sum() string                  { return t.action.MD5Sum() }
+func (t *defaultActionType) GoalType() MessageType           { return t.goal }
+func (t *defaultActionType) FeedbackType() MessageType       { return t.feedback }
+func (t *defaultActionType) ResultType() MessageType         { return t.result }
+func (t *defaultActionType) ActionGoalType() MessageType     { return t.actionGoal }
+func (t *defaultActionType) ActionFeedbackType() MessageType { return t.actionFeedback }
+func (t *defaultActionType) ActionResultType() MessageType   { return t.actionResult }
+
+var goalIDCounter uint64
+
+// newGoalID generates a goal id which is unique for this process, following the format used by actionlib.
+func newGoalID(nodeName string, stamp Time) GoalID {
+	count := atomic.AddUint64(&goalIDCounter, 1)
+	return GoalID{stamp, fmt.Sprintf("%s-%d-%d.%09d", nodeName, count, stamp.Sec, stamp.NSec)}
+}
+
+func (g *GoalID) serialize(buf *bytes.Buffer) {
+	writeTime(buf, g.Stamp)
+	writeString(buf, g.ID)
+}
+
+func (g *GoalID) deserialize(buf *bytes.Reader) error {
+	var err error
+	if g.Stamp, err = readTime(buf); err != nil {
+		return err
+	}
+	g.ID, err = readString(buf)
+	return err
+}
+
+func (s *GoalStatus) serialize(buf *bytes.Buffer) {
+	s.GoalID.serialize(buf)
+	binary.Write(buf, binary.LittleEndian, s.Status)
+	writeString(buf, s.Text)
+}
+
+func (s *GoalStatus) deserialize(buf *bytes.Reader) error {
+	if err := s.GoalID.deserialize(buf); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.Status); err != nil {
+		return err
+	}
+	var err error
+	s.Text, err = readString(buf)
+	return err
+}
+
+// Message definitions of the actionlib_msgs types, which action servers and clients exchange on the
+// cancel and status topics.
+const (
+	goalIDText = `time stamp
+string id
+`
+	goalStatusText = `GoalID goal_id
+uint8 status
+uint8 PENDING         = 0
+uint8 ACTIVE          = 1
+uint8 PREEMPTED       = 2
+uint8 SUCCEEDED       = 3
+uint8 ABORTED         = 4
+uint8 REJECTED        = 5
+uint8 PREEMPTING      = 6
+uint8 RECALLING       = 7
+uint8 RECALLED        = 8
+uint8 LOST            = 9
+string text
+`
+	goalStatusArrayText = `Header header
+GoalStatus[] status_list
+`
+	messageDefinitionSeparator = "\n================================================================================\n"
+)
+
+// goalIDMessage is the actionlib_msgs/GoalID message published on the cancel topic.
+type goalIDMessage struct {
+	GoalID
+}
+
+var msgTypeGoalID = &builtinMessageType{
+	name:       "actionlib_msgs/GoalID",
+	text:       goalIDText,
+	md5sum:     "302881f31927c1df708a2dbab0e80ee8",
+	newMessage: func() Message { return new(goalIDMessage) },
+}
+
+func (m *goalIDMessage) Type() MessageType {
+	return msgTypeGoalID
+}
+
+func (m *goalIDMessage) Serialize(buf *bytes.Buffer) error {
+	m.GoalID.serialize(buf)
+	return nil
+}
+
+func (m *goalIDMessage) Deserialize(buf *bytes.Reader) error {
+	return m.GoalID.deserialize(buf)
+}
+
+// goalStatusArrayMessage is the actionlib_msgs/GoalStatusArray message published on the status topic.
+type goalStatusArrayMessage struct {
+	Header     msgHeader
+	StatusList []GoalStatus
+}
+
+var msgTypeGoalStatusArray = &builtinMessageType{
+	name: "actionlib_msgs/GoalStatusArray",
+	text: goalStatusArrayText +
+		messageDefinitionSeparator + "MSG: std_msgs/Header\n" + msgHeaderText +
+		messageDefinitionSeparator + "MSG: actionlib_msgs/GoalStatus\n" + goalStatusText +
+		messageDefinitionSeparator + "MSG: actionlib_msgs/GoalID\n" + goalIDText,
+	md5sum:     "8b2b82f13216d0a8ea88bd3af735e619",
+	newMessage: func() Message { return new(goalStatusArrayMessage) },
+}
+
+func (m *goalStatusArrayMessage) Type() MessageType {
+	return msgTypeGoalStatusArray
+}
+
+func (m *goalStatusArrayMessage) Serialize(buf *bytes.Buffer) error {
+	m.Header.serialize(buf)
+	binary.Write(buf, binary.LittleEndian, uint32(len(m.StatusList)))
+	for i := range m.StatusList {
+		m.StatusList[i].serialize(buf)
+	}
+	return nil
+}
+
+func (m *goalStatusArrayMessage) Deserialize(buf *bytes.Reader) error {
+	if err := m.Header.deserialize(buf); err != nil {
+		return err
+	}
+	var size uint32
+	if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+		return err
+	}
+	m.StatusList = make([]GoalStatus, 0, size)
+	for i := 0; i < int(size); i++ {
+		var s GoalStatus
+		if err := s.deserialize(buf); err != nil {
+			return err
+		}
+		m.StatusList = append(m.StatusList, s)
+	}
+	return nil
+}
+
+// actionEnvelopeType wraps a goal, feedback or result message type of an action into the
+// corresponding ActionGoal, ActionFeedback or ActionResult message sent on the wire.  The wire
+// type only provides the name, text and md5sum, so that this works the same for generated
+// and dynamic message types.
+type actionEnvelopeType struct {
+	wireType    MessageType
+	payloadType MessageType
+	withStatus  bool // Feedback and result envelopes carry a GoalStatus, goal envelopes only a GoalID.
+}
+
+func newActionGoalType(actionType ActionType) *actionEnvelopeType {
+	return &actionEnvelopeType{actionType.ActionGoalType(), actionType.GoalType(), false}
+}
+
+func newActionFeedbackType(actionType ActionType) *actionEnvelopeType {
+	return &actionEnvelopeType{actionType.ActionFeedbackType(), actionType.FeedbackType(), true}
+}
+
+func newActionResultType(actionType ActionType) *actionEnvelopeType {
+	return &actionEnvelopeType{actionType.ActionResultType(), actionType.ResultType(), true}
+}
+
+func (t *actionEnvelopeType) Text() string   { return t.wireType.Text() }
+func (t *actionEnvelopeType) MD5Sum() string { return t.wireType.MD5Sum() }
+func (t *actionEnvelopeType) Name() string   { return t.wireType.Name() }
+
+func (t *actionEnvelopeType) NewMessage() Message {
+	return &actionEnvelope{envelopeType: t}
+}
+
+// actionEnvelope is an ActionGoal, ActionFeedback or ActionResult message.
+type actionEnvelope struct {
+	envelopeType *actionEnvelopeType
+	Header       msgHeader
+	GoalID       GoalID     // Only used by goal envelopes
+	Status       GoalStatus // Only used by feedback and result envelopes
+	Payload      Message
+}
+
+func (m *actionEnvelope) Type() MessageType {
+	return m.envelopeType
+}
+
+func (m *actionEnvelope) Serialize(buf *bytes.Buffer) error {
+	m.Header.serialize(buf)
+	if m.envelopeType.withStatus {
+		m.Status.serialize(buf)
+	} else {
+		m.GoalID.serialize(buf)
+	}
+	payload := m.Payload
+	if payload == nil {
+		payload = m.envelopeType.payloadType.NewMessage()
+	}
+	return payload.Serialize(buf)
+}
+
+func (m *actionEnvelope) Deserialize(buf *bytes.Reader) error {
+	if err := m.Header.deserialize(buf); err != nil {
+		return err
+	}
+	if m.envelopeType.withStatus {
+		if err := m.Status.deserialize(buf); err != nil {
+			return err
+		}
+	} else {
+		if err := m.GoalID.deserialize(buf); err != nil {
+			return err
+		}
+	}
+	m.Payload = m.envelopeType.payloadType.NewMessage()
+	if m.Payload == nil {
+		return fmt.Errorf("failed to instantiate %s", m.envelopeType.payloadType.Name())
+	}
+	return m.Payload.Deserialize(buf)
+}
@@ -1 +1,500 @@
 package ros
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CommState is the state of a goal as tracked by an action client, following the actionlib client state machine.
+type CommState uint8
+
+const (
+	//CommStateWaitingForGoalAck means the goal was sent, but the server has not reported it yet
+	CommStateWaitingForGoalAck CommState = iota
+	//CommStatePending means the server received the goal but has not started processing it
+	CommStatePending
+	//CommStateActive means the server is processing the goal
+	CommStateActive
+	//CommStateWaitingForResult means the server reported a terminal state and the result is on its way
+	CommStateWaitingForResult
+	//CommStateWaitingForCancelAck means a cancel request was sent, but the server has not reported it yet
+	CommStateWaitingForCancelAck
+	//CommStateRecalling means the server is cancelling a goal it had not started processing
+	CommStateRecalling
+	//CommStatePreempting means the server is cancelling a goal it was processing
+	CommStatePreempting
+	//CommStateDone means the result was received, or the goal was lost
+	CommStateDone
+)
+
+var commStateNames = map[CommState]string{
+	CommStateWaitingForGoalAck:   "WAITING_FOR_GOAL_ACK",
+	CommStatePending:             "PENDING",
+	CommStateActive:              "ACTIVE",
+	CommStateWaitingForResult:    "WAITING_FOR_RESULT",
+	CommStateWaitingForCancelAck: "WAITING_FOR_CANCEL_ACK",
+	CommStateRecalling:           "RECALLING",
+	CommStatePreempting:          "PREEMPTING",
+	CommStateDone:                "DONE",
+}
+
+func (s CommState) String() string {
+	if name, ok := commStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint8(s))
+}
+
+// commStateInvalid marks a goal status which the server must not report in a given comm state.
+const commStateInvalid CommState = 255
+
+var invalidCommTransition = []CommState{commStateInvalid}
+
+// commStateTransitions maps the comm state of a goal and the status reported by the server to the sequence of
+// comm states the goal goes through.  A missing entry means no transition; invalidCommTransition means the
+// server reported a status which is not possible from the comm state.
+var commStateTransitions = map[CommState]map[uint8][]CommState{
+	CommStateWaitingForGoalAck: {
+		GoalStatusPending:    {CommStatePending},
+		GoalStatusActive:     {CommStateActive},
+		GoalStatusRejected:   {CommStatePending, CommStateWaitingForResult},
+		GoalStatusRecalling:  {CommStatePending, CommStateRecalling},
+		GoalStatusRecalled:   {CommStatePending, CommStateWaitingForResult},
+		GoalStatusPreempted:  {CommStateActive, CommStatePreempting, CommStateWaitingForResult},
+		GoalStatusSucceeded:  {CommStateActive, CommStateWaitingForResult},
+		GoalStatusAborted:    {CommStateActive, CommStateWaitingForResult},
+		GoalStatusPreempting: {CommStateActive, CommStatePreempting},
+	},
+	CommStatePending: {
+		GoalStatusActive:     {CommStateActive},
+		GoalStatusRejected:   {CommStateWaitingForResult},
+		GoalStatusRecalling:  {CommStateRecalling},
+		GoalStatusRecalled:   {CommStateRecalling, CommStateWaitingForResult},
+		GoalStatusPreempted:  {CommStateActive, CommStatePreempting, CommStateWaitingForResult},
+		GoalStatusSucceeded:  {CommStateActive, CommStateWaitingForResult},
+		GoalStatusAborted:    {CommStateActive, CommStateWaitingForResult},
+		GoalStatusPreempting: {CommStateActive, CommStatePreempting},
+	},
+	CommStateActive: {
+		GoalStatusPending:    invalidCommTransition,
+		GoalStatusRejected:   invalidCommTransition,
+		GoalStatusRecalling:  invalidCommTransition,
+		GoalStatusRecalled:   invalidCommTransition,
+		GoalStatusPreempted:  {CommStatePreempting, CommStateWaitingForResult},
+		GoalStatusSucceeded:  {CommStateWaitingForResult},
+		GoalStatusAborted:    {CommStateWaitingForResult},
+		GoalStatusPreempting: {CommStatePreempting},
+	},
+	CommStateWaitingForResult: {
+		GoalStatusPending:    invalidCommTransition,
+		GoalStatusRecalling:  invalidCommTransition,
+		GoalStatusPreempting: invalidCommTransition,
+	},
+	CommStateWaitingForCancelAck: {
+		GoalStatusRejected:   {CommStateWaitingForResult},
+		GoalStatusRecalling:  {CommStateRecalling},
+		GoalStatusRecalled:   {CommStateRecalling, CommStateWaitingForResult},
+		GoalStatusPreempted:  {CommStatePreempting, CommStateWaitingForResult},
+		GoalStatusSucceeded:  {CommStatePreempting, CommStateWaitingForResult},
+		GoalStatusAborted:    {CommStatePreempting, CommStateWaitingForResult},
+		GoalStatusPreempting: {CommStatePreempting},
+	},
+	CommStateRecalling: {
+		GoalStatusPending:    invalidCommTransition,
+		GoalStatusActive:     invalidCommTransition,
+		GoalStatusRejected:   {CommStateWaitingForResult},
+		GoalStatusRecalled:   {CommStateWaitingForResult},
+		GoalStatusPreempted:  {CommStatePreempting, CommStateWaitingForResult},
+		GoalStatusSucceeded:  {CommStatePreempting, CommStateWaitingForResult},
+		GoalStatusAborted:    {CommStatePreempting, CommStateWaitingForResult},
+		GoalStatusPreempting: {CommStatePreempting},
+	},
+	CommStatePreempting: {
+		GoalStatusPending:   invalidCommTransition,
+		GoalStatusActive:    invalidCommTransition,
+		GoalStatusRejected:  invalidCommTransition,
+		GoalStatusRecalling: invalidCommTransition,
+		GoalStatusRecalled:  invalidCommTransition,
+		GoalStatusPreempted: {CommStateWaitingForResult},
+		GoalStatusSucceeded: {CommStateWaitingForResult},
+		GoalStatusAborted:   {CommStateWaitingForResult},
+	},
+	CommStateDone: {
+		GoalStatusPending:    invalidCommTransition,
+		GoalStatusActive:     invalidCommTransition,
+		GoalStatusRecalling:  invalidCommTransition,
+		GoalStatusPreempting: invalidCommTransition,
+	},
+}
+
+// nextCommStates returns the comm states a goal in state goes through when the server reports status.
+func nextCommStates(state CommState, status uint8) ([]CommState, error) {
+	next := commStateTransitions[state][status]
+	if len(next) == 1 && next[0] == commStateInvalid {
+		return nil, fmt.Errorf("invalid goal status %s in comm state %s", GoalStatusName(status), state)
+	}
+	return next, nil
+}
+
+type defaultActionClient struct {
+	node              Node
+	action            string
+	actionType        ActionType
+	goalType          *actionEnvelopeType
+	feedbackType      *actionEnvelopeType
+	resultType        *actionEnvelopeType
+	goalPub           Publisher
+	cancelPub         Publisher
+	statusSub         Subscriber
+	feedbackSub       Subscriber
+	resultSub         Subscriber
+	handles           map[string]*clientGoalHandle
+	mutex             sync.Mutex
+	statusCallerID    string
+	goalSubscribers   map[string]bool
+	cancelSubscribers map[string]bool
+	shutdownOnce      sync.Once
+}
+
+// NewActionClient creates an action client for the action server with namespace action, e.g. "/move_base".
+// Status, feedback and result messages are processed while the node spins.
+func NewActionClient(node Node, action string, actionType ActionType) (ActionClient, error) {
+	logger := *node.Logger()
+	c := new(defaultActionClient)
+	c.node = node
+	c.action = action
+	c.actionType = actionType
+	c.goalType = newActionGoalType(actionType)
+	c.feedbackType = newActionFeedbackType(actionType)
+	c.resultType = newActionResultType(actionType)
+	c.handles = make(map[string]*clientGoalHandle)
+	c.goalSubscribers = make(map[string]bool)
+	c.cancelSubscribers = make(map[string]bool)
+
+	var err error
+	if c.goalPub, err = node.NewPublisherWithCallbacks(action+"/goal", c.goalType,
+		c.subscriberTracker(c.goalSubscribers, true), c.subscriberTracker(c.goalSubscribers, false)); err != nil {
+		logger.Errorf("action client %s failed to advertise goal : %v", action, err)
+		c.Shutdown()
+		return nil, err
+	}
+	if c.cancelPub, err = node.NewPublisherWithCallbacks(action+"/cancel", msgTypeGoalID,
+		c.subscriberTracker(c.cancelSubscribers, true), c.subscriberTracker(c.cancelSubscribers, false)); err != nil {
+		logger.Errorf("action client %s failed to advertise cancel : %v", action, err)
+		c.Shutdown()
+		return nil, err
+	}
+	if c.statusSub, err = node.NewSubscriber(action+"/status", msgTypeGoalStatusArray, c.onStatus); err != nil {
+		logger.Errorf("action client %s failed to subscribe status : %v", action, err)
+		c.Shutdown()
+		return nil, err
+	}
+	if c.feedbackSub, err = node.NewSubscriber(action+"/feedback", c.feedbackType, c.onFeedback); err != nil {
+		logger.Errorf("action client %s failed to subscribe feedback : %v", action, err)
+		c.Shutdown()
+		return nil, err
+	}
+	if c.resultSub, err = node.NewSubscriber(action+"/result", c.resultType, c.onResult); err != nil {
+		logger.Errorf("action client %s failed to subscribe result : %v", action, err)
+		c.Shutdown()
+		return nil, err
+	}
+	return c, nil
+}
+
+// subscriberTracker returns a publisher callback which records the caller ids of connected subscribers.
+func (c *defaultActionClient) subscriberTracker(subscribers map[string]bool, connected bool) func(SingleSubscriberPublisher) {
+	return func(ssp SingleSubscriberPublisher) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if connected {
+			subscribers[ssp.GetSubscriberName()] = true
+		} else {
+			delete(subscribers, ssp.GetSubscriberName())
+		}
+	}
+}
+
+func (c *defaultActionClient) Shutdown() {
+	c.shutdownOnce.Do(func() {
+		if c.goalPub != nil {
+			c.node.RemovePublisher(c.action + "/goal")
+		}
+		if c.cancelPub != nil {
+			c.node.RemovePublisher(c.action + "/cancel")
+		}
+		if c.statusSub != nil {
+			c.node.RemoveSubscriber(c.action + "/status")
+		}
+		if c.feedbackSub != nil {
+			c.node.RemoveSubscriber(c.action + "/feedback")
+		}
+		if c.resultSub != nil {
+			c.node.RemoveSubscriber(c.action + "/result")
+		}
+		c.mutex.Lock()
+		for _, h := range c.handles {
+			h.active = false
+		}
+		c.handles = make(map[string]*clientGoalHandle)
+		c.mutex.Unlock()
+	})
+}
+
+// isServerConnected reports whether a single action server is connected to all five topics of the action.
+func (c *defaultActionClient) isServerConnected() bool {
+	c.mutex.Lock()
+	server := c.statusCallerID
+	connected := server != "" && c.goalSubscribers[server] && c.cancelSubscribers[server]
+	c.mutex.Unlock()
+	return connected && c.feedbackSub.GetNumPublishers() > 0 && c.resultSub.GetNumPublishers() > 0
+}
+
+// WaitForServer blocks until an action server is connected, or until timeout elapses.  A zero timeout waits
+// forever.  The status of the server is only received while the node spins, so the node must be spinning
+// in another goroutine.
+func (c *defaultActionClient) WaitForServer(timeout Duration) bool {
+	var deadline <-chan time.Time
+	if !timeout.IsZero() {
+		deadline = time.After(time.Duration(timeout.ToNSec()))
+	}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for c.node.OK() {
+		if c.isServerConnected() {
+			return true
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return false
+		}
+	}
+	return false
+}
+
+func (c *defaultActionClient) SendGoal(goal Message, transitionCallback func(ClientGoalHandle), feedbackCallback func(ClientGoalHandle, Message)) (ClientGoalHandle, error) {
+	now := Now()
+	msg := c.goalType.NewMessage().(*actionEnvelope)
+	msg.Header.Stamp = now
+	msg.GoalID = newGoalID(c.node.QualifiedName(), now)
+	msg.Payload = goal
+
+	h := &clientGoalHandle{
+		client:             c,
+		goal:               msg,
+		state:              CommStateWaitingForGoalAck,
+		transitionCallback: transitionCallback,
+		feedbackCallback:   feedbackCallback,
+		active:             true,
+	}
+	h.status.GoalID = msg.GoalID
+	h.status.Status = GoalStatusPending
+	c.mutex.Lock()
+	c.handles[msg.GoalID.ID] = h
+	c.mutex.Unlock()
+
+	c.goalPub.Publish(msg)
+	return h, nil
+}
+
+func (c *defaultActionClient) CancelAllGoals() {
+	c.cancelPub.Publish(&goalIDMessage{})
+}
+
+func (c *defaultActionClient) CancelAllGoalsBeforeTime(stamp Time) {
+	c.cancelPub.Publish(&goalIDMessage{GoalID{Stamp: stamp}})
+}
+
+// commTransition is a comm state change to be reported to the transition callback of a goal handle.
+type commTransition struct {
+	handle *clientGoalHandle
+	state  CommState
+}
+
+// fireTransitions calls the transition callbacks; it must be called without holding the client mutex.  Each
+// callback is given the comm state of its transition, although a status update may have moved the handle on
+// through several states already.
+func fireTransitions(transitions []commTransition) {
+	for _, t := range transitions {
+		if t.handle.transitionCallback != nil {
+			t.handle.transitionCallback(&transitionGoalHandle{t.handle, t.state})
+		}
+	}
+}
+
+// transitionGoalHandle is the goal handle passed to a transition callback, whose comm state is the state of
+// the transition.
+type transitionGoalHandle struct {
+	*clientGoalHandle
+	state CommState
+}
+
+func (h *transitionGoalHandle) GetCommState() CommState {
+	return h.state
+}
+
+func (h *transitionGoalHandle) GetTerminalState() (uint8, error) {
+	if h.state != CommStateDone {
+		return 0, fmt.Errorf("goal %s is not done, its comm state is %s", h.goal.GoalID.ID, h.state)
+	}
+	return h.clientGoalHandle.GetTerminalState()
+}
+
+// updateStatus feeds a status reported by the server into the comm state machine of h, which must be locked.
+func (c *defaultActionClient) updateStatus(h *clientGoalHandle, status GoalStatus, transitions []commTransition) []commTransition {
+	if h.state == CommStateDone {
+		return transitions
+	}
+	h.status = status
+	next, err := nextCommStates(h.state, status.Status)
+	if err != nil {
+		logger := *c.node.Logger()
+		logger.Errorf("action client %s goal %s: %v", c.action, status.GoalID.ID, err)
+		return transitions
+	}
+	for _, state := range next {
+		h.state = state
+		transitions = append(transitions, commTransition{h, state})
+	}
+	return transitions
+}
+
+func (c *defaultActionClient) onStatus(msg Message, event MessageEvent) {
+	statusArray, ok := msg.(*goalStatusArrayMessage)
+	if !ok {
+		return
+	}
+	var transitions []commTransition
+	c.mutex.Lock()
+	c.statusCallerID = event.PublisherName
+	statuses := make(map[string]GoalStatus, len(statusArray.StatusList))
+	for _, s := range statusArray.StatusList {
+		statuses[s.GoalID.ID] = s
+	}
+	for id, h := range c.handles {
+		if status, ok := statuses[id]; ok {
+			transitions = c.updateStatus(h, status, transitions)
+		} else if h.state != CommStateWaitingForGoalAck && h.state != CommStateWaitingForResult && h.state != CommStateDone {
+			// The server forgot about our goal before sending its result.
+			h.status.Status = GoalStatusLost
+			h.state = CommStateDone
+			h.active = false
+			delete(c.handles, id)
+			transitions = append(transitions, commTransition{h, CommStateDone})
+		}
+	}
+	c.mutex.Unlock()
+	fireTransitions(transitions)
+}
+
+func (c *defaultActionClient) onFeedback(msg Message) {
+	feedback, ok := msg.(*actionEnvelope)
+	if !ok {
+		return
+	}
+	c.mutex.Lock()
+	h, ok := c.handles[feedback.Status.GoalID.ID]
+	c.mutex.Unlock()
+	if ok && h.feedbackCallback != nil {
+		h.feedbackCallback(h, feedback.Payload)
+	}
+}
+
+func (c *defaultActionClient) onResult(msg Message) {
+	result, ok := msg.(*actionEnvelope)
+	if !ok {
+		return
+	}
+	var transitions []commTransition
+	c.mutex.Lock()
+	if h, ok := c.handles[result.Status.GoalID.ID]; ok && h.state != CommStateDone {
+		transitions = c.updateStatus(h, result.Status, transitions)
+		h.status = result.Status
+		h.result = result.Payload
+		h.state = CommStateDone
+		h.active = false
+		delete(c.handles, result.Status.GoalID.ID)
+		transitions = append(transitions, commTransition{h, CommStateDone})
+	}
+	c.mutex.Unlock()
+	fireTransitions(transitions)
+}
+
+// clientGoalHandle implements ClientGoalHandle.  Its state is guarded by the mutex of its client.
+type clientGoalHandle struct {
+	client             *defaultActionClient
+	goal               *actionEnvelope
+	state              CommState
+	status             GoalStatus
+	result             Message
+	transitionCallback func(ClientGoalHandle)
+	feedbackCallback   func(ClientGoalHandle, Message)
+	active             bool
+}
+
+func (h *clientGoalHandle) GetGoalID() GoalID {
+	return h.goal.GoalID
+}
+
+func (h *clientGoalHandle) GetCommState() CommState {
+	h.client.mutex.Lock()
+	defer h.client.mutex.Unlock()
+	return h.state
+}
+
+func (h *clientGoalHandle) GetGoalStatus() GoalStatus {
+	h.client.mutex.Lock()
+	defer h.client.mutex.Unlock()
+	return h.status
+}
+
+func (h *clientGoalHandle) GetResult() Message {
+	h.client.mutex.Lock()
+	defer h.client.mutex.Unlock()
+	return h.result
+}
+
+func (h *clientGoalHandle) GetTerminalState() (uint8, error) {
+	h.client.mutex.Lock()
+	defer h.client.mutex.Unlock()
+	if h.state != CommStateDone {
+		return 0, fmt.Errorf("goal %s is not done, its comm state is %s", h.goal.GoalID.ID, h.state)
+	}
+	return h.status.Status, nil
+}
+
+func (h *clientGoalHandle) Cancel() error {
+	c := h.client
+	c.mutex.Lock()
+	if !h.active {
+		c.mutex.Unlock()
+		return fmt.Errorf("goal handle %s is no longer tracked by its action client", h.goal.GoalID.ID)
+	}
+	switch h.state {
+	case CommStateWaitingForGoalAck, CommStatePending, CommStateActive, CommStateWaitingForCancelAck:
+	default:
+		// The server already knows that the goal is being cancelled, or has finished it.
+		c.mutex.Unlock()
+		return nil
+	}
+	h.state = CommStateWaitingForCancelAck
+	c.mutex.Unlock()
+
+	c.cancelPub.Publish(&goalIDMessage{GoalID{ID: h.goal.GoalID.ID}})
+	fireTransitions([]commTransition{{h, CommStateWaitingForCancelAck}})
+	return nil
+}
+
+func (h *clientGoalHandle) Resend() error {
+	c := h.client
+	c.mutex.Lock()
+	active := h.active
+	c.mutex.Unlock()
+	if !active {
+		return fmt.Errorf("goal handle %s is no longer tracked by its action client", h.goal.GoalID.ID)
+	}
+	c.goalPub.Publish(h.goal)
+	return nil
+}
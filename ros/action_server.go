@@ -1 +1,420 @@
 package ros
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Rate at which the action server publishes the status of its goals (Hz), as in actionlib.
+	defaultActionStatusFrequency = 5.0
+	// How long terminal goals stay on the status list before they are forgotten (sec), as in actionlib.
+	defaultActionStatusListTimeout = 5.0
+)
+
+// serverGoalEvent is an input to the goal state machine of an action server.
+type serverGoalEvent int
+
+const (
+	serverGoalAccept serverGoalEvent = iota
+	serverGoalReject
+	serverGoalCancelRequest
+	serverGoalCancel
+	serverGoalSucceed
+	serverGoalAbort
+)
+
+func (e serverGoalEvent) String() string {
+	switch e {
+	case serverGoalAccept:
+		return "accept"
+	case serverGoalReject:
+		return "reject"
+	case serverGoalCancelRequest:
+		return "request cancel of"
+	case serverGoalCancel:
+		return "cancel"
+	case serverGoalSucceed:
+		return "succeed"
+	case serverGoalAbort:
+		return "abort"
+	}
+	return "unknown event for"
+}
+
+// serverGoalTransitions is the goal state machine of the actionlib protocol, as seen by the server.
+var serverGoalTransitions = map[uint8]map[serverGoalEvent]uint8{
+	GoalStatusPending: {
+		serverGoalAccept:        GoalStatusActive,
+		serverGoalReject:        GoalStatusRejected,
+		serverGoalCancelRequest: GoalStatusRecalling,
+		serverGoalCancel:        GoalStatusRecalled,
+	},
+	GoalStatusRecalling: {
+		serverGoalAccept: GoalStatusPreempting,
+		serverGoalReject: GoalStatusRejected,
+		serverGoalCancel: GoalStatusRecalled,
+	},
+	GoalStatusActive: {
+		serverGoalCancelRequest: GoalStatusPreempting,
+		serverGoalCancel:        GoalStatusPreempted,
+		serverGoalSucceed:       GoalStatusSucceeded,
+		serverGoalAbort:         GoalStatusAborted,
+	},
+	GoalStatusPreempting: {
+		serverGoalCancel:  GoalStatusPreempted,
+		serverGoalSucceed: GoalStatusSucceeded,
+		serverGoalAbort:   GoalStatusAborted,
+	},
+}
+
+func nextServerGoalStatus(status uint8, event serverGoalEvent) (uint8, bool) {
+	next, ok := serverGoalTransitions[status][event]
+	return next, ok
+}
+
+type defaultActionServer struct {
+	node              Node
+	action            string
+	actionType        ActionType
+	goalCallback      func(ServerGoalHandle)
+	cancelCallback    func(ServerGoalHandle)
+	goalType          *actionEnvelopeType
+	feedbackType      *actionEnvelopeType
+	resultType        *actionEnvelopeType
+	goalSub           Subscriber
+	cancelSub         Subscriber
+	statusPub         Publisher
+	feedbackPub       Publisher
+	resultPub         Publisher
+	handles           map[string]*serverGoalHandle
+	handlesMutex      sync.Mutex
+	lastCancel        Time
+	statusListTimeout Duration
+	shutdownChan      chan struct{}
+	shutdownOnce      sync.Once
+}
+
+// NewActionServer creates an action server which serves goals of actionType in the namespace action,
+// e.g. "/fibonacci".  goalCallback is called with the handle of each new goal, and cancelCallback
+// with the handle of every goal that a client requested to cancel.  Both callbacks run on the node's
+// spin goroutine; the goal handle may be stored and completed later from any goroutine.
+func NewActionServer(node Node, action string, actionType ActionType, goalCallback, cancelCallback func(ServerGoalHandle)) (ActionServer, error) {
+	logger := *node.Logger()
+	s := new(defaultActionServer)
+	s.node = node
+	s.action = action
+	s.actionType = actionType
+	s.goalCallback = goalCallback
+	s.cancelCallback = cancelCallback
+	s.goalType = newActionGoalType(actionType)
+	s.feedbackType = newActionFeedbackType(actionType)
+	s.resultType = newActionResultType(actionType)
+	s.handles = make(map[string]*serverGoalHandle)
+	s.statusListTimeout.FromSec(defaultActionStatusListTimeout)
+	s.shutdownChan = make(chan struct{})
+
+	var err error
+	if s.statusPub, err = node.NewPublisher(action+"/status", msgTypeGoalStatusArray); err != nil {
+		logger.Errorf("action server %s failed to advertise status : %v", action, err)
+		s.shutdownEntities()
+		return nil, err
+	}
+	if s.feedbackPub, err = node.NewPublisher(action+"/feedback", s.feedbackType); err != nil {
+		logger.Errorf("action server %s failed to advertise feedback : %v", action, err)
+		s.shutdownEntities()
+		return nil, err
+	}
+	if s.resultPub, err = node.NewPublisher(action+"/result", s.resultType); err != nil {
+		logger.Errorf("action server %s failed to advertise result : %v", action, err)
+		s.shutdownEntities()
+		return nil, err
+	}
+	if s.goalSub, err = node.NewSubscriber(action+"/goal", s.goalType, s.onGoal); err != nil {
+		logger.Errorf("action server %s failed to subscribe goal : %v", action, err)
+		s.shutdownEntities()
+		return nil, err
+	}
+	if s.cancelSub, err = node.NewSubscriber(action+"/cancel", msgTypeGoalID, s.onCancel); err != nil {
+		logger.Errorf("action server %s failed to subscribe cancel : %v", action, err)
+		s.shutdownEntities()
+		return nil, err
+	}
+
+	go s.publishStatusLoop()
+	return s, nil
+}
+
+// Shutdown stops the action server and removes its publishers and subscribers.
+func (s *defaultActionServer) Shutdown() {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdownChan)
+		s.shutdownEntities()
+	})
+}
+
+func (s *defaultActionServer) shutdownEntities() {
+	if s.goalSub != nil {
+		s.node.RemoveSubscriber(s.action + "/goal")
+	}
+	if s.cancelSub != nil {
+		s.node.RemoveSubscriber(s.action + "/cancel")
+	}
+	if s.statusPub != nil {
+		s.node.RemovePublisher(s.action + "/status")
+	}
+	if s.feedbackPub != nil {
+		s.node.RemovePublisher(s.action + "/feedback")
+	}
+	if s.resultPub != nil {
+		s.node.RemovePublisher(s.action + "/result")
+	}
+}
+
+func (s *defaultActionServer) publishStatusLoop() {
+	period := time.Duration(float64(time.Second) / defaultActionStatusFrequency)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.publishStatus()
+		case <-s.shutdownChan:
+			return
+		}
+	}
+}
+
+// publishStatus publishes the status of all tracked goals, and forgets terminal goals which have
+// been on the status list for longer than statusListTimeout.
+func (s *defaultActionServer) publishStatus() {
+	now := Now()
+	msg := new(goalStatusArrayMessage)
+	msg.Header.Stamp = now
+	s.handlesMutex.Lock()
+	for id, h := range s.handles {
+		if !h.destructionTime.IsZero() {
+			expiry := h.destructionTime.Add(s.statusListTimeout)
+			if expiry.Cmp(now) < 0 {
+				delete(s.handles, id)
+				continue
+			}
+		}
+		msg.StatusList = append(msg.StatusList, h.status)
+	}
+	s.handlesMutex.Unlock()
+	if !s.isShutdown() {
+		s.statusPub.Publish(msg)
+	}
+}
+
+func (s *defaultActionServer) isShutdown() bool {
+	select {
+	case <-s.shutdownChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *defaultActionServer) publishResult(status GoalStatus, result Message) {
+	if s.isShutdown() {
+		return
+	}
+	msg := s.resultType.NewMessage().(*actionEnvelope)
+	msg.Header.Stamp = Now()
+	msg.Status = status
+	msg.Payload = result
+	s.resultPub.Publish(msg)
+	s.publishStatus()
+}
+
+func (s *defaultActionServer) publishFeedback(status GoalStatus, feedback Message) {
+	if s.isShutdown() {
+		return
+	}
+	msg := s.feedbackType.NewMessage().(*actionEnvelope)
+	msg.Header.Stamp = Now()
+	msg.Status = status
+	msg.Payload = feedback
+	s.feedbackPub.Publish(msg)
+}
+
+func (s *defaultActionServer) onGoal(msg Message) {
+	goal, ok := msg.(*actionEnvelope)
+	if !ok {
+		return
+	}
+	logger := *s.node.Logger()
+
+	s.handlesMutex.Lock()
+	if h, ok := s.handles[goal.GoalID.ID]; ok {
+		// We already know this goal, which happens when a cancel request arrives before the goal.
+		logger.Debugf("action server %s received known goal %s", s.action, goal.GoalID.ID)
+		var status GoalStatus
+		recalled := false
+		if h.status.Status == GoalStatusRecalling {
+			h.status.Status = GoalStatusRecalled
+			h.destructionTime = Now()
+			status = h.status
+			recalled = true
+		}
+		s.handlesMutex.Unlock()
+		if recalled {
+			s.publishResult(status, nil)
+		}
+		return
+	}
+
+	now := Now()
+	goalID := goal.GoalID
+	if goalID.ID == "" {
+		goalID = newGoalID(s.node.QualifiedName(), now)
+	}
+	if goalID.Stamp.IsZero() {
+		goalID.Stamp = now
+	}
+	h := &serverGoalHandle{server: s, goal: goal.Payload}
+	h.status.GoalID = goalID
+	h.status.Status = GoalStatusPending
+	s.handles[goalID.ID] = h
+	canceled := !goal.GoalID.Stamp.IsZero() && goal.GoalID.Stamp.Cmp(s.lastCancel) <= 0
+	s.handlesMutex.Unlock()
+
+	if canceled {
+		// The goal was sent before the last cancel-all request, so it is cancelled right away.
+		if err := h.SetCanceled(nil, "This goal handle was canceled by the action server because its timestamp is before the timestamp of the last cancel request"); err != nil {
+			logger.Error(err)
+		}
+		return
+	}
+	if s.goalCallback != nil {
+		s.goalCallback(h)
+	}
+}
+
+func (s *defaultActionServer) onCancel(msg Message) {
+	cancel, ok := msg.(*goalIDMessage)
+	if !ok {
+		return
+	}
+
+	var requested []*serverGoalHandle
+	found := false
+	s.handlesMutex.Lock()
+	cancelEverything := cancel.ID == "" && cancel.Stamp.IsZero()
+	for id, h := range s.handles {
+		cancelThis := cancel.ID == id
+		cancelBefore := !cancel.Stamp.IsZero() && h.status.GoalID.Stamp.Cmp(cancel.Stamp) <= 0
+		if !(cancelEverything || cancelThis || cancelBefore) {
+			continue
+		}
+		if cancelThis {
+			found = true
+		}
+		if next, ok := nextServerGoalStatus(h.status.Status, serverGoalCancelRequest); ok && h.goal != nil {
+			h.status.Status = next
+			requested = append(requested, h)
+		}
+	}
+	if cancel.ID != "" && !found {
+		// Remember the cancel request, so that the goal gets recalled if it arrives later.
+		h := &serverGoalHandle{server: s, destructionTime: Now()}
+		h.status.GoalID = cancel.GoalID
+		h.status.Status = GoalStatusRecalling
+		s.handles[cancel.ID] = h
+	}
+	if cancel.Stamp.Cmp(s.lastCancel) > 0 {
+		s.lastCancel = cancel.Stamp
+	}
+	s.handlesMutex.Unlock()
+
+	if len(requested) > 0 {
+		s.publishStatus()
+	}
+	if s.cancelCallback != nil {
+		for _, h := range requested {
+			s.cancelCallback(h)
+		}
+	}
+}
+
+// serverGoalHandle implements ServerGoalHandle.  Its status is guarded by the mutex of its server.
+type serverGoalHandle struct {
+	server          *defaultActionServer
+	goal            Message
+	status          GoalStatus
+	destructionTime Time // Time at which the goal reached a terminal state
+}
+
+func (h *serverGoalHandle) GetGoal() Message {
+	return h.goal
+}
+
+func (h *serverGoalHandle) GetGoalID() GoalID {
+	h.server.handlesMutex.Lock()
+	defer h.server.handlesMutex.Unlock()
+	return h.status.GoalID
+}
+
+func (h *serverGoalHandle) GetGoalStatus() GoalStatus {
+	h.server.handlesMutex.Lock()
+	defer h.server.handlesMutex.Unlock()
+	return h.status
+}
+
+// transition applies event to the goal and returns the new status.
+func (h *serverGoalHandle) transition(event serverGoalEvent, text string) (GoalStatus, error) {
+	s := h.server
+	s.handlesMutex.Lock()
+	defer s.handlesMutex.Unlock()
+	next, ok := nextServerGoalStatus(h.status.Status, event)
+	if !ok {
+		return h.status, fmt.Errorf("action server %s cannot %s goal %s in state %s",
+			s.action, event, h.status.GoalID.ID, GoalStatusName(h.status.Status))
+	}
+	h.status.Status = next
+	h.status.Text = text
+	if isTerminalGoalStatus(next) {
+		h.destructionTime = Now()
+	}
+	return h.status, nil
+}
+
+func (h *serverGoalHandle) SetAccepted(text string) error {
+	if _, err := h.transition(serverGoalAccept, text); err != nil {
+		return err
+	}
+	h.server.publishStatus()
+	return nil
+}
+
+func (h *serverGoalHandle) setTerminal(event serverGoalEvent, result Message, text string) error {
+	status, err := h.transition(event, text)
+	if err != nil {
+		return err
+	}
+	h.server.publishResult(status, result)
+	return nil
+}
+
+func (h *serverGoalHandle) SetRejected(result Message, text string) error {
+	return h.setTerminal(serverGoalReject, result, text)
+}
+
+func (h *serverGoalHandle) SetCanceled(result Message, text string) error {
+	return h.setTerminal(serverGoalCancel, result, text)
+}
+
+func (h *serverGoalHandle) SetSucceeded(result Message, text string) error {
+	return h.setTerminal(serverGoalSucceed, result, text)
+}
+
+func (h *serverGoalHandle) SetAborted(result Message, text string) error {
+	return h.setTerminal(serverGoalAbort, result, text)
+}
+
+func (h *serverGoalHandle) PublishFeedback(feedback Message) {
+	h.server.publishFeedback(h.GetGoalStatus(), feedback)
+}
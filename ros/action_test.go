@@ -0,0 +1,380 @@
+package ros
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edwinhayes/rosgo/libgengo"
+)
+
+func TestActionlibMsgsMD5(t *testing.T) {
+	ctx, err := libgengo.NewMsgContext([]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.LoadMsgFromString(msgHeaderText, "std_msgs/Header"); err != nil {
+		t.Fatal(err)
+	}
+	for _, msgType := range []*builtinMessageType{msgTypeGoalID, msgTypeGoalStatus(), msgTypeGoalStatusArray} {
+		text := msgType.Text()
+		// Only the definition of the message itself, not its dependencies.
+		if i := bytes.Index([]byte(text), []byte(messageDefinitionSeparator)); i >= 0 {
+			text = text[:i]
+		}
+		spec, err := ctx.LoadMsgFromString(text, msgType.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.MD5Sum != msgType.MD5Sum() {
+			t.Errorf("%s: expected md5sum %s, computed %s", msgType.Name(), msgType.MD5Sum(), spec.MD5Sum)
+		}
+	}
+}
+
+// msgTypeGoalStatus is only needed to compute the md5sum of actionlib_msgs/GoalStatusArray.
+func msgTypeGoalStatus() *builtinMessageType {
+	return &builtinMessageType{
+		name:   "actionlib_msgs/GoalStatus",
+		text:   goalStatusText,
+		md5sum: "d388f9b87b3c471f784434d671988d4a",
+	}
+}
+
+func TestGoalStatusArraySerialization(t *testing.T) {
+	msg := new(goalStatusArrayMessage)
+	msg.Header.Seq = 3
+	msg.Header.Stamp = NewTime(10, 20)
+	msg.StatusList = []GoalStatus{
+		{GoalID{NewTime(1, 2), "/node-1-1.000000002"}, GoalStatusActive, "working"},
+		{GoalID{NewTime(3, 4), "/node-2-3.000000004"}, GoalStatusRecalled, ""},
+	}
+	var buf bytes.Buffer
+	if err := msg.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	result := msgTypeGoalStatusArray.NewMessage().(*goalStatusArrayMessage)
+	if err := result.Deserialize(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if result.Header != msg.Header {
+		t.Errorf("header mismatch: %v != %v", result.Header, msg.Header)
+	}
+	if len(result.StatusList) != len(msg.StatusList) {
+		t.Fatalf("expected %d statuses, got %d", len(msg.StatusList), len(result.StatusList))
+	}
+	for i := range msg.StatusList {
+		if result.StatusList[i] != msg.StatusList[i] {
+			t.Errorf("status %d mismatch: %v != %v", i, result.StatusList[i], msg.StatusList[i])
+		}
+	}
+}
+
+func TestActionEnvelopeSerialization(t *testing.T) {
+	// actionlib_msgs/GoalID stands in for the payload type of the action.
+	wireType := &builtinMessageType{name: "test/TestActionResult", md5sum: "*"}
+	envelopeType := &actionEnvelopeType{wireType, msgTypeGoalID, true}
+
+	msg := envelopeType.NewMessage().(*actionEnvelope)
+	msg.Header.Stamp = NewTime(5, 6)
+	msg.Status = GoalStatus{GoalID{NewTime(1, 2), "goal"}, GoalStatusSucceeded, "done"}
+	msg.Payload = &goalIDMessage{GoalID{NewTime(7, 8), "payload"}}
+	var buf bytes.Buffer
+	if err := msg.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	result := envelopeType.NewMessage().(*actionEnvelope)
+	if err := result.Deserialize(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != msg.Status {
+		t.Errorf("status mismatch: %v != %v", result.Status, msg.Status)
+	}
+	if result.Payload.(*goalIDMessage).GoalID != msg.Payload.(*goalIDMessage).GoalID {
+		t.Errorf("payload mismatch: %v != %v", result.Payload, msg.Payload)
+	}
+	if result.Type().Name() != "test/TestActionResult" {
+		t.Error(result.Type().Name())
+	}
+}
+
+func TestServerGoalTransitions(t *testing.T) {
+	var tests = []struct {
+		status   uint8
+		event    serverGoalEvent
+		expected uint8
+		ok       bool
+	}{
+		{GoalStatusPending, serverGoalAccept, GoalStatusActive, true},
+		{GoalStatusPending, serverGoalReject, GoalStatusRejected, true},
+		{GoalStatusPending, serverGoalCancelRequest, GoalStatusRecalling, true},
+		{GoalStatusPending, serverGoalCancel, GoalStatusRecalled, true},
+		{GoalStatusPending, serverGoalSucceed, 0, false},
+		{GoalStatusRecalling, serverGoalAccept, GoalStatusPreempting, true},
+		{GoalStatusRecalling, serverGoalCancel, GoalStatusRecalled, true},
+		{GoalStatusRecalling, serverGoalAbort, 0, false},
+		{GoalStatusActive, serverGoalCancelRequest, GoalStatusPreempting, true},
+		{GoalStatusActive, serverGoalCancel, GoalStatusPreempted, true},
+		{GoalStatusActive, serverGoalSucceed, GoalStatusSucceeded, true},
+		{GoalStatusActive, serverGoalAbort, GoalStatusAborted, true},
+		{GoalStatusActive, serverGoalReject, 0, false},
+		{GoalStatusPreempting, serverGoalSucceed, GoalStatusSucceeded, true},
+		{GoalStatusPreempting, serverGoalCancel, GoalStatusPreempted, true},
+		{GoalStatusPreempting, serverGoalCancelRequest, 0, false},
+		{GoalStatusSucceeded, serverGoalAbort, 0, false},
+		{GoalStatusRecalled, serverGoalAccept, 0, false},
+	}
+	for _, test := range tests {
+		next, ok := nextServerGoalStatus(test.status, test.event)
+		if ok != test.ok || (ok && next != test.expected) {
+			t.Errorf("%s %s: expected (%s, %v), got (%s, %v)", test.event, GoalStatusName(test.status),
+				GoalStatusName(test.expected), test.ok, GoalStatusName(next), ok)
+		}
+	}
+}
+
+func TestNewGoalIDIsUnique(t *testing.T) {
+	stamp := NewTime(1, 2)
+	a := newGoalID("/node", stamp)
+	b := newGoalID("/node", stamp)
+	if a.ID == b.ID {
+		t.Errorf("goal ids are not unique: %s", a.ID)
+	}
+	if a.Stamp != stamp {
+		t.Error(a.Stamp)
+	}
+}
+
+func TestNextCommStates(t *testing.T) {
+	var tests = []struct {
+		state    CommState
+		status   uint8
+		expected []CommState
+		valid    bool
+	}{
+		{CommStateWaitingForGoalAck, GoalStatusPending, []CommState{CommStatePending}, true},
+		{CommStateWaitingForGoalAck, GoalStatusSucceeded, []CommState{CommStateActive, CommStateWaitingForResult}, true},
+		{CommStateWaitingForGoalAck, GoalStatusPreempted, []CommState{CommStateActive, CommStatePreempting, CommStateWaitingForResult}, true},
+		{CommStatePending, GoalStatusPending, nil, true},
+		{CommStatePending, GoalStatusRecalled, []CommState{CommStateRecalling, CommStateWaitingForResult}, true},
+		{CommStateActive, GoalStatusActive, nil, true},
+		{CommStateActive, GoalStatusPending, nil, false},
+		{CommStateActive, GoalStatusAborted, []CommState{CommStateWaitingForResult}, true},
+		{CommStateWaitingForCancelAck, GoalStatusActive, nil, true},
+		{CommStateWaitingForCancelAck, GoalStatusSucceeded, []CommState{CommStatePreempting, CommStateWaitingForResult}, true},
+		{CommStatePreempting, GoalStatusRecalled, nil, false},
+		{CommStateWaitingForResult, GoalStatusSucceeded, nil, true},
+		{CommStateDone, GoalStatusActive, nil, false},
+	}
+	for _, test := range tests {
+		next, err := nextCommStates(test.state, test.status)
+		if (err == nil) != test.valid {
+			t.Errorf("%s + %s: expected valid=%v, got error %v", test.state, GoalStatusName(test.status), test.valid, err)
+			continue
+		}
+		if len(next) != len(test.expected) {
+			t.Errorf("%s + %s: expected %v, got %v", test.state, GoalStatusName(test.status), test.expected, next)
+			continue
+		}
+		for i := range next {
+			if next[i] != test.expected[i] {
+				t.Errorf("%s + %s: expected %v, got %v", test.state, GoalStatusName(test.status), test.expected, next)
+				break
+			}
+		}
+	}
+}
+
+func TestClientTransitionStates(t *testing.T) {
+	c := &defaultActionClient{handles: make(map[string]*clientGoalHandle)}
+	var states []CommState
+	h := &clientGoalHandle{
+		client: c,
+		goal:   &actionEnvelope{GoalID: GoalID{ID: "goal"}},
+		state:  CommStateWaitingForGoalAck,
+		transitionCallback: func(handle ClientGoalHandle) {
+			states = append(states, handle.GetCommState())
+		},
+		active: true,
+	}
+	c.handles["goal"] = h
+
+	// A result without any status reports every state the goal went through.
+	c.onResult(&actionEnvelope{Status: GoalStatus{GoalID: GoalID{ID: "goal"}, Status: GoalStatusSucceeded}})
+	expected := []CommState{CommStateActive, CommStateWaitingForResult, CommStateDone}
+	if len(states) != len(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, states)
+	}
+	for i := range states {
+		if states[i] != expected[i] {
+			t.Fatalf("expected transitions %v, got %v", expected, states)
+		}
+	}
+	if err := h.Resend(); err == nil {
+		t.Error("expected a finished goal not to be resent")
+	}
+}
+
+// newTestActionType returns the action rosgo_tests/Test, whose goal, feedback and result are all
+// actionlib_msgs/GoalID.
+func newTestActionType() ActionType {
+	wireType := func(suffix string) MessageType {
+		return &builtinMessageType{name: "rosgo_tests/Test" + suffix, md5sum: "*"}
+	}
+	return NewActionType(wireType("Action"), msgTypeGoalID, msgTypeGoalID, msgTypeGoalID,
+		wireType("ActionGoal"), wireType("ActionFeedback"), wireType("ActionResult"))
+}
+
+// transitionRecorder records the comm states reported to the transition callback of a goal.
+type transitionRecorder struct {
+	mutex  sync.Mutex
+	states []CommState
+}
+
+func (r *transitionRecorder) callback(h ClientGoalHandle) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.states = append(r.states, h.GetCommState())
+}
+
+// expectSuffix checks that the last transitions are expected; earlier ones depend on when the status of the
+// server was received.
+func (r *transitionRecorder) expectSuffix(t *testing.T, goal string, expected ...CommState) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.states) >= len(expected) {
+		suffix := r.states[len(r.states)-len(expected):]
+		matches := true
+		for i := range expected {
+			matches = matches && suffix[i] == expected[i]
+		}
+		if matches {
+			return
+		}
+	}
+	t.Errorf("%s: expected transitions ending in %v, got %v", goal, expected, r.states)
+}
+
+func waitCommState(t *testing.T, h ClientGoalHandle, state CommState) {
+	deadline := time.Now().Add(5 * time.Second)
+	for h.GetCommState() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("goal %s: timed out waiting for %s, in %s", h.GetGoalID().ID, state, h.GetCommState())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestActionRoundTrip(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	serverNode := newTestNode(t, m, "/server")
+	defer serverNode.Shutdown()
+	go serverNode.Spin()
+	clientNode := newTestNode(t, m, "/client")
+	defer clientNode.Shutdown()
+	go clientNode.Spin()
+
+	// The server accepts every goal but "recall", and leaves the rest to the test.
+	goals := make(chan ServerGoalHandle, 3)
+	server, err := NewActionServer(serverNode, "/test_action", newTestActionType(), func(h ServerGoalHandle) {
+		if h.GetGoal().(*goalIDMessage).ID != "recall" {
+			if err := h.SetAccepted(""); err != nil {
+				t.Error(err)
+			}
+		}
+		goals <- h
+	}, func(h ServerGoalHandle) {
+		if err := h.SetCanceled(nil, "canceled"); err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Shutdown()
+	client, err := NewActionClient(clientNode, "/test_action", newTestActionType())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+	if !client.WaitForServer(NewDuration(5, 0)) {
+		t.Fatal("timed out waiting for the action server")
+	}
+
+	// A goal which succeeds, after sending feedback.
+	var work transitionRecorder
+	feedback := make(chan string, 1)
+	h, err := client.SendGoal(&goalIDMessage{GoalID{ID: "work"}}, work.callback, func(h ClientGoalHandle, msg Message) {
+		feedback <- msg.(*goalIDMessage).ID
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverGoal := <-goals
+	if id := serverGoal.GetGoal().(*goalIDMessage).ID; id != "work" || serverGoal.GetGoalID() != h.GetGoalID() {
+		t.Errorf("unexpected goal %q with id %v", id, serverGoal.GetGoalID())
+	}
+	serverGoal.PublishFeedback(&goalIDMessage{GoalID{ID: "progress"}})
+	select {
+	case id := <-feedback:
+		if id != "progress" {
+			t.Errorf("unexpected feedback %q", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for feedback")
+	}
+	if err := serverGoal.SetSucceeded(&goalIDMessage{GoalID{ID: "done"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+	waitCommState(t, h, CommStateDone)
+	if status, err := h.GetTerminalState(); err != nil || status != GoalStatusSucceeded {
+		t.Errorf("expected the goal to succeed, got %s, %v", GoalStatusName(status), err)
+	}
+	if result, ok := h.GetResult().(*goalIDMessage); !ok || result.ID != "done" {
+		t.Errorf("unexpected result %v", h.GetResult())
+	}
+	work.expectSuffix(t, "work", CommStateActive, CommStateWaitingForResult, CommStateDone)
+
+	// A goal which is cancelled while the server works on it.
+	var preempt transitionRecorder
+	h, err = client.SendGoal(&goalIDMessage{GoalID{ID: "preempt"}}, preempt.callback, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-goals
+	waitCommState(t, h, CommStateActive)
+	if err := h.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	waitCommState(t, h, CommStateDone)
+	if status, err := h.GetTerminalState(); err != nil || status != GoalStatusPreempted {
+		t.Errorf("expected the goal to be preempted, got %s, %v", GoalStatusName(status), err)
+	}
+	preempt.expectSuffix(t, "preempt", CommStateActive, CommStateWaitingForCancelAck, CommStatePreempting,
+		CommStateWaitingForResult, CommStateDone)
+
+	// A goal which is cancelled before the server starts on it.
+	var recall transitionRecorder
+	h, err = client.SendGoal(&goalIDMessage{GoalID{ID: "recall"}}, recall.callback, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-goals
+	waitCommState(t, h, CommStatePending)
+	if err := h.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	waitCommState(t, h, CommStateDone)
+	if status, err := h.GetTerminalState(); err != nil || status != GoalStatusRecalled {
+		t.Errorf("expected the goal to be recalled, got %s, %v", GoalStatusName(status), err)
+	}
+	recall.expectSuffix(t, "recall", CommStatePending, CommStateWaitingForCancelAck, CommStateRecalling,
+		CommStateWaitingForResult, CommStateDone)
+	if err := h.Resend(); err == nil {
+		t.Error("expected a recalled goal not to be resent")
+	}
+}
@@ -0,0 +1,164 @@
+package ros
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// callbackJob is a callback waiting in a CallbackQueue; jobs with the same owner, such as a subscriber or a
+// service server, are called in order, one at a time.
+type callbackJob struct {
+	owner interface{}
+	call  func()
+}
+
+// CallbackQueue holds the callbacks of subscribers and service servers until they are called by Spin,
+// SpinOnce or an AsyncSpinner.  Every node has a queue of its own; OptionSubscriberCallbackQueue and
+// OptionServiceCallbackQueue assign another.
+type CallbackQueue struct {
+	jobChan chan callbackJob
+}
+
+// NewCallbackQueue creates a queue which holds up to size callbacks.
+func NewCallbackQueue(size int) *CallbackQueue {
+	return &CallbackQueue{jobChan: make(chan callbackJob, size)}
+}
+
+// CallOne calls the next callback in the queue, waiting up to timeout for one; it returns false if there
+// was none.
+func (q *CallbackQueue) CallOne(timeout time.Duration) bool {
+	select {
+	case job := <-q.jobChan:
+		job.call()
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// CallAvailable calls the callbacks which are in the queue, without waiting for more.
+func (q *CallbackQueue) CallAvailable() {
+	for {
+		select {
+		case job := <-q.jobChan:
+			job.call()
+		default:
+			return
+		}
+	}
+}
+
+// Len returns the number of callbacks in the queue.
+func (q *CallbackQueue) Len() int {
+	return len(q.jobChan)
+}
+
+// AsyncSpinner calls the callbacks of a queue on several goroutines.  Callbacks of different subscribers
+// or service servers run concurrently, so they must be safe to call concurrently; the callbacks of one subscriber or server
+// are still called in order, one at a time.
+type AsyncSpinner struct {
+	queue    *CallbackQueue
+	threads  int
+	mutex    sync.Mutex
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+// NewAsyncSpinner creates a spinner which calls the callbacks of queue on threads goroutines once started.
+func NewAsyncSpinner(queue *CallbackQueue, threads int) (*AsyncSpinner, error) {
+	if threads < 1 {
+		return nil, fmt.Errorf("an async spinner needs at least 1 thread, not %d", threads)
+	}
+	return &AsyncSpinner{queue: queue, threads: threads}, nil
+}
+
+// Start starts calling the callbacks of the queue; it does nothing if the spinner is already started.
+func (s *AsyncSpinner) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopChan != nil {
+		return
+	}
+	s.stopChan = make(chan struct{})
+	s.doneChan = make(chan struct{})
+	go s.spin(s.stopChan, s.doneChan)
+}
+
+// Stop stops taking callbacks from the queue, and returns once the callbacks it already took are called.
+func (s *AsyncSpinner) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopChan == nil {
+		return
+	}
+	close(s.stopChan)
+	<-s.doneChan
+	s.stopChan = nil
+}
+
+// spin hands the callbacks of the queue to the worker goroutines, holding back callbacks whose owner has
+// one running or waiting, until stopChan is closed.
+func (s *AsyncSpinner) spin(stopChan <-chan struct{}, doneChan chan<- struct{}) {
+	workChan := make(chan callbackJob)
+	// Each worker has at most one owner to report, so reporting never blocks.
+	finishedChan := make(chan interface{}, s.threads)
+	var wg sync.WaitGroup
+	for i := 0; i < s.threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range workChan {
+				job.call()
+				finishedChan <- job.owner
+			}
+		}()
+	}
+	defer func() {
+		close(workChan)
+		wg.Wait()
+		close(doneChan)
+	}()
+
+	var ready []callbackJob                        // Jobs which may be called now.
+	waiting := make(map[interface{}][]callbackJob) // Jobs behind a job of the same owner, by owner.
+	pending := 0
+	for {
+		jobChan := s.queue.jobChan
+		// Taking no more jobs than the queue holds keeps its size meaningful.
+		if stopChan == nil || pending >= cap(jobChan)+s.threads {
+			jobChan = nil
+		}
+		var readyChan chan callbackJob
+		var next callbackJob
+		if len(ready) > 0 {
+			readyChan = workChan
+			next = ready[0]
+		}
+		if stopChan == nil && pending == 0 {
+			return
+		}
+		select {
+		case job := <-jobChan:
+			pending++
+			if jobs, ok := waiting[job.owner]; ok {
+				waiting[job.owner] = append(jobs, job)
+			} else {
+				waiting[job.owner] = nil
+				ready = append(ready, job)
+			}
+		case readyChan <- next:
+			ready = ready[1:]
+		case owner := <-finishedChan:
+			pending--
+			if jobs := waiting[owner]; len(jobs) > 0 {
+				ready = append(ready, jobs[0])
+				waiting[owner] = jobs[1:]
+			} else {
+				delete(waiting, owner)
+			}
+		case <-stopChan:
+			stopChan = nil
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package ros
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCallbackQueue(t *testing.T) {
+	queue := NewCallbackQueue(10)
+	if queue.CallOne(time.Millisecond) {
+		t.Error("expected an empty queue to call nothing")
+	}
+	var calls []int
+	for i := 0; i < 3; i++ {
+		i := i
+		queue.jobChan <- callbackJob{nil, func() { calls = append(calls, i) }}
+	}
+	if queue.Len() != 3 {
+		t.Errorf("expected 3 callbacks, got %d", queue.Len())
+	}
+	if !queue.CallOne(time.Millisecond) {
+		t.Error("expected a callback to be called")
+	}
+	queue.CallAvailable()
+	if len(calls) != 3 || calls[0] != 0 || calls[1] != 1 || calls[2] != 2 {
+		t.Errorf("unexpected calls %v", calls)
+	}
+}
+
+func TestAsyncSpinner(t *testing.T) {
+	if _, err := NewAsyncSpinner(NewCallbackQueue(10), 0); err == nil {
+		t.Error("expected a spinner without threads to be refused")
+	}
+
+	queue := NewCallbackQueue(100)
+	spinner, err := NewAsyncSpinner(queue, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spinner.Start()
+
+	// The slow owner must neither delay the fast one, nor run concurrently with itself.
+	var mutex sync.Mutex
+	var slowCalls []int
+	running := 0
+	fastDone := make(chan time.Time, 1)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		i := i
+		queue.jobChan <- callbackJob{"slow", func() {
+			mutex.Lock()
+			running++
+			if running > 1 {
+				t.Error("callbacks of one owner ran concurrently")
+			}
+			mutex.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mutex.Lock()
+			running--
+			slowCalls = append(slowCalls, i)
+			mutex.Unlock()
+		}}
+	}
+	queue.jobChan <- callbackJob{"fast", func() { fastDone <- time.Now() }}
+	if elapsed := (<-fastDone).Sub(start); elapsed > 50*time.Millisecond {
+		t.Errorf("the fast callback waited %v for the slow ones", elapsed)
+	}
+
+	// Stop calls the callbacks the spinner already took.
+	spinner.Stop()
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(slowCalls) != 5 {
+		t.Fatalf("expected 5 slow callbacks, got %v", slowCalls)
+	}
+	for i, call := range slowCalls {
+		if call != i {
+			t.Errorf("unexpected order %v", slowCalls)
+			break
+		}
+	}
+}
+
+func TestSubscriberCallbackQueue(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	talker := newTestNode(t, m, "/talker")
+	defer talker.Shutdown()
+	listener := newTestNode(t, m, "/listener")
+	defer listener.Shutdown()
+
+	pub, err := talker.NewPublisher("/chatter", msgTypeGoalID, OptionLatch(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.Publish(&goalIDMessage{GoalID{ID: "queued"}})
+
+	// The listener never spins; the spinner of its queue calls the callback.
+	queue := NewCallbackQueue(10)
+	spinner, _ := NewAsyncSpinner(queue, 2)
+	spinner.Start()
+	defer spinner.Stop()
+	received := make(chan string, 1)
+	if _, err := listener.NewSubscriber("/chatter", msgTypeGoalID, func(msg *goalIDMessage) {
+		received <- msg.GoalID.ID
+	}, OptionSubscriberCallbackQueue(queue)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case id := <-received:
+		if id != "queued" {
+			t.Errorf("unexpected message %q", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the message")
+	}
+	if listener.CallbackQueue().Len() != 0 {
+		t.Error("expected the queue of the node to stay empty")
+	}
+}
+
+func TestServiceCallbackQueue(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	queue := NewCallbackQueue(10)
+	server := newEchoServerWithOptions(t, m, OptionServiceCallbackQueue(queue))
+	defer server.Shutdown()
+	spinner, _ := NewAsyncSpinner(queue, 1)
+	spinner.Start()
+	defer spinner.Stop()
+	node := newTestNode(t, m, "/client")
+	defer node.Shutdown()
+
+	if id, err := callEcho(node.NewServiceClient("/echo", srvTypeEcho), "queued"); err != nil || id != "queued" {
+		t.Errorf("unexpected response %q, %v", id, err)
+	}
+}
@@ -0,0 +1,165 @@
+package ros
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	//ConnectionDirectionInbound is a subscriber connection, receiving messages from a publisher
+	ConnectionDirectionInbound = "i"
+	//ConnectionDirectionOutbound is a publisher connection, sending messages to a subscriber
+	ConnectionDirectionOutbound = "o"
+)
+
+// ConnectionStats is a snapshot of the statistics of a single topic connection, as reported by the
+// getBusStats and getBusInfo slave API methods.
+type ConnectionStats struct {
+	// ID is unique among the connections of the node.
+	ID    int32
+	Topic string
+	// RemoteID is the caller id of the subscriber for an outbound connection, and the slave API URI of the
+	// publisher for an inbound connection.
+	RemoteID  string
+	Direction string
+	Transport string
+	// Info describes the transport, e.g. its addresses.
+	Info      string
+	Bytes     uint64
+	Messages  uint64
+	Drops     uint64
+	Connected bool
+}
+
+var connectionIDCounter int32
+
+func nextConnectionID() int32 {
+	return atomic.AddInt32(&connectionIDCounter, 1)
+}
+
+// connectionStats accumulates the statistics of a connection.  The counters are updated atomically by the
+// goroutine serving the connection, and read by the slave API.
+type connectionStats struct {
+	// The counters come first, to keep them 64-bit aligned for atomic access.
+	bytes     uint64
+	messages  uint64
+	drops     uint64
+	id        int32
+	topic     string
+	direction string
+	transport string
+	mutex     sync.Mutex
+	remoteID  string
+	info      string
+	connected bool
+}
+
+func newConnectionStats(topic, direction, transport, remoteID string) *connectionStats {
+	return &connectionStats{
+		id:        nextConnectionID(),
+		topic:     topic,
+		direction: direction,
+		transport: transport,
+		remoteID:  remoteID,
+	}
+}
+
+// addMessage counts a message of size bytes, excluding its length prefix.
+func (s *connectionStats) addMessage(size int) {
+	atomic.AddUint64(&s.bytes, uint64(size)+4)
+	atomic.AddUint64(&s.messages, 1)
+}
+
+func (s *connectionStats) addDrop() {
+	atomic.AddUint64(&s.drops, 1)
+}
+
+func (s *connectionStats) setConnected(remoteID, info string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if remoteID != "" {
+		s.remoteID = remoteID
+	}
+	s.info = info
+	s.connected = true
+}
+
+func (s *connectionStats) setDisconnected() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connected = false
+}
+
+func (s *connectionStats) snapshot() ConnectionStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return ConnectionStats{
+		ID:        s.id,
+		Topic:     s.topic,
+		RemoteID:  s.remoteID,
+		Direction: s.direction,
+		Transport: s.transport,
+		Info:      s.info,
+		Bytes:     atomic.LoadUint64(&s.bytes),
+		Messages:  atomic.LoadUint64(&s.messages),
+		Drops:     atomic.LoadUint64(&s.drops),
+		Connected: s.connected,
+	}
+}
+
+// connectionStatsMap holds the statistics of the connections of a publisher or a subscriber.
+type connectionStatsMap struct {
+	mutex sync.Mutex
+	stats map[interface{}]*connectionStats
+}
+
+func (m *connectionStatsMap) add(key interface{}, stats *connectionStats) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.stats == nil {
+		m.stats = make(map[interface{}]*connectionStats)
+	}
+	m.stats[key] = stats
+}
+
+func (m *connectionStatsMap) remove(key interface{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.stats, key)
+}
+
+// snapshot returns the statistics of all connections, ordered by id.
+func (m *connectionStatsMap) snapshot() []ConnectionStats {
+	m.mutex.Lock()
+	result := make([]ConnectionStats, 0, len(m.stats))
+	for _, s := range m.stats {
+		result = append(result, s.snapshot())
+	}
+	m.mutex.Unlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Build getBusStats entry of a publisher: [topic, messageDataSent, [[id, bytesSent, numSent, connected]...]].
+func buildPublisherBusStats(topic string, messageDataSent uint64, stats []ConnectionStats) interface{} {
+	connections := []interface{}{}
+	for _, s := range stats {
+		connections = append(connections, []interface{}{s.ID, int64(s.Bytes), int64(s.Messages), s.Connected})
+	}
+	return []interface{}{topic, int64(messageDataSent), connections}
+}
+
+// Build getBusStats entry of a subscriber: [topic, [[id, bytesReceived, numReceived, drops, connected]...]].
+func buildSubscriberBusStats(topic string, stats []ConnectionStats) interface{} {
+	connections := []interface{}{}
+	for _, s := range stats {
+		connections = append(connections, []interface{}{s.ID, int64(s.Bytes), int64(s.Messages), int64(s.Drops), s.Connected})
+	}
+	return []interface{}{topic, connections}
+}
+
+// Build getBusInfo entry of a connection: [id, destinationId, direction, transport, topic, connected, info].
+func buildBusInfo(s ConnectionStats) interface{} {
+	return []interface{}{s.ID, s.RemoteID, s.Direction, s.Transport, s.Topic, s.Connected, s.Info}
+}
@@ -0,0 +1,97 @@
+package ros
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/edwinhayes/rosgo/xmlrpc"
+)
+
+func TestBusStatsFormat(t *testing.T) {
+	s := newConnectionStats("/chatter", ConnectionDirectionInbound, "TCPROS", "http://talker:1234")
+	s.setConnected("", "TCPROS connection")
+	s.addMessage(10)
+	s.addMessage(20)
+	s.addDrop()
+	snapshot := s.snapshot()
+	if snapshot.Bytes != 38 || snapshot.Messages != 2 || snapshot.Drops != 1 || !snapshot.Connected {
+		t.Errorf("unexpected snapshot %+v", snapshot)
+	}
+
+	expected := []interface{}{"/chatter", []interface{}{[]interface{}{s.id, int64(38), int64(2), int64(1), true}}}
+	if stats := buildSubscriberBusStats("/chatter", []ConnectionStats{snapshot}); !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %v, got %v", expected, stats)
+	}
+	expected = []interface{}{"/chatter", int64(30), []interface{}{[]interface{}{s.id, int64(38), int64(2), true}}}
+	if stats := buildPublisherBusStats("/chatter", 30, []ConnectionStats{snapshot}); !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %v, got %v", expected, stats)
+	}
+	expected = []interface{}{s.id, "http://talker:1234", "i", "TCPROS", "/chatter", true, "TCPROS connection"}
+	if info := buildBusInfo(snapshot); !reflect.DeepEqual(info, expected) {
+		t.Errorf("expected %v, got %v", expected, info)
+	}
+}
+
+func TestBusStats(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	talker := newTestNode(t, m, "/talker")
+	defer talker.Shutdown()
+	listener := newTestNode(t, m, "/listener")
+	defer listener.Shutdown()
+
+	received := make(chan struct{}, 100)
+	sub, err := listener.NewSubscriber("/goal_id", msgTypeGoalID, func(msg *goalIDMessage) {
+		received <- struct{}{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := talker.NewPublisher("/goal_id", msgTypeGoalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(10 * time.Second)
+	for done := false; !done; {
+		pub.Publish(&goalIDMessage{GoalID{ID: "goal"}})
+		listener.SpinOnce()
+		select {
+		case <-received:
+			done = true
+		case <-timeout:
+			t.Fatal("timed out waiting for a message")
+		default:
+		}
+	}
+
+	pubStats := pub.GetConnectionStats()
+	if len(pubStats) != 1 || pubStats[0].RemoteID != "/listener" || pubStats[0].Direction != ConnectionDirectionOutbound ||
+		pubStats[0].Messages == 0 || !pubStats[0].Connected {
+		t.Errorf("unexpected publisher stats %+v", pubStats)
+	}
+	subStats := sub.GetConnectionStats()
+	if len(subStats) != 1 || subStats[0].RemoteID != talker.xmlrpcURI || subStats[0].Direction != ConnectionDirectionInbound ||
+		subStats[0].Messages == 0 || subStats[0].Transport != "TCPROS" {
+		t.Errorf("unexpected subscriber stats %+v", subStats)
+	}
+
+	result, err := callRosAPI(talker.xmlrpcURI, "getBusInfo", "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := result.([]interface{})
+	if len(info) != 1 || info[0].([]interface{})[1] != "/listener" || info[0].([]interface{})[4] != "/goal_id" {
+		t.Errorf("unexpected bus info %v", info)
+	}
+	result, err = xmlrpc.Call(listener.xmlrpcURI, "getBusStats", "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The listener only publishes its logs.
+	stats := result.([]interface{})[2].([]interface{})
+	if len(stats) != 3 || len(stats[0].([]interface{})) != 1 || stats[0].([]interface{})[0].([]interface{})[0] != "/rosout" ||
+		len(stats[1].([]interface{})) != 1 {
+		t.Errorf("unexpected bus stats %v", stats)
+	}
+}
@@ -34,9 +34,12 @@ func (d *Duration) Cmp(other Duration) int {
 	return cmpUint64(d.ToNSec(), other.ToNSec())
 }
 
-//Sleep function pauses go routine for duration d
+//Sleep function pauses go routine for duration d; of simulated time while a node follows it, returning early
+//if the time jumps backwards
 func (d *Duration) Sleep() error {
-	if !d.IsZero() {
+	if _, active, _ := simTime.state(); active {
+		simTime.sleep(*d)
+	} else if !d.IsZero() {
 		time.Sleep(time.Duration(d.ToNSec()) * time.Nanosecond)
 	}
 	return nil
@@ -42,7 +42,7 @@ type DynamicMessage struct {
 
 var rosPkgPath string // Colon separated list of paths to search for message definitions on.
 
-var context *libgengo.MsgContext // We'll try to preserve a single message context to avoid reloading each time.
+var msgContext *libgengo.MsgContext // We'll try to preserve a single message context to avoid reloading each time.
 
 // DEFINE PUBLIC STATIC FUNCTIONS.
 
@@ -69,7 +69,19 @@ func GetRuntimePackagePath() string {
 
 // ResetContext resets the package path context so that a new one will be generated
 func ResetContext() {
-	context = nil
+	msgContext = nil
+}
+
+// ensureContext creates the message context for our ROS install, unless it exists already.
+func ensureContext() error {
+	if msgContext == nil {
+		c, err := libgengo.NewMsgContext(strings.Split(GetRuntimePackagePath(), ":"))
+		if err != nil {
+			return err
+		}
+		msgContext = c
+	}
+	return nil
 }
 
 // NewDynamicMessageType generates a DynamicMessageType corresponding to the specified typeName from the available ROS message definitions; typeName should be a fully-qualified
@@ -88,13 +100,8 @@ func newDynamicMessageTypeNested(typeName string, packageName string) (*DynamicM
 	m := new(DynamicMessageType)
 
 	// If we haven't created a message context yet, better do that.
-	if context == nil {
-		// Create context for our ROS install.
-		c, err := libgengo.NewMsgContext(strings.Split(GetRuntimePackagePath(), ":"))
-		if err != nil {
-			return nil, err
-		}
-		context = c
+	if err := ensureContext(); err != nil {
+		return nil, err
 	}
 
 	// We need to try to look up the full name, in case we've just been given a short name.
@@ -104,7 +111,7 @@ func newDynamicMessageTypeNested(typeName string, packageName string) (*DynamicM
 	if typeName == "Header" {
 		fullname = "std_msgs/Header"
 	} else {
-		_, ok := context.GetMsgs()[fullname]
+		_, ok := msgContext.GetMsgs()[fullname]
 		if !ok {
 			// Seems like the package_name we were give wasn't the full name.
 
@@ -116,7 +123,7 @@ func newDynamicMessageTypeNested(typeName string, packageName string) (*DynamicM
 	}
 
 	// Load context for the target message.
-	spec, err := context.LoadMsg(fullname)
+	spec, err := msgContext.LoadMsg(fullname)
 	if err != nil {
 		return nil, err
 	}
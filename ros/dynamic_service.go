@@ -0,0 +1,104 @@
+package ros
+
+// IMPORT REQUIRED PACKAGES.
+
+import (
+	"github.com/edwinhayes/rosgo/libgengo"
+)
+
+// DEFINE PUBLIC STRUCTURES.
+
+// DynamicServiceType abstracts the schema of a ROS Service whose schema is only known at runtime.  DynamicServiceTypes are created by looking up the relevant schema information from
+// ROS Service definition files.  DynamicServiceType implements the rosgo ServiceType interface, allowing it to be used throughout rosgo in the same manner as service schemas generated
+// at compiletime by gengo.
+type DynamicServiceType struct {
+	spec    *libgengo.SrvSpec
+	reqType *DynamicMessageType
+	resType *DynamicMessageType
+}
+
+// DynamicService abstracts an instance of a ROS Service whose type is only known at runtime.  The request and response are DynamicMessages of the request and response types of the
+// referenced DynamicServiceType.  DynamicService implements the rosgo Service interface, so a service handler for a DynamicServiceType should take a *DynamicService.
+type DynamicService struct {
+	dynamicType *DynamicServiceType
+	Request     *DynamicMessage
+	Response    *DynamicMessage
+}
+
+// DEFINE PUBLIC STATIC FUNCTIONS.
+
+// NewDynamicServiceType generates a DynamicServiceType corresponding to the specified typeName from the available ROS service definitions; typeName should be a fully-qualified
+// ROS service type name.  The service definitions are looked up in the same message 'context' as the ones of DynamicMessageType.
+func NewDynamicServiceType(typeName string) (*DynamicServiceType, error) {
+	// If we haven't created a message context yet, better do that.
+	if err := ensureContext(); err != nil {
+		return nil, err
+	}
+
+	// Load the service, which also registers its request and response messages with the context.
+	spec, err := msgContext.LoadSrv(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	// The request and response are ordinary messages.
+	return &DynamicServiceType{
+		spec:    spec,
+		reqType: &DynamicMessageType{spec: spec.Request},
+		resType: &DynamicMessageType{spec: spec.Response},
+	}, nil
+}
+
+// DEFINE PUBLIC RECEIVER FUNCTIONS.
+
+//	DynamicServiceType
+
+// Name returns the full ROS name of the service type; this is a getter for the DynamicServiceType.
+func (t *DynamicServiceType) Name() string {
+	return t.spec.FullName
+}
+
+// Text returns the full ROS service specification of the service type; this is a getter for the DynamicServiceType.
+func (t *DynamicServiceType) Text() string {
+	return t.spec.Text
+}
+
+// MD5Sum returns the ROS compatible MD5 sum of the service type; this is a getter for the DynamicServiceType.
+func (t *DynamicServiceType) MD5Sum() string {
+	return t.spec.MD5Sum
+}
+
+// RequestType returns the DynamicMessageType of the request of the service type.
+func (t *DynamicServiceType) RequestType() MessageType {
+	return t.reqType
+}
+
+// ResponseType returns the DynamicMessageType of the response of the service type.
+func (t *DynamicServiceType) ResponseType() MessageType {
+	return t.resType
+}
+
+// NewService creates a new DynamicService instance, whose request and response are zero valued DynamicMessages.
+func (t *DynamicServiceType) NewService() Service {
+	s := &DynamicService{dynamicType: t}
+	s.Request, _ = t.reqType.NewMessage().(*DynamicMessage)
+	s.Response, _ = t.resType.NewMessage().(*DynamicMessage)
+	return s
+}
+
+//	DynamicService
+
+// Type returns the DynamicServiceType of the service.
+func (s *DynamicService) Type() *DynamicServiceType {
+	return s.dynamicType
+}
+
+// ReqMessage returns the request of the service.
+func (s *DynamicService) ReqMessage() Message {
+	return s.Request
+}
+
+// ResMessage returns the response of the service.
+func (s *DynamicService) ResMessage() Message {
+	return s.Response
+}
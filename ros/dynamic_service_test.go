@@ -0,0 +1,83 @@
+package ros
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setTestPackagePath makes a ROS package rosgo_tests with the service AddTwoInts the runtime package path.
+func setTestPackagePath(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "rosgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := filepath.Join(dir, "rosgo_tests")
+	if err := os.MkdirAll(filepath.Join(pkg, "srv"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"package.xml":        "<package><name>rosgo_tests</name></package>\n",
+		"srv/AddTwoInts.srv": "int64 a\nint64 b\n---\nint64 sum\n",
+	}
+	for name, text := range files {
+		if err := ioutil.WriteFile(filepath.Join(pkg, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldPath := GetRuntimePackagePath()
+	SetRuntimePackagePath(dir)
+	return func() {
+		SetRuntimePackagePath(oldPath)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDynamicServiceType(t *testing.T) {
+	defer setTestPackagePath(t)()
+
+	if _, err := NewDynamicServiceType("rosgo_tests/Missing"); err == nil {
+		t.Error("expected an error for a missing service")
+	}
+	srvType, err := NewDynamicServiceType("rosgo_tests/AddTwoInts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same as rospy_tutorials/AddTwoInts, as md5sums don't depend on the package.
+	if srvType.MD5Sum() != "6a2e34150c00229791cc89ff309fff21" {
+		t.Errorf("unexpected md5sum %s", srvType.MD5Sum())
+	}
+	if srvType.Name() != "rosgo_tests/AddTwoInts" || srvType.RequestType().Name() != "rosgo_tests/AddTwoIntsRequest" ||
+		srvType.ResponseType().Name() != "rosgo_tests/AddTwoIntsResponse" {
+		t.Errorf("unexpected names %s, %s, %s", srvType.Name(), srvType.RequestType().Name(), srvType.ResponseType().Name())
+	}
+
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	server := newTestNode(t, m, "/server")
+	defer server.Shutdown()
+	go server.Spin()
+	node := newTestNode(t, m, "/client")
+	defer node.Shutdown()
+
+	if server.NewServiceServer("/add_two_ints", srvType, func(srv *DynamicService) error {
+		srv.Response.Data()["sum"] = srv.Request.Data()["a"].(int64) + srv.Request.Data()["b"].(int64)
+		return nil
+	}) == nil {
+		t.Fatal("failed to create the service server")
+	}
+
+	// A generic client fills in the request from JSON.
+	srv := srvType.NewService().(*DynamicService)
+	if err := json.Unmarshal([]byte(`{"a": 2, "b": 40}`), srv.Request); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.NewServiceClient("/add_two_ints", srvType).Call(srv); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := json.Marshal(srv.Response); string(res) != `{"sum":42}` {
+		t.Errorf("unexpected response %s", res)
+	}
+}
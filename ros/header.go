@@ -89,3 +89,27 @@ func writeConnectionHeader(headers []header, w io.Writer) error {
 	}
 	return nil
 }
+
+// encodeHeaderFields serializes headers without the leading total length, as carried in the UDPROS
+// parameters of requestTopic.
+func encodeHeaderFields(headers []header) []byte {
+	var buf bytes.Buffer
+	_ = writeConnectionHeader(headers, &buf)
+	return buf.Bytes()[4:]
+}
+
+// decodeHeaderFields parses headers serialized by encodeHeaderFields.
+func decodeHeaderFields(data []byte) (map[string]string, error) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	headers, err := readConnectionHeader(&buf)
+	if err != nil {
+		return nil, err
+	}
+	headerMap := make(map[string]string)
+	for _, h := range headers {
+		headerMap[h.key] = h.value
+	}
+	return headerMap, nil
+}
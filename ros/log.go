@@ -1,6 +1,10 @@
 package ros
 
 import (
+	"io"
+	"strconv"
+	"sync/atomic"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -19,3 +23,48 @@ func DefaultLogger() *logrus.Logger {
 func NewLogger() *logrus.Logger {
 	return logrus.New()
 }
+
+// writerHook writes the entries of a logger at or above its level to out.  A node discards the output of its
+// logger and writes through hooks instead, so that each output of the node has a level of its own.
+type writerHook struct {
+	out       io.Writer
+	formatter logrus.Formatter
+	level     uint32
+}
+
+func newWriterHook(out io.Writer, formatter logrus.Formatter, level logrus.Level) *writerHook {
+	return &writerHook{out: out, formatter: formatter, level: uint32(level)}
+}
+
+func (h *writerHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *writerHook) Fire(entry *logrus.Entry) error {
+	if entry.Level > h.getLevel() {
+		return nil
+	}
+	serialized, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.out.Write(serialized)
+	return err
+}
+
+func (h *writerHook) getLevel() logrus.Level {
+	return logrus.Level(atomic.LoadUint32(&h.level))
+}
+
+func (h *writerHook) setLevel(level logrus.Level) {
+	atomic.StoreUint32(&h.level, uint32(level))
+}
+
+// parseLogLevel parses the value of a log level argument, such as __ll, which is the number of a logrus level.
+func parseLogLevel(value string) (logrus.Level, error) {
+	level, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return logrus.Level(level), nil
+}
@@ -0,0 +1,164 @@
+package ros
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Default limits of the log file of a node.
+const (
+	defaultLogFileMaxSize    = 10 * 1024 * 1024
+	defaultLogFileMaxBackups = 5
+)
+
+// rotatingFile is a log file which is rotated once it grows beyond maxSize: path is renamed to path.1, path.1
+// to path.2 and so on, keeping up to maxBackups old files.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	mutex      sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+// openRotatingFile opens the log file at path, appending to it, and creates its directory if needed.
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		// Closed with its node; entries logged later are dropped.
+		return len(p), nil
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if f.maxBackups < 1 {
+		os.Remove(f.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+		for i := f.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// rosconsoleFormatter formats entries like the default format of rosconsole, "[${severity}] [${time}]: ${message}",
+// where the time is the ROS time.
+type rosconsoleFormatter struct{}
+
+func (rosconsoleFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	var severity string
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		severity = "FATAL"
+	case logrus.ErrorLevel:
+		severity = "ERROR"
+	case logrus.WarnLevel:
+		severity = " WARN"
+	case logrus.InfoLevel:
+		severity = " INFO"
+	default:
+		severity = "DEBUG"
+	}
+	now := Now()
+	return []byte(fmt.Sprintf("[%s] [%d.%09d]: %s\n", severity, now.Sec, now.NSec, entry.Message)), nil
+}
+
+// logFilePath returns the path of the log file of a node: <logDir>/<runID>/<node>-<pid>.log, where the node
+// name has its slashes replaced by underscores.
+func logFilePath(logDir string, runID string, qualifiedName string, pid int) string {
+	name := strings.Replace(strings.TrimPrefix(qualifiedName, GlobalNS), Sep, "_", -1)
+	return filepath.Join(logDir, runID, fmt.Sprintf("%s-%d.log", name, pid))
+}
+
+// logFileHook writes the entries of the loggers of a node to its log file.  Like rosoutHook, it leaves out the
+// debug entries of rosgo itself, which are only written to the console.
+type logFileHook struct {
+	*writerHook
+}
+
+func (h *logFileHook) Fire(entry *logrus.Entry) error {
+	if entry.Level >= logrus.DebugLevel && strings.HasPrefix(logCaller().Function, rosPackage) {
+		return nil
+	}
+	return h.writerHook.Fire(entry)
+}
+
+// openLogFile starts writing the log of the node to its log file, in the directory of the run given by the
+// /run_id parameter.  The file is rotated once it grows beyond maxSize bytes, keeping maxBackups old files.
+func (node *defaultNode) openLogFile(logger *logrus.Logger, maxSize int64, maxBackups int) error {
+	runID := ""
+	if value, err := node.GetParam("/run_id"); err == nil {
+		if s, ok := value.(string); ok {
+			runID = s
+		}
+	}
+	file, err := openRotatingFile(logFilePath(node.logDir, runID, node.qualifiedName, os.Getpid()),
+		maxSize, maxBackups)
+	if err != nil {
+		return err
+	}
+	node.logFile = file
+	node.logFileHook = &logFileHook{newWriterHook(file, rosconsoleFormatter{}, logrus.DebugLevel)}
+	logger.AddHook(node.logFileHook)
+	return nil
+}
@@ -0,0 +1,84 @@
+package ros
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rosgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run", "node.log")
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	// Every line overflows the file, and only two old files are kept.
+	expected := map[string]string{"node.log": "fourth\n", "node.log.1": "third\n", "node.log.2": "second\n"}
+	files, _ := ioutil.ReadDir(filepath.Dir(path))
+	if len(files) != len(expected) {
+		t.Errorf("expected %d files, got %d", len(expected), len(files))
+	}
+	for name, text := range expected {
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "run", name)); err != nil || string(data) != text {
+			t.Errorf("%s: expected %q, got %q, %v", name, text, data, err)
+		}
+	}
+}
+
+func TestRosconsoleFormatter(t *testing.T) {
+	entry := &logrus.Entry{Level: logrus.WarnLevel, Message: "careful"}
+	data, err := rosconsoleFormatter{}.Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := string(data); !strings.HasPrefix(line, "[ WARN] [") || !strings.HasSuffix(line, "]: careful\n") {
+		t.Errorf("unexpected line %q", line)
+	}
+	if path := logFilePath("/log", "run", "/ns/node", 42); path != "/log/run/ns_node-42.log" {
+		t.Errorf("unexpected path %s", path)
+	}
+}
+
+func TestNodeLogFile(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	setup := newTestNode(t, m, "/setup")
+	defer setup.Shutdown()
+	if err := setup.SetParam("/run_id", "test-run"); err != nil {
+		t.Fatal(err)
+	}
+
+	node := newTestNode(t, m, "/ns/node")
+	node.logger.Warn("written to the file")
+	node.logger.Debug("debug of rosgo")
+	node.Shutdown()
+
+	path := logFilePath(node.logDir, "test-run", "/ns/node", os.Getpid())
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "]: written to the file\n") {
+		t.Errorf("unexpected log file %q", data)
+	}
+	// The debug entries of rosgo itself stay out of the file.
+	if strings.Contains(string(data), "DEBUG") {
+		t.Errorf("unexpected debug entries in the log file %q", data)
+	}
+}
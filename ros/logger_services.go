@@ -0,0 +1,310 @@
+package ros
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	modular "github.com/edwinhayes/logrus-modular"
+	"github.com/sirupsen/logrus"
+)
+
+// builtinServiceType describes a service type which rosgo serves internally (e.g. roscpp/GetLoggers).
+type builtinServiceType struct {
+	name       string
+	md5sum     string
+	reqType    *builtinMessageType
+	resType    *builtinMessageType
+	newService func() Service
+}
+
+func (t *builtinServiceType) MD5Sum() string            { return t.md5sum }
+func (t *builtinServiceType) Name() string              { return t.name }
+func (t *builtinServiceType) RequestType() MessageType  { return t.reqType }
+func (t *builtinServiceType) ResponseType() MessageType { return t.resType }
+func (t *builtinServiceType) NewService() Service       { return t.newService() }
+
+// loggerInfo is the roscpp/Logger message, a logger and its level.
+type loggerInfo struct {
+	Name  string
+	Level string
+}
+
+const loggerInfoText = `string name
+string level
+`
+
+// getLoggersRequest and getLoggersResponse are the messages of roscpp/GetLoggers.
+type getLoggersRequest struct{}
+
+type getLoggersResponse struct {
+	Loggers []loggerInfo
+}
+
+type getLoggersService struct {
+	req getLoggersRequest
+	res getLoggersResponse
+}
+
+var srvTypeGetLoggers = &builtinServiceType{
+	name:   "roscpp/GetLoggers",
+	md5sum: "32e97e85527d4678a8f9279894bb64b0",
+	reqType: &builtinMessageType{
+		name:       "roscpp/GetLoggersRequest",
+		md5sum:     "d41d8cd98f00b204e9800998ecf8427e",
+		newMessage: func() Message { return new(getLoggersRequest) },
+	},
+	resType: &builtinMessageType{
+		name:       "roscpp/GetLoggersResponse",
+		text:       "Logger[] loggers\n" + messageDefinitionSeparator + "MSG: roscpp/Logger\n" + loggerInfoText,
+		md5sum:     "32e97e85527d4678a8f9279894bb64b0",
+		newMessage: func() Message { return new(getLoggersResponse) },
+	},
+	newService: func() Service { return new(getLoggersService) },
+}
+
+func (s *getLoggersService) ReqMessage() Message { return &s.req }
+func (s *getLoggersService) ResMessage() Message { return &s.res }
+
+func (m *getLoggersRequest) Type() MessageType                 { return srvTypeGetLoggers.reqType }
+func (m *getLoggersRequest) Serialize(buf *bytes.Buffer) error { return nil }
+func (m *getLoggersRequest) Deserialize(buf *bytes.Reader) error {
+	return nil
+}
+
+func (m *getLoggersResponse) Type() MessageType { return srvTypeGetLoggers.resType }
+
+func (m *getLoggersResponse) Serialize(buf *bytes.Buffer) error {
+	binary.Write(buf, binary.LittleEndian, uint32(len(m.Loggers)))
+	for _, l := range m.Loggers {
+		writeString(buf, l.Name)
+		writeString(buf, l.Level)
+	}
+	return nil
+}
+
+func (m *getLoggersResponse) Deserialize(buf *bytes.Reader) error {
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return err
+	}
+	m.Loggers = nil
+	for i := uint32(0); i < count; i++ {
+		var l loggerInfo
+		var err error
+		if l.Name, err = readString(buf); err != nil {
+			return err
+		}
+		if l.Level, err = readString(buf); err != nil {
+			return err
+		}
+		m.Loggers = append(m.Loggers, l)
+	}
+	return nil
+}
+
+// setLoggerLevelRequest and setLoggerLevelResponse are the messages of roscpp/SetLoggerLevel.
+type setLoggerLevelRequest struct {
+	Logger string
+	Level  string
+}
+
+type setLoggerLevelResponse struct{}
+
+type setLoggerLevelService struct {
+	req setLoggerLevelRequest
+	res setLoggerLevelResponse
+}
+
+var srvTypeSetLoggerLevel = &builtinServiceType{
+	name:   "roscpp/SetLoggerLevel",
+	md5sum: "51da076440d78ca1684d36c868df61ea",
+	reqType: &builtinMessageType{
+		name:       "roscpp/SetLoggerLevelRequest",
+		text:       "string logger\nstring level\n",
+		md5sum:     "51da076440d78ca1684d36c868df61ea",
+		newMessage: func() Message { return new(setLoggerLevelRequest) },
+	},
+	resType: &builtinMessageType{
+		name:       "roscpp/SetLoggerLevelResponse",
+		md5sum:     "d41d8cd98f00b204e9800998ecf8427e",
+		newMessage: func() Message { return new(setLoggerLevelResponse) },
+	},
+	newService: func() Service { return new(setLoggerLevelService) },
+}
+
+func (s *setLoggerLevelService) ReqMessage() Message { return &s.req }
+func (s *setLoggerLevelService) ResMessage() Message { return &s.res }
+
+func (m *setLoggerLevelRequest) Type() MessageType { return srvTypeSetLoggerLevel.reqType }
+
+func (m *setLoggerLevelRequest) Serialize(buf *bytes.Buffer) error {
+	writeString(buf, m.Logger)
+	writeString(buf, m.Level)
+	return nil
+}
+
+func (m *setLoggerLevelRequest) Deserialize(buf *bytes.Reader) error {
+	var err error
+	if m.Logger, err = readString(buf); err != nil {
+		return err
+	}
+	m.Level, err = readString(buf)
+	return err
+}
+
+func (m *setLoggerLevelResponse) Type() MessageType                 { return srvTypeSetLoggerLevel.resType }
+func (m *setLoggerLevelResponse) Serialize(buf *bytes.Buffer) error { return nil }
+func (m *setLoggerLevelResponse) Deserialize(buf *bytes.Reader) error {
+	return nil
+}
+
+// rootLoggerName is the name under which ~get_loggers lists the logger of the node, like the "ros" logger of
+// roscpp; its level applies to all module loggers.
+const rootLoggerName = "ros"
+
+// rosconsoleLevel returns the name of level in rosconsole.
+func rosconsoleLevel(level logrus.Level) string {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return "fatal"
+	case logrus.ErrorLevel:
+		return "error"
+	case logrus.WarnLevel:
+		return "warn"
+	case logrus.InfoLevel:
+		return "info"
+	default:
+		return "debug"
+	}
+}
+
+// parseRosconsoleLevel parses the name of a rosconsole level, or of any logrus level.
+func parseRosconsoleLevel(name string) (logrus.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return logrus.DebugLevel, nil
+	case "info":
+		return logrus.InfoLevel, nil
+	case "warn":
+		return logrus.WarnLevel, nil
+	case "error":
+		return logrus.ErrorLevel, nil
+	case "fatal":
+		return logrus.FatalLevel, nil
+	default:
+		return logrus.ParseLevel(name)
+	}
+}
+
+// loggerRegistry records the module loggers created from the logger of a node, since logrus-modular cannot list
+// them.
+type loggerRegistry struct {
+	mutex   sync.Mutex
+	modules map[string]modular.ModuleLogger
+}
+
+func (r *loggerRegistry) add(logger modular.ModuleLogger) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.modules[logger.GetModuleName()] = logger
+}
+
+// trackedLogger is a module logger whose children, and their children, are recorded in a registry.
+type trackedLogger struct {
+	modular.ModuleLogger
+	registry *loggerRegistry
+}
+
+func newTrackedLogger(logger modular.ModuleLogger) *trackedLogger {
+	return &trackedLogger{logger, &loggerRegistry{modules: make(map[string]modular.ModuleLogger)}}
+}
+
+func (l *trackedLogger) track(child modular.ModuleLogger) modular.ModuleLogger {
+	l.registry.add(child)
+	return &trackedLogger{child, l.registry}
+}
+
+func (l *trackedLogger) GetChild(moduleName string) (modular.ModuleLogger, error) {
+	child, err := l.ModuleLogger.GetChild(moduleName)
+	if err != nil {
+		return nil, err
+	}
+	return l.track(child), nil
+}
+
+func (l *trackedLogger) CreateChild(moduleName string, defaultLevel logrus.Level) (modular.ModuleLogger, error) {
+	child, err := l.ModuleLogger.CreateChild(moduleName, defaultLevel)
+	if err != nil {
+		return nil, err
+	}
+	return l.track(child), nil
+}
+
+func (l *trackedLogger) GetOrCreateChild(moduleName string, defaultLevel logrus.Level) modular.ModuleLogger {
+	return l.track(l.ModuleLogger.GetOrCreateChild(moduleName, defaultLevel))
+}
+
+// loggers returns the logger of the node and the module loggers created from it, sorted by name.
+func (l *trackedLogger) loggers() []loggerInfo {
+	result := []loggerInfo{{rootLoggerName, rosconsoleLevel(l.GetLevel())}}
+	l.registry.mutex.Lock()
+	defer l.registry.mutex.Unlock()
+	for name, logger := range l.registry.modules {
+		result = append(result, loggerInfo{name, rosconsoleLevel(logger.GetLevel())})
+	}
+	sort.Slice(result[1:], func(i, j int) bool { return result[i+1].Name < result[j+1].Name })
+	return result
+}
+
+// setLevel sets the level of the named logger, and of its children.
+func (l *trackedLogger) setLevel(name string, level logrus.Level) error {
+	if name == rootLoggerName {
+		l.SetLevel(level)
+		return nil
+	}
+	l.registry.mutex.Lock()
+	logger, ok := l.registry.modules[name]
+	l.registry.mutex.Unlock()
+	if !ok {
+		var err error
+		if logger, err = l.GetChild(name); err != nil {
+			return fmt.Errorf("no logger %s", name)
+		}
+	}
+	logger.SetLevel(level)
+	return nil
+}
+
+// advertiseLoggerServices serves ~get_loggers and ~set_logger_level, which rqt_logger_level uses to change the
+// levels of the loggers of the node.  They are served on goroutines of their own, so they work without
+// spinning the node.  The services are advertised unless the node is started with __logger_services:=false.
+func (node *defaultNode) advertiseLoggerServices() error {
+	concurrency := OptionServiceConcurrency(ServiceGoroutinePerRequest, 0)
+	if node.NewServiceServer("~get_loggers", srvTypeGetLoggers, func(srv *getLoggersService) error {
+		srv.res.Loggers = node.logger.(*trackedLogger).loggers()
+		return nil
+	}, concurrency) == nil {
+		return fmt.Errorf("failed to advertise ~get_loggers")
+	}
+	if node.NewServiceServer("~set_logger_level", srvTypeSetLoggerLevel, func(srv *setLoggerLevelService) error {
+		level, err := parseRosconsoleLevel(srv.req.Level)
+		if err != nil {
+			return err
+		}
+		if err := node.logger.(*trackedLogger).setLevel(srv.req.Logger, level); err != nil {
+			return err
+		}
+		// /rosout publishes at info by default; a logger set to a more verbose level is published at it.
+		if node.rosoutHook != nil && level > node.rosoutHook.getLevel() {
+			node.rosoutHook.setLevel(level)
+		}
+		return nil
+	}, concurrency) == nil {
+		return fmt.Errorf("failed to advertise ~set_logger_level")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package ros
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/edwinhayes/rosgo/libgengo"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLoggerServicesMD5(t *testing.T) {
+	ctx, err := libgengo.NewMsgContext([]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.LoadMsgFromString(loggerInfoText, "roscpp/Logger"); err != nil {
+		t.Fatal(err)
+	}
+	for text, srvType := range map[string]*builtinServiceType{
+		"---\nLogger[] loggers\n":            srvTypeGetLoggers,
+		"string logger\nstring level\n---\n": srvTypeSetLoggerLevel,
+	} {
+		spec, err := ctx.LoadSrvFromString(text, srvType.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.MD5Sum != srvType.MD5Sum() || spec.Request.MD5Sum != srvType.reqType.MD5Sum() || spec.Response.MD5Sum != srvType.resType.MD5Sum() {
+			t.Errorf("%s: unexpected md5sums", srvType.Name())
+		}
+	}
+}
+
+func TestLoggerServices(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/node")
+	defer node.Shutdown()
+	camera := node.logger.GetOrCreateChild("camera", logrus.InfoLevel)
+	camera.GetOrCreateChild("driver", logrus.WarnLevel)
+	client := newTestNode(t, m, "/client")
+	defer client.Shutdown()
+
+	getLoggers := func() []loggerInfo {
+		t.Helper()
+		srv := new(getLoggersService)
+		if err := client.NewServiceClient("/node/get_loggers", srvTypeGetLoggers).Call(srv); err != nil {
+			t.Fatal(err)
+		}
+		return srv.res.Loggers
+	}
+	setLoggerLevel := func(logger, level string) error {
+		srv := new(setLoggerLevelService)
+		srv.req = setLoggerLevelRequest{logger, level}
+		return client.NewServiceClient("/node/set_logger_level", srvTypeSetLoggerLevel).Call(srv)
+	}
+
+	expected := []loggerInfo{{"ros", "info"}, {"camera", "info"}, {"camera.driver", "warn"}}
+	if loggers := getLoggers(); !reflect.DeepEqual(loggers, expected) {
+		t.Errorf("expected %v, got %v", expected, loggers)
+	}
+
+	if level := node.rosoutHook.getLevel(); level != logrus.InfoLevel {
+		t.Errorf("expected /rosout at info by default, got %v", level)
+	}
+
+	// Setting the level of a logger sets the levels of its children, and lets /rosout publish at it.
+	if err := setLoggerLevel("camera", "DEBUG"); err != nil {
+		t.Fatal(err)
+	}
+	if level := node.rosoutHook.getLevel(); level != logrus.DebugLevel {
+		t.Errorf("expected /rosout at debug, got %v", level)
+	}
+	expected = []loggerInfo{{"ros", "info"}, {"camera", "debug"}, {"camera.driver", "debug"}}
+	if loggers := getLoggers(); !reflect.DeepEqual(loggers, expected) {
+		t.Errorf("expected %v, got %v", expected, loggers)
+	}
+	if camera.GetLevel() != logrus.DebugLevel {
+		t.Errorf("expected the module logger at debug, got %v", camera.GetLevel())
+	}
+	if err := setLoggerLevel("ros", "error"); err != nil || node.logger.GetLevel() != logrus.ErrorLevel {
+		t.Errorf("expected the logger of the node at error, got %v, %v", node.logger.GetLevel(), err)
+	}
+
+	if err := setLoggerLevel("missing", "debug"); err == nil {
+		t.Error("expected an unknown logger to fail")
+	}
+	if err := setLoggerLevel("camera", "loud"); err == nil {
+		t.Error("expected an unknown level to fail")
+	}
+}
+
+func TestLoggerServicesDisabled(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node, err := newDefaultNode("/node", []string{"__master:=" + m.URI(), "__ip:=127.0.0.1", "__logger_services:=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Shutdown()
+	if _, err := node.ProbeService("/node/get_loggers"); err == nil {
+		t.Error("expected the logger services not to be advertised")
+	}
+}
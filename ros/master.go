@@ -1,6 +1,7 @@
 package ros
 
 import (
+	"context"
 	"fmt"
 	"github.com/edwinhayes/rosgo/xmlrpc"
 )
@@ -9,7 +10,12 @@ import (
 //Method is the method to be called in the request. Args is an interface of values that are required
 //by the method call. Returns interface of the XML response from callee.
 func callRosAPI(calleeURI string, method string, args ...interface{}) (interface{}, error) {
-	result, err := xmlrpc.Call(calleeURI, method, args...)
+	return callRosAPIContext(context.Background(), calleeURI, method, args...)
+}
+
+// callRosAPIContext is callRosAPI, giving up when ctx is done.
+func callRosAPIContext(ctx context.Context, calleeURI string, method string, args ...interface{}) (interface{}, error) {
+	result, err := xmlrpc.CallContext(ctx, calleeURI, method, args...)
 	if err != nil {
 		return nil, err
 	}
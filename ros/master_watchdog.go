@@ -0,0 +1,261 @@
+package ros
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MasterState is the state of the connection of a node to the master.
+type MasterState int
+
+const (
+	// MasterConnected is reported when the master answers again, and still knows the node.
+	MasterConnected MasterState = iota
+	// MasterDisconnected is reported when the master stops answering.
+	MasterDisconnected
+	// MasterReregistered is reported when the node registered again with a master which did not know it,
+	// usually a restarted roscore.
+	MasterReregistered
+)
+
+func (s MasterState) String() string {
+	switch s {
+	case MasterConnected:
+		return "connected"
+	case MasterDisconnected:
+		return "disconnected"
+	case MasterReregistered:
+		return "reregistered"
+	default:
+		return fmt.Sprintf("MasterState(%d)", int(s))
+	}
+}
+
+// MasterEvent is passed to the callbacks of Node.SubscribeMasterState.  Err is the failure which
+// disconnected the master, or the first failure to register again.
+type MasterEvent struct {
+	State MasterState
+	Err   error
+}
+
+// masterWatchdogPeriod is how often the watchdog checks the master.
+var masterWatchdogPeriod = 5 * time.Second
+
+// masterWatchdog checks the master periodically from a goroutine of its own.  A master which answers but
+// does not know the node, since roscore restarted, is given every publication, subscription, service and
+// parameter subscription of the node again.
+type masterWatchdog struct {
+	node      *defaultNode
+	mutex     sync.Mutex
+	callbacks []func(MasterEvent)
+	runID     string          // The /run_id of the master, which roscore sets anew when it starts.
+	ctx       context.Context // Cancelled on close, ending the master call in progress.
+	cancel    context.CancelFunc
+	doneChan  chan struct{}
+	closeOnce sync.Once
+}
+
+func newMasterWatchdog(node *defaultNode) *masterWatchdog {
+	w := &masterWatchdog{
+		node:     node,
+		doneChan: make(chan struct{}),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	go w.run()
+	return w
+}
+
+func (w *masterWatchdog) subscribe(callback func(MasterEvent)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.callbacks = append(w.callbacks, callback)
+}
+
+func (w *masterWatchdog) run() {
+	defer close(w.doneChan)
+	logger := w.node.logger
+	connected := true
+	reregister := false
+	w.runID, _ = w.getRunID()
+	ticker := time.NewTicker(masterWatchdogPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.ctx.Done():
+			return
+		}
+		if _, err := callRosAPIContext(w.ctx, w.node.masterURI, "getUri", w.node.qualifiedName); err != nil {
+			if connected && w.ctx.Err() == nil {
+				logger.Warnf("Lost the master at %s: %v", w.node.masterURI, err)
+				connected = false
+				w.emit(MasterEvent{MasterDisconnected, err})
+			}
+			continue
+		}
+		if !reregister {
+			reregister = w.restarted(!connected) && w.node.hasRegistrations()
+		}
+		if w.ctx.Err() != nil {
+			return
+		}
+		if reregister {
+			logger.Infof("Registering with the master at %s again", w.node.masterURI)
+			err := w.node.reregister(w.ctx)
+			// A failed registration is retried on the next check.
+			reregister = err != nil
+			connected = true
+			w.emit(MasterEvent{MasterReregistered, err})
+		} else if !connected {
+			logger.Infof("Reconnected to the master at %s", w.node.masterURI)
+			connected = true
+			w.emit(MasterEvent{MasterConnected, nil})
+		}
+	}
+}
+
+// getRunID returns the /run_id of the master, and whether it has one.
+func (w *masterWatchdog) getRunID() (string, bool) {
+	result, err := callRosAPIContext(w.ctx, w.node.masterURI, "getParam", w.node.qualifiedName, "/run_id")
+	if err != nil {
+		return "", false
+	}
+	runID, ok := result.(string)
+	return runID, ok && runID != ""
+}
+
+// restarted reports whether the master, which answers, restarted and forgot the node.  That's when its /run_id
+// changed; a master without a /run_id is asked whether it knows the node, after it stopped answering for a
+// while.
+func (w *masterWatchdog) restarted(disconnected bool) bool {
+	runID, ok := w.getRunID()
+	if ok && w.runID != "" {
+		changed := runID != w.runID
+		w.runID = runID
+		return changed
+	}
+	w.runID = runID
+	if !disconnected {
+		return false
+	}
+	_, err := callRosAPIContext(w.ctx, w.node.masterURI, "lookupNode", w.node.qualifiedName, w.node.qualifiedName)
+	return err != nil
+}
+
+// emit queues the callbacks for event to the callback queue of the node.
+func (w *masterWatchdog) emit(event MasterEvent) {
+	w.mutex.Lock()
+	callbacks := make([]func(MasterEvent), len(w.callbacks))
+	copy(callbacks, w.callbacks)
+	w.mutex.Unlock()
+	for _, callback := range callbacks {
+		cb := callback
+		select {
+		case w.node.queue.jobChan <- callbackJob{w, func() { cb(event) }}:
+		case <-time.After(time.Duration(3) * time.Second):
+			w.node.logger.Debugf("Master state callback job for %s timed out.", event.State)
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *masterWatchdog) close() {
+	w.closeOnce.Do(func() {
+		w.cancel()
+		<-w.doneChan
+	})
+}
+
+// hasRegistrations reports whether the node has anything registered with the master.
+func (node *defaultNode) hasRegistrations() bool {
+	found := false
+	node.publishers.Range(func(_ interface{}, _ interface{}) bool {
+		found = true
+		return false
+	})
+	node.registryMutex.RLock()
+	found = found || len(node.subscribers) > 0 || len(node.servers) > 0
+	node.registryMutex.RUnlock()
+	return found || len(node.paramCache.keys()) > 0
+}
+
+// reregister registers the publications, subscriptions, services and parameter subscriptions of the node
+// with the master again, returning the first failure.
+func (node *defaultNode) reregister(ctx context.Context) error {
+	var firstErr error
+	fail := func(err error) {
+		node.logger.Error(err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	node.publishers.Range(func(_ interface{}, p interface{}) bool {
+		pub := p.(*defaultPublisher)
+		if _, err := callRosAPIContext(ctx, node.masterURI, "registerPublisher",
+			node.qualifiedName, pub.topic, pub.msgType.Name(), node.xmlrpcURI); err != nil {
+			fail(fmt.Errorf("registerPublisher(%s): %v", pub.topic, err))
+		}
+		return true
+	})
+
+	node.registryMutex.RLock()
+	subscribers := make([]*defaultSubscriber, 0, len(node.subscribers))
+	for _, sub := range node.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	servers := make([]*defaultServiceServer, 0, len(node.servers))
+	for _, server := range node.servers {
+		servers = append(servers, server)
+	}
+	node.registryMutex.RUnlock()
+
+	for _, sub := range subscribers {
+		result, err := callRosAPIContext(ctx, node.masterURI, "registerSubscriber",
+			node.qualifiedName, sub.topic, sub.msgType.Name(), node.xmlrpcURI)
+		if err != nil {
+			fail(fmt.Errorf("registerSubscriber(%s): %v", sub.topic, err))
+			continue
+		}
+		list, _ := result.([]interface{})
+		var publishers []string
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				publishers = append(publishers, s)
+			}
+		}
+		select {
+		case sub.pubListChan <- publishers:
+		case <-ctx.Done():
+			fail(ctx.Err())
+			return firstErr
+		}
+	}
+
+	for _, server := range servers {
+		select {
+		case <-server.doneChan:
+			// Shut down, but not removed from the node.
+			continue
+		default:
+		}
+		if _, err := callRosAPIContext(ctx, node.masterURI, "registerService",
+			node.qualifiedName, server.service, server.rosrpcAddr, node.xmlrpcURI); err != nil {
+			fail(fmt.Errorf("registerService(%s): %v", server.service, err))
+		}
+	}
+
+	for _, key := range node.paramCache.keys() {
+		value, err := callRosAPIContext(ctx, node.masterURI, "subscribeParam", node.qualifiedName, node.xmlrpcURI, key)
+		if err != nil {
+			fail(fmt.Errorf("subscribeParam(%s): %v", key, err))
+			continue
+		}
+		// The parameters of the new master may differ.
+		node.updateParam(key, value)
+	}
+
+	return firstErr
+}
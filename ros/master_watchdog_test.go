@@ -0,0 +1,155 @@
+package ros
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/edwinhayes/rosgo/master"
+)
+
+// waitMasterState spins node until a master state event arrives on events, which must have state.
+func waitMasterState(t *testing.T, node *defaultNode, events chan MasterEvent, state MasterState) MasterEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		node.SpinOnce()
+		select {
+		case event := <-events:
+			if event.State != state {
+				t.Fatalf("expected the master to be %s, got %s (%v)", state, event.State, event.Err)
+			}
+			return event
+		case <-timeout:
+			t.Fatalf("timed out waiting for the master to be %s", state)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestMasterWatchdog(t *testing.T) {
+	defer func(period time.Duration) { masterWatchdogPeriod = period }(masterWatchdogPeriod)
+	masterWatchdogPeriod = 20 * time.Millisecond
+
+	m := newTestMaster(t)
+	uri, err := url.Parse(m.URI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	talker := newTestNode(t, m, "/talker")
+	defer talker.Shutdown()
+	if _, err := talker.NewPublisher("/chatter", msgTypeGoalID); err != nil {
+		t.Fatal(err)
+	}
+	if talker.NewServiceServer("/echo", srvTypeEcho, echoHandler) == nil {
+		t.Fatal("failed to create the service server")
+	}
+	listener := newTestNode(t, m, "/listener")
+	defer listener.Shutdown()
+	if _, err := listener.NewSubscriber("/chatter", msgTypeGoalID, func(*goalIDMessage) {}); err != nil {
+		t.Fatal(err)
+	}
+	params := make(chan interface{}, 10)
+	if err := listener.SetParam("/rate", 10.0); err != nil {
+		t.Fatal(err)
+	}
+	if err := listener.SubscribeParam("/rate", func(key string, value interface{}) { params <- value }); err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan MasterEvent, 10)
+	listener.SubscribeMasterState(func(event MasterEvent) { events <- event })
+
+	// roscore restarts on the same port.
+	m.Shutdown()
+	waitMasterState(t, listener, events, MasterDisconnected)
+	m, err = master.NewMaster(uri.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Shutdown()
+	if err := talker.SetParam("/rate", 20.0); err != nil {
+		t.Fatal(err)
+	}
+	if event := waitMasterState(t, listener, events, MasterReregistered); event.Err != nil {
+		t.Error(event.Err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		result, err := callRosAPI(m.URI(), "getSystemState", "/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := result.([]interface{})
+		if hasSystemStateEntry(state[0], "/chatter", "/talker") && hasSystemStateEntry(state[1], "/chatter", "/listener") &&
+			hasSystemStateEntry(state[2], "/echo", "/talker") {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for the nodes to register again, got %v", state)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	spinFor(listener.CallbackQueue(), 50*time.Millisecond)
+	select {
+	case value := <-params:
+		if value != 20.0 {
+			t.Errorf("expected the parameter of the new master, got %v", value)
+		}
+	default:
+		t.Error("expected the parameter callback to be called")
+	}
+	if value, err := listener.GetParamCached("/rate"); err != nil || value != 20.0 {
+		t.Errorf("expected the cached parameter of the new master, got %v, %v", value, err)
+	}
+}
+
+// hasSystemStateEntry reports whether the publishers, subscribers or services of getSystemState list the node
+// for name.
+func hasSystemStateEntry(entries interface{}, name string, node string) bool {
+	for _, entry := range entries.([]interface{}) {
+		pair := entry.([]interface{})
+		if pair[0] != name {
+			continue
+		}
+		for _, n := range pair[1].([]interface{}) {
+			if n == node {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestMasterWatchdogRunID(t *testing.T) {
+	defer func(period time.Duration) { masterWatchdogPeriod = period }(masterWatchdogPeriod)
+	masterWatchdogPeriod = 20 * time.Millisecond
+
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	if _, err := callRosAPI(m.URI(), "setParam", "/roslaunch", "/run_id", "first-run"); err != nil {
+		t.Fatal(err)
+	}
+	node := newTestNode(t, m, "/talker")
+	defer node.Shutdown()
+	if _, err := node.NewPublisher("/chatter", msgTypeGoalID); err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan MasterEvent, 10)
+	node.SubscribeMasterState(func(event MasterEvent) { events <- event })
+
+	// A new /run_id means that another roscore answers, even if the master never stopped answering.
+	if _, err := callRosAPI(m.URI(), "setParam", "/roslaunch", "/run_id", "second-run"); err != nil {
+		t.Fatal(err)
+	}
+	if event := waitMasterState(t, node, events, MasterReregistered); event.Err != nil {
+		t.Error(event.Err)
+	}
+	spinFor(node.CallbackQueue(), 5*masterWatchdogPeriod)
+	select {
+	case event := <-events:
+		t.Errorf("unexpected master event %v", event.State)
+	default:
+	}
+}
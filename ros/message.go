@@ -2,6 +2,8 @@ package ros
 
 import (
 	"bytes"
+	"encoding/binary"
+	"io"
 )
 
 //MessageType struct which contains the interface functions for the important properties of a message
@@ -19,3 +21,94 @@ type Message interface {
 	Serialize(buf *bytes.Buffer) error
 	Deserialize(buf *bytes.Reader) error
 }
+
+// builtinMessageType describes a message type which rosgo needs internally (e.g. actionlib_msgs/GoalID)
+// and therefore cannot be generated by gengo without an import cycle.
+type builtinMessageType struct {
+	name       string
+	text       string
+	md5sum     string
+	newMessage func() Message
+}
+
+func (t *builtinMessageType) Text() string {
+	return t.text
+}
+
+func (t *builtinMessageType) MD5Sum() string {
+	return t.md5sum
+}
+
+func (t *builtinMessageType) Name() string {
+	return t.name
+}
+
+func (t *builtinMessageType) NewMessage() Message {
+	return t.newMessage()
+}
+
+func writeTime(buf *bytes.Buffer, t Time) {
+	binary.Write(buf, binary.LittleEndian, t.Sec)
+	binary.Write(buf, binary.LittleEndian, t.NSec)
+}
+
+func readTime(buf *bytes.Reader) (Time, error) {
+	var t Time
+	if err := binary.Read(buf, binary.LittleEndian, &t.Sec); err != nil {
+		return t, err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &t.NSec); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(buf *bytes.Reader) (string, error) {
+	var size uint32
+	if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+		return "", err
+	}
+	if int64(size) > int64(buf.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	data := make([]byte, int(size))
+	if _, err := io.ReadFull(buf, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// msgHeader is the wire representation of std_msgs/Header.
+type msgHeader struct {
+	Seq     uint32
+	Stamp   Time
+	FrameID string
+}
+
+func (h *msgHeader) serialize(buf *bytes.Buffer) {
+	binary.Write(buf, binary.LittleEndian, h.Seq)
+	writeTime(buf, h.Stamp)
+	writeString(buf, h.FrameID)
+}
+
+func (h *msgHeader) deserialize(buf *bytes.Reader) error {
+	var err error
+	if err = binary.Read(buf, binary.LittleEndian, &h.Seq); err != nil {
+		return err
+	}
+	if h.Stamp, err = readTime(buf); err != nil {
+		return err
+	}
+	h.FrameID, err = readString(buf)
+	return err
+}
+
+const msgHeaderText = `uint32 seq
+time stamp
+string frame_id
+`
@@ -1,8 +1,11 @@
 package ros
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
@@ -13,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	modular "github.com/edwinhayes/logrus-modular"
@@ -70,10 +74,22 @@ type defaultNode struct {
 	subscribers      map[string]*defaultSubscriber
 	publishers       sync.Map
 	servers          map[string]*defaultServiceServer
-	jobChan          chan func()
+	registryMutex    sync.RWMutex // Guards subscribers and servers where other goroutines read them.
+	masterWatchdog   *masterWatchdog
+	closeCtx         context.Context // Done when shutting down is forced; master calls while shutting down use it.
+	forceClose       context.CancelFunc
+	queue            *CallbackQueue
+	clock            clock
+	clockSpinner     *AsyncSpinner // Spins the subscription to /clock while following simulated time.
+	timers           []*defaultTimer
 	interruptChan    chan os.Signal
 	enableInterrupts bool
 	logger           modular.ModuleLogger
+	consoleHook      *writerHook
+	rosoutHook       *rosoutHook
+	rosoutLevel      logrus.Level
+	logFile          *rotatingFile
+	logFileHook      *logFileHook
 	ok               bool
 	okMutex          sync.RWMutex
 	waitGroup        sync.WaitGroup
@@ -83,6 +99,16 @@ type defaultNode struct {
 	homeDir          string
 	nameResolver     *NameResolver
 	nonRosArgs       []string
+	paramCache       *paramCache
+	paramCallsMutex  sync.Mutex
+	paramCalls       []paramCall // Parameter callbacks waiting for room in the callback queue, in order.
+	paramCallsQueued bool        // Whether a goroutine is moving paramCalls to the callback queue.
+}
+
+// paramCall is a callback of the subscribed parameter key.
+type paramCall struct {
+	key  string
+	call func()
 }
 
 func listenRandomPort(address string, trialLimit int) (net.Listener, error) {
@@ -103,20 +129,25 @@ func listenRandomPort(address string, trialLimit int) (net.Listener, error) {
 }
 
 func newDefaultNodeWithLogs(name string, logger *modular.ModuleLogger, args []string) (*defaultNode, error) {
-	node, err := newDefaultNode(name, args)
+	node, err := newDefaultNodeWithOptions(name, OptionNodeArgs(args), OptionNodeLogger(*logger))
 	if err != nil {
 		(*logger).Errorf("could not instantiate newDefaultNode : %v", err)
 		return nil, err
 	}
-
-	node.logger = (*logger).(modular.ModuleLogger)
 	return node, nil
 }
 
 func newDefaultNode(name string, args []string) (*defaultNode, error) {
-	node := new(defaultNode)
+	return newDefaultNodeWithOptions(name, OptionNodeArgs(args))
+}
 
-	remapping, params, specials, rest := processArguments(args)
+func newDefaultNodeWithOptions(name string, options ...OptionNode) (*defaultNode, error) {
+	opts, err := newNodeOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	node := new(defaultNode)
+	node.closeCtx, node.forceClose = context.WithCancel(context.Background())
 
 	node.homeDir = filepath.Join(os.Getenv("HOME"), ".ros")
 	if homeDir := os.Getenv("ROS_HOME"); len(homeDir) > 0 {
@@ -125,75 +156,48 @@ func newDefaultNode(name string, args []string) (*defaultNode, error) {
 
 	logger := logrus.New()
 	rootLogger := modular.NewRootLogger(logger)
-	if value, ok := specials["__ll"]; ok {
-		val, err := strconv.ParseInt(value, 10, 32)
-		if err == nil {
-			logger.SetLevel(logrus.Level(val))
-		}
-	} else {
-		logger.SetLevel(logrus.FatalLevel)
+	node.rosoutLevel = opts.rosoutLevel
+	// The console and /rosout filter the entries by levels of their own.
+	node.consoleHook = newWriterHook(logger.Out, logger.Formatter, opts.LogLevel)
+	logger.AddHook(node.consoleHook)
+	logger.SetOutput(ioutil.Discard)
+	logger.SetLevel(logrus.TraceLevel)
+	node.logger = newTrackedLogger(rootLogger)
+	if opts.Logger != nil {
+		node.logger = newTrackedLogger(opts.Logger)
 	}
-	node.logger = rootLogger
 
 	// Parse the name, since if it's an absolute name, then technically it actually contains the namespace also.
 	rawname := name
-	if value, ok := specials["__name"]; ok {
-		rawname = value
+	if opts.name != "" {
+		rawname = opts.name
 	}
 	var namespace string
-	var err error
 	namespace, node.name, err = qualifyNodeName(rawname)
 	if err != nil {
 		return nil, err
 	}
 
 	node.namespace = namespace
-	if ns := os.Getenv("ROS_NAMESPACE"); len(ns) > 0 {
+	if ns := opts.Namespace; len(ns) > 0 {
 		// Namespaces should all be absolute, so make sure it starts with a slash.
 		node.namespace = GlobalNS + strings.TrimPrefix(ns, GlobalNS)
 	}
-	if ns, ok := specials["__ns"]; ok {
-		// Namespaces should all be absolute, so make sure it starts with a slash.
-		node.namespace = GlobalNS + strings.TrimPrefix(ns, GlobalNS)
-	}
-	if value, ok := specials["__si"]; ok {
-		val, err := strconv.ParseBool(value)
-		if err != nil {
-			node.enableInterrupts = val
-		}
-	} else {
-		node.enableInterrupts = defaultInterrupts
-	}
+	node.enableInterrupts = opts.SignalHandling
 	node.logDir = filepath.Join(node.homeDir, "log")
 	if logDir := os.Getenv("ROS_LOG_DIR"); len(logDir) > 0 {
 		node.logDir = logDir
 	}
-	if value, ok := specials["__log"]; ok {
-		node.logDir = value
+	if opts.logDir != "" {
+		node.logDir = opts.logDir
 	}
 
-	var onlyLocalhost bool
-	node.hostname, onlyLocalhost = determineHost()
-	if value, ok := specials["__hostname"]; ok {
-		node.hostname = value
-		onlyLocalhost = (value == "localhost")
-	} else if value, ok := specials["__ip"]; ok {
-		node.hostname = value
-		onlyLocalhost = (value == "::1" || strings.HasPrefix(value, "127."))
-	}
-	if onlyLocalhost {
-		node.listenIP = "127.0.0.1"
-	} else {
-		node.listenIP = "0.0.0.0"
-	}
+	node.hostname = opts.Hostname
+	node.listenIP = opts.ListenIP
+	node.masterURI = opts.MasterURI
 
-	node.masterURI = os.Getenv("ROS_MASTER_URI")
-	if value, ok := specials["__master"]; ok {
-		node.masterURI = value
-	}
-
-	node.nameResolver = newNameResolver(node.namespace, node.name, remapping)
-	node.nonRosArgs = rest
+	node.nameResolver = newNameResolver(node.namespace, node.name, opts.Remappings)
+	node.nonRosArgs = opts.nonRosArgs
 
 	if node.namespace != GlobalNS {
 		node.qualifiedName = node.namespace + Sep + node.name
@@ -202,6 +206,7 @@ func newDefaultNode(name string, args []string) (*defaultNode, error) {
 	}
 	node.subscribers = make(map[string]*defaultSubscriber)
 	node.servers = make(map[string]*defaultServiceServer)
+	node.paramCache = newParamCache()
 	node.interruptChan = make(chan os.Signal)
 	node.ok = true
 
@@ -216,13 +221,14 @@ func newDefaultNode(name string, args []string) (*defaultNode, error) {
 			node.okMutex.Unlock()
 		}()
 	}
-	node.jobChan = make(chan func(), 100)
+	node.queue = NewCallbackQueue(100)
+	node.clock = wallClock{}
 
 	logger.Debugf("Master URI = %s", node.masterURI)
 
-	// Set parameters set by arguments
-	for k, v := range params {
-		_, err := callRosAPI(node.masterURI, "setParam", node.qualifiedName, k, v)
+	// Set the private parameters of the node
+	for k, v := range opts.PrivateParams {
+		_, err := callRosAPI(node.masterURI, "setParam", node.qualifiedName, PrivateNS+k, v)
 		if err != nil {
 			return nil, err
 		}
@@ -262,6 +268,40 @@ func newDefaultNode(name string, args []string) (*defaultNode, error) {
 	}
 	node.xmlrpcHandler = xmlrpc.NewHandler(m)
 	go http.Serve(node.xmlrpcListener, node.xmlrpcHandler)
+
+	if node.rosoutHook, err = newRosoutHook(node, node.rosoutLevel); err != nil {
+		logger.Warnf("Logs are not published to /rosout: %v", err)
+	} else {
+		logger.AddHook(node.rosoutHook)
+	}
+	if err := node.openLogFile(logger, opts.LogFileMaxSize, opts.LogFileMaxBackups); err != nil {
+		logger.Warnf("Logs are not written to a file: %v", err)
+	}
+	if opts.Logger != nil {
+		// The entries of the logger of the application go to /rosout and the log file, but not to the console.
+		if node.rosoutHook != nil {
+			opts.Logger.GetRoot().GetLogger().AddHook(node.rosoutHook)
+		}
+		if node.logFileHook != nil {
+			opts.Logger.GetRoot().GetLogger().AddHook(node.logFileHook)
+		}
+	}
+	if opts.loggerServices {
+		if err := node.advertiseLoggerServices(); err != nil {
+			logger.Warn(err)
+		}
+	}
+	if opts.masterWatchdog {
+		node.masterWatchdog = newMasterWatchdog(node)
+	}
+	if useSimTime, err := node.GetParam("/use_sim_time"); err == nil && useSimTime == true {
+		logger.Debug("Following simulated time")
+		if err := node.followSimTime(); err != nil {
+			logger.Error(err)
+			node.Shutdown()
+			return nil, err
+		}
+	}
 	logger.Debugf("Started %s", node.qualifiedName)
 	return node, nil
 }
@@ -294,11 +334,45 @@ func (node *defaultNode) QualifiedName() string {
 }
 
 func (node *defaultNode) getBusStats(callerID string) (interface{}, error) {
-	return buildRosAPIResult(-1, "Not implemented", 0), nil
+	publishStats := []interface{}{}
+	node.publishers.Range(func(_ interface{}, p interface{}) bool {
+		publishStats = append(publishStats, p.(*defaultPublisher).getBusStats())
+		return true
+	})
+	subscribeStats := []interface{}{}
+	// The services are summed up as [numRequests, bytesReceived, bytesSent], like in rospy.
+	var numRequests, bytesReceived, bytesSent uint64
+	node.registryMutex.RLock()
+	for _, s := range node.subscribers {
+		subscribeStats = append(subscribeStats, s.getBusStats())
+	}
+	for _, s := range node.servers {
+		numRequests += atomic.LoadUint64(&s.numRequests)
+		bytesReceived += atomic.LoadUint64(&s.bytesReceived)
+		bytesSent += atomic.LoadUint64(&s.bytesSent)
+	}
+	node.registryMutex.RUnlock()
+	serviceStats := []interface{}{int64(numRequests), int64(bytesReceived), int64(bytesSent)}
+	stats := []interface{}{publishStats, subscribeStats, serviceStats}
+	return buildRosAPIResult(APIStatusSuccess, "Success", stats), nil
 }
 
 func (node *defaultNode) getBusInfo(callerID string) (interface{}, error) {
-	return buildRosAPIResult(-1, "Not implemeted", 0), nil
+	var connections []ConnectionStats
+	node.publishers.Range(func(_ interface{}, p interface{}) bool {
+		connections = append(connections, p.(*defaultPublisher).GetConnectionStats()...)
+		return true
+	})
+	node.registryMutex.RLock()
+	for _, s := range node.subscribers {
+		connections = append(connections, s.GetConnectionStats()...)
+	}
+	node.registryMutex.RUnlock()
+	busInfo := []interface{}{}
+	for _, c := range connections {
+		busInfo = append(busInfo, buildBusInfo(c))
+	}
+	return buildRosAPIResult(APIStatusSuccess, "Success", busInfo), nil
 }
 
 func (node *defaultNode) getMasterURI(callerID string) (interface{}, error) {
@@ -318,10 +392,12 @@ func (node *defaultNode) getPid(callerID string) (interface{}, error) {
 
 func (node *defaultNode) getSubscriptions(callerID string) (interface{}, error) {
 	result := []interface{}{}
+	node.registryMutex.RLock()
 	for t, s := range node.subscribers {
 		pair := []interface{}{t, s.msgType.Name()}
 		result = append(result, pair)
 	}
+	node.registryMutex.RUnlock()
 	return buildRosAPIResult(0, "Success", result), nil
 }
 
@@ -340,14 +416,57 @@ func (node *defaultNode) getPublications(callerID string) (interface{}, error) {
 }
 
 func (node *defaultNode) paramUpdate(callerID string, key string, value interface{}) (interface{}, error) {
-	return buildRosAPIResult(-1, "Not implemented", 0), nil
+	node.logger.Debugf("Slave API paramUpdate(%s, %s, ...) called.", callerID, key)
+	node.updateParam(cleanParamKey(key), value)
+	return buildRosAPIResult(APIStatusSuccess, "Success", 0), nil
+}
+
+// updateParam updates the cached value of a subscribed parameter, and queues its callbacks.  The callbacks are
+// handed to a goroutine which waits for room in the callback queue, so that paramUpdate answers at once.
+func (node *defaultNode) updateParam(key string, value interface{}) {
+	calls := node.paramCache.update(key, value)
+	if len(calls) == 0 {
+		return
+	}
+	node.paramCallsMutex.Lock()
+	defer node.paramCallsMutex.Unlock()
+	for _, call := range calls {
+		node.paramCalls = append(node.paramCalls, paramCall{key, call})
+	}
+	if !node.paramCallsQueued {
+		node.paramCallsQueued = true
+		go node.queueParamCalls()
+	}
+}
+
+// queueParamCalls moves the waiting parameter callbacks to the callback queue, in order, until there are none.
+func (node *defaultNode) queueParamCalls() {
+	for {
+		node.paramCallsMutex.Lock()
+		if len(node.paramCalls) == 0 {
+			node.paramCallsQueued = false
+			node.paramCallsMutex.Unlock()
+			return
+		}
+		pc := node.paramCalls[0]
+		node.paramCalls = node.paramCalls[1:]
+		node.paramCallsMutex.Unlock()
+		select {
+		case node.queue.jobChan <- callbackJob{node.paramCache, pc.call}:
+		case <-time.After(time.Duration(3) * time.Second):
+			node.logger.Debugf("Parameter callback job for %s timed out.", pc.key)
+		}
+	}
 }
 
 func (node *defaultNode) publisherUpdate(callerID string, topic string, publishers []interface{}) (interface{}, error) {
 	node.logger.Debug("Slave API publisherUpdate() called.")
 	var code int32
 	var message string
-	if sub, ok := node.subscribers[topic]; !ok {
+	node.registryMutex.RLock()
+	sub, ok := node.subscribers[topic]
+	node.registryMutex.RUnlock()
+	if !ok {
 		node.logger.Debug("publisherUpdate() called without subscribing topic.")
 		code = 0
 		message = "No such topic"
@@ -394,6 +513,16 @@ func (node *defaultNode) requestTopic(callerID string, topic string, protocols [
 				selectedProtocol = append(selectedProtocol, port)
 				break
 			}
+			if protocolName == protocolUDPROS {
+				node.logger.Debug("UDPROS requested")
+				params, err := pub.(*defaultPublisher).acceptUDPROS(protocolParams)
+				if err != nil {
+					node.logger.Warn("UDPROS request for ", topic, " from ", callerID, " refused: ", err)
+					continue
+				}
+				selectedProtocol = params
+				break
+			}
 		}
 		node.logger.Debug(selectedProtocol)
 		code = 1
@@ -403,16 +532,31 @@ func (node *defaultNode) requestTopic(callerID string, topic string, protocols [
 	return buildRosAPIResult(code, message, value), nil
 }
 
-func (node *defaultNode) NewPublisher(topic string, msgType MessageType) (Publisher, error) {
-	name := node.nameResolver.remap(topic)
-	return node.NewPublisherWithCallbacks(name, msgType, nil, nil)
+func (node *defaultNode) NewPublisher(topic string, msgType MessageType, options ...OptionPublisher) (Publisher, error) {
+	return node.newPublisher(context.Background(), topic, msgType, nil, nil, options)
+}
+
+func (node *defaultNode) NewPublisherContext(ctx context.Context, topic string, msgType MessageType, options ...OptionPublisher) (Publisher, error) {
+	return node.newPublisher(ctx, topic, msgType, nil, nil, options)
+}
+
+func (node *defaultNode) NewPublisherWithCallbacks(topic string, msgType MessageType, connectCallback, disconnectCallback func(SingleSubscriberPublisher), options ...OptionPublisher) (Publisher, error) {
+	return node.newPublisher(context.Background(), topic, msgType, connectCallback, disconnectCallback, options)
+}
+
+func (node *defaultNode) NewPublisherWithCallbacksContext(ctx context.Context, topic string, msgType MessageType, connectCallback, disconnectCallback func(SingleSubscriberPublisher), options ...OptionPublisher) (Publisher, error) {
+	return node.newPublisher(ctx, topic, msgType, connectCallback, disconnectCallback, options)
 }
 
-func (node *defaultNode) NewPublisherWithCallbacks(topic string, msgType MessageType, connectCallback, disconnectCallback func(SingleSubscriberPublisher)) (Publisher, error) {
+func (node *defaultNode) newPublisher(ctx context.Context, topic string, msgType MessageType, connectCallback, disconnectCallback func(SingleSubscriberPublisher), options []OptionPublisher) (Publisher, error) {
 	name := node.nameResolver.remap(topic)
-	pub, ok := node.publishers.Load(topic)
+	opts, err := newPublisherOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := node.publishers.Load(name)
 	if !ok {
-		_, err := callRosAPI(node.masterURI, "registerPublisher",
+		_, err := callRosAPIContext(ctx, node.masterURI, "registerPublisher",
 			node.qualifiedName,
 			name, msgType.Name(),
 			node.xmlrpcURI)
@@ -421,7 +565,7 @@ func (node *defaultNode) NewPublisherWithCallbacks(topic string, msgType Message
 			return nil, err
 		}
 
-		pub = newDefaultPublisher(node, name, msgType, connectCallback, disconnectCallback)
+		pub = newDefaultPublisher(node, name, msgType, connectCallback, disconnectCallback, opts)
 		node.publishers.Store(name, pub)
 		go pub.(*defaultPublisher).start(&node.waitGroup)
 	}
@@ -429,8 +573,12 @@ func (node *defaultNode) NewPublisherWithCallbacks(topic string, msgType Message
 }
 
 func (node *defaultNode) GetPublishedTopics(subgraph string) ([]interface{}, error) {
+	return node.GetPublishedTopicsContext(context.Background(), subgraph)
+}
+
+func (node *defaultNode) GetPublishedTopicsContext(ctx context.Context, subgraph string) ([]interface{}, error) {
 	node.logger.Debug("Call Master API getPublishedTopics")
-	result, err := callRosAPI(node.masterURI, "getPublishedTopics",
+	result, err := callRosAPIContext(ctx, node.masterURI, "getPublishedTopics",
 		node.qualifiedName,
 		subgraph)
 	if err != nil {
@@ -446,35 +594,54 @@ func (node *defaultNode) GetPublishedTopics(subgraph string) ([]interface{}, err
 }
 
 func (node *defaultNode) GetTopicTypes() []interface{} {
+	list, _ := node.GetTopicTypesContext(context.Background())
+	return list
+}
+
+func (node *defaultNode) GetTopicTypesContext(ctx context.Context) ([]interface{}, error) {
 	node.logger.Debug("Call Master API getTopicTypes")
-	result, err := callRosAPI(node.masterURI, "getTopicTypes",
+	result, err := callRosAPIContext(ctx, node.masterURI, "getTopicTypes",
 		node.qualifiedName)
 	if err != nil {
 		node.logger.Errorf("Failed to call getTopicTypes() for %s.", err)
+		return nil, err
 	}
 	list, ok := result.([]interface{})
 	if !ok {
 		node.logger.Errorf("result is not []string but %s.", reflect.TypeOf(result).String())
 	}
 	node.logger.Debug("Result: ", list)
-	return list
+	return list, nil
 }
 
 // RemoveSubscriber shuts down and deletes an existing topic subscriber.
 func (node *defaultNode) RemoveSubscriber(topic string) {
 	name := node.nameResolver.remap(topic)
-	if sub, ok := node.subscribers[name]; ok {
+	node.registryMutex.Lock()
+	sub, ok := node.subscribers[name]
+	delete(node.subscribers, name)
+	node.registryMutex.Unlock()
+	if ok {
 		sub.Shutdown()
-		delete(node.subscribers, name)
 	}
 }
 
-func (node *defaultNode) NewSubscriber(topic string, msgType MessageType, callback interface{}) (Subscriber, error) {
+func (node *defaultNode) NewSubscriber(topic string, msgType MessageType, callback interface{}, options ...OptionSubscriber) (Subscriber, error) {
+	return node.NewSubscriberContext(context.Background(), topic, msgType, callback, options...)
+}
+
+func (node *defaultNode) NewSubscriberContext(ctx context.Context, topic string, msgType MessageType, callback interface{}, options ...OptionSubscriber) (Subscriber, error) {
+	opts, err := newSubscriberOptions(options)
+	if err != nil {
+		return nil, err
+	}
 	name := node.nameResolver.remap(topic)
+	node.registryMutex.RLock()
 	sub, ok := node.subscribers[name]
+	node.registryMutex.RUnlock()
 	if !ok {
 		node.logger.Debug("Call Master API registerSubscriber")
-		result, err := callRosAPI(node.masterURI, "registerSubscriber",
+		result, err := callRosAPIContext(ctx, node.masterURI, "registerSubscriber",
 			node.qualifiedName,
 			name,
 			msgType.Name(),
@@ -498,11 +665,18 @@ func (node *defaultNode) NewSubscriber(topic string, msgType MessageType, callba
 
 		node.logger.Debugf("Publisher URI list: %v", publishers)
 
-		sub = newDefaultSubscriber(name, msgType, callback)
+		sub = newDefaultSubscriber(name, msgType, callback, opts)
+		if sub.callbackQueue == nil {
+			sub.callbackQueue = node.queue
+		}
+		sub.hostname = node.hostname
+		sub.listenIP = node.listenIP
+		node.registryMutex.Lock()
 		node.subscribers[name] = sub
+		node.registryMutex.Unlock()
 
 		node.logger.Debugf("Start subscriber goroutine for topic '%s'", sub.topic)
-		go sub.start(&node.waitGroup, node.qualifiedName, node.xmlrpcURI, node.masterURI, node.jobChan, &node.logger)
+		go sub.start(node.closeCtx, &node.waitGroup, node.qualifiedName, node.xmlrpcURI, node.masterURI, &node.logger)
 		node.logger.Debugf("Done")
 		sub.pubListChan <- publishers
 		node.logger.Debugf("Update publisher list for topic '%s'", sub.topic)
@@ -512,61 +686,188 @@ func (node *defaultNode) NewSubscriber(topic string, msgType MessageType, callba
 	return sub, nil
 }
 
-func (node *defaultNode) NewServiceClient(service string, srvType ServiceType) ServiceClient {
+func (node *defaultNode) NewServiceClient(service string, srvType ServiceType, options ...OptionServiceClient) ServiceClient {
+	opts, err := newServiceClientOptions(options)
+	if err != nil {
+		node.logger.Errorf("Invalid options of service client %s: %v", service, err)
+		return nil
+	}
 	name := node.nameResolver.remap(service)
-	client := newDefaultServiceClient(&node.logger, node.qualifiedName, node.masterURI, name, srvType)
+	client := newDefaultServiceClient(&node.logger, node.qualifiedName, node.masterURI, name, srvType, opts)
 	return client
 }
 
-func (node *defaultNode) NewServiceServer(service string, srvType ServiceType, handler interface{}) ServiceServer {
+func (node *defaultNode) ProbeService(service string) (*ServiceInfo, error) {
+	return node.ProbeServiceContext(context.Background(), service)
+}
+
+func (node *defaultNode) ProbeServiceContext(ctx context.Context, service string) (*ServiceInfo, error) {
 	name := node.nameResolver.remap(service)
+	client := newDefaultServiceClient(&node.logger, node.qualifiedName, node.masterURI, name, nil, &ServiceClientOptions{})
+	resHeaderMap, err := client.probe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceInfo{name, resHeaderMap["type"], resHeaderMap["md5sum"], resHeaderMap["callerid"]}, nil
+}
+
+func (node *defaultNode) NewTimer(period Duration, callback func(TimerEvent), oneshot bool) Timer {
+	return node.newTimer(node.clock, period, callback, oneshot)
+}
+
+func (node *defaultNode) NewWallTimer(period Duration, callback func(TimerEvent), oneshot bool) Timer {
+	return node.newTimer(wallClock{}, period, callback, oneshot)
+}
+
+func (node *defaultNode) newTimer(clock clock, period Duration, callback func(TimerEvent), oneshot bool) Timer {
+	timer := newDefaultTimer(node.queue, clock, period, callback, oneshot)
+	node.timers = append(node.timers, timer)
+	timer.Start()
+	return timer
+}
+
+func (node *defaultNode) NewServiceServer(service string, srvType ServiceType, handler interface{}, options ...OptionServiceServer) ServiceServer {
+	server, err := node.NewServiceServerContext(context.Background(), service, srvType, handler, options...)
+	if err != nil {
+		return nil
+	}
+	return server
+}
+
+func (node *defaultNode) NewServiceServerContext(ctx context.Context, service string, srvType ServiceType, handler interface{}, options ...OptionServiceServer) (ServiceServer, error) {
+	opts, err := newServiceServerOptions(options)
+	if err != nil {
+		node.logger.Errorf("Invalid options of service server %s: %v", service, err)
+		return nil, err
+	}
+	if err := validateServiceHandler(handler, srvType); err != nil {
+		node.logger.Errorf("Invalid handler of service server %s: %v", service, err)
+		return nil, err
+	}
+	name := node.nameResolver.remap(service)
+	node.registryMutex.RLock()
 	server, ok := node.servers[name]
+	node.registryMutex.RUnlock()
 	if ok {
 		server.Shutdown()
 	}
-	server = newDefaultServiceServer(node, name, srvType, handler)
-	if server == nil {
-		return nil
+	server, err = newDefaultServiceServer(ctx, node, name, srvType, handler, opts)
+	if err != nil {
+		return nil, err
 	}
+	node.registryMutex.Lock()
 	node.servers[name] = server
-	return server
+	node.registryMutex.Unlock()
+	return server, nil
 }
 
 func (node *defaultNode) SpinOnce() bool {
-	timeoutChan := time.After(10 * time.Millisecond)
-	select {
-	case job := <-node.jobChan:
-		job()
-		return false
-	case <-timeoutChan:
-		break
-	}
-	return true
+	return !node.queue.CallOne(10 * time.Millisecond)
 }
 
 func (node *defaultNode) Spin() {
 	for node.OK() {
-		timeoutChan := time.After(1000 * time.Millisecond)
-		select {
-		case job := <-node.jobChan:
-			node.logger.Debug("Execute job")
-			job()
-		case <-timeoutChan:
-			break
-		}
+		node.queue.CallOne(1000 * time.Millisecond)
 	}
 }
 
+// SubscribeMasterState adds a callback which is called from the spin thread when the master stops answering,
+// answers again, or was restarted and the node registered with it again.
+func (node *defaultNode) SubscribeMasterState(callback func(MasterEvent)) {
+	if node.masterWatchdog == nil {
+		node.logger.Warn("The master watchdog is disabled; master state callbacks are never called.")
+		return
+	}
+	node.masterWatchdog.subscribe(callback)
+}
+
+func (node *defaultNode) CallbackQueue() *CallbackQueue {
+	return node.queue
+}
+
 func (node *defaultNode) Shutdown() {
+	node.ShutdownContext(context.Background())
+}
+
+// waitContext waits for wait to return, or for ctx to be done.
+func waitContext(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeWhenDone closes conn once ctx is done, until the returned function is called.
+func closeWhenDone(ctx context.Context, conn io.Closer) func() {
+	stopChan := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stopChan:
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(stopChan) }) }
+}
+
+// ShutdownContext shuts the node down like Shutdown.  Once ctx is done, it stops waiting: the master calls
+// still in progress are cancelled, the connections of the publishers, subscribers and service servers are
+// closed, what is left finishes in the background, and ctx.Err() is returned.
+func (node *defaultNode) ShutdownContext(ctx context.Context) error {
 	node.logger.Debug("Shutting node down")
 	node.okMutex.Lock()
 	node.ok = false
 	node.okMutex.Unlock()
+
+	node.registryMutex.RLock()
+	subscribers := make([]*defaultSubscriber, 0, len(node.subscribers))
+	for _, sub := range node.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	servers := make([]*defaultServiceServer, 0, len(node.servers))
+	for _, server := range node.servers {
+		servers = append(servers, server)
+	}
+	node.registryMutex.RUnlock()
+
+	var err error
+	// wait waits for a step of the shutdown until ctx is done; after that, the steps are left to the background.
+	wait := func(step func()) {
+		if err == nil {
+			if err = waitContext(ctx, step); err != nil {
+				node.forceClose()
+			}
+		} else {
+			go step()
+		}
+	}
+	if node.masterWatchdog != nil {
+		wait(node.masterWatchdog.close)
+	}
+	for _, t := range node.timers {
+		t.Stop()
+	}
+	if node.rosoutHook != nil {
+		wait(node.rosoutHook.close)
+	}
 	node.logger.Debug("Shutdown subscribers")
-	for _, s := range node.subscribers {
+	for _, s := range subscribers {
 		s.Shutdown()
 	}
 	node.logger.Debug("Shutdown subscribers...done")
+	if node.clockSpinner != nil {
+		wait(node.clockSpinner.Stop)
+		node.clockSpinner = nil
+		simTime.release()
+	}
 	node.logger.Debug("Shutdown publishers")
 	node.publishers.Range(func(key interface{}, value interface{}) bool {
 		value.(*defaultPublisher).Shutdown()
@@ -574,37 +875,64 @@ func (node *defaultNode) Shutdown() {
 	})
 	node.logger.Debug("Shutdown publishers...done")
 	node.logger.Debug("Shutdown servers")
-	for _, s := range node.servers {
+	for _, s := range servers {
 		s.Shutdown()
 	}
 	node.logger.Debug("Shutdown servers...done")
+	for _, key := range node.paramCache.keys() {
+		if _, err := callRosAPIContext(ctx, node.masterURI, "unsubscribeParam", node.qualifiedName, node.xmlrpcURI, key); err != nil {
+			node.logger.Warn(err)
+		}
+	}
 	node.logger.Debug("Wait all goroutines")
-	node.waitGroup.Wait()
+	wait(node.waitGroup.Wait)
+	for _, s := range servers {
+		server := s
+		wait(func() { <-server.doneChan })
+	}
 	node.logger.Debug("Wait all goroutines...Done")
 	node.logger.Debug("Close XMLRPC lisetner")
 	node.xmlrpcListener.Close()
 	node.logger.Debug("Close XMLRPC done")
 	node.logger.Debug("Wait XMLRPC server shutdown")
-	node.xmlrpcHandler.WaitForShutdown()
+	wait(node.xmlrpcHandler.WaitForShutdown)
 	node.logger.Debug("Wait XMLRPC server shutdown...Done")
+	if err != nil {
+		node.logger.Warnf("Forced to shut down: %v", err)
+	}
 	node.logger.Debug("Shutting node down completed")
-	return
+	if node.logFile != nil {
+		node.logFile.Close()
+	}
+	return err
 }
 
 func (node *defaultNode) GetParam(key string) (interface{}, error) {
+	return node.GetParamContext(context.Background(), key)
+}
+
+func (node *defaultNode) GetParamContext(ctx context.Context, key string) (interface{}, error) {
 	name := node.nameResolver.remap(key)
-	return callRosAPI(node.masterURI, "getParam", node.qualifiedName, name)
+	return callRosAPIContext(ctx, node.masterURI, "getParam", node.qualifiedName, name)
 }
 
 func (node *defaultNode) SetParam(key string, value interface{}) error {
+	return node.SetParamContext(context.Background(), key, value)
+}
+
+func (node *defaultNode) SetParamContext(ctx context.Context, key string, value interface{}) error {
 	name := node.nameResolver.remap(key)
-	_, e := callRosAPI(node.masterURI, "setParam", node.qualifiedName, name, value)
+	_, e := callRosAPIContext(ctx, node.masterURI, "setParam", node.qualifiedName, name, value)
 	return e
 }
 
 func (node *defaultNode) HasParam(key string) (bool, error) {
+	return node.HasParamContext(context.Background(), key)
+}
+
+func (node *defaultNode) HasParamContext(ctx context.Context, key string) (bool, error) {
 	name := node.nameResolver.remap(key)
-	result, err := callRosAPI(node.masterURI, "hasParam", node.qualifiedName, name)
+	result, err := callRosAPIContext(ctx, node.masterURI, "hasParam", node.qualifiedName, name)
 	if err != nil {
 		return false, err
 	}
@@ -613,7 +941,11 @@ func (node *defaultNode) HasParam(key string) (bool, error) {
 }
 
 func (node *defaultNode) SearchParam(key string) (string, error) {
-	result, err := callRosAPI(node.masterURI, "searchParam", node.qualifiedName, key)
+	return node.SearchParamContext(context.Background(), key)
+}
+
+func (node *defaultNode) SearchParamContext(ctx context.Context, key string) (string, error) {
+	result, err := callRosAPIContext(ctx, node.masterURI, "searchParam", node.qualifiedName, key)
 	if err != nil {
 		return "", err
 	}
@@ -622,11 +954,80 @@ func (node *defaultNode) SearchParam(key string) (string, error) {
 }
 
 func (node *defaultNode) DeleteParam(key string) error {
+	return node.DeleteParamContext(context.Background(), key)
+}
+
+func (node *defaultNode) DeleteParamContext(ctx context.Context, key string) error {
 	name := node.nameResolver.remap(key)
-	_, err := callRosAPI(node.masterURI, "deleteParam", node.qualifiedName, name)
+	_, err := callRosAPIContext(ctx, node.masterURI, "deleteParam", node.qualifiedName, name)
 	return err
 }
 
+// SubscribeParam subscribes to the parameter key with the master, which reports changes of the parameter,
+// and of any parameter inside it, to the node.  The cached value is kept up to date, and callback (which may
+// be nil) is called from the spin thread with the key and the new value of each changed parameter.  A
+// deleted parameter is reported with an empty map[string]interface{} value.
+func (node *defaultNode) SubscribeParam(key string, callback func(key string, value interface{})) error {
+	return node.SubscribeParamContext(context.Background(), key, callback)
+}
+
+func (node *defaultNode) SubscribeParamContext(ctx context.Context, key string, callback func(key string, value interface{})) error {
+	name := node.nameResolver.remap(key)
+	if !node.paramCache.subscribe(name, callback) {
+		return nil
+	}
+	value, err := callRosAPIContext(ctx, node.masterURI, "subscribeParam", node.qualifiedName, node.xmlrpcURI, name)
+	if err != nil {
+		node.paramCache.unsubscribe(name)
+		return err
+	}
+	node.paramCache.set(name, value)
+	return nil
+}
+
+// UnsubscribeParam removes the subscription to the parameter key, and all of its callbacks.
+func (node *defaultNode) UnsubscribeParam(key string) error {
+	return node.UnsubscribeParamContext(context.Background(), key)
+}
+
+func (node *defaultNode) UnsubscribeParamContext(ctx context.Context, key string) error {
+	name := node.nameResolver.remap(key)
+	if !node.paramCache.unsubscribe(name) {
+		return nil
+	}
+	_, err := callRosAPIContext(ctx, node.masterURI, "unsubscribeParam", node.qualifiedName, node.xmlrpcURI, name)
+	return err
+}
+
+// GetParamCached returns the value of the parameter key like GetParam, but the parameter is subscribed to on
+// first use, and later calls return the cached value without asking the master.
+func (node *defaultNode) GetParamCached(key string) (interface{}, error) {
+	return node.GetParamCachedContext(context.Background(), key)
+}
+
+func (node *defaultNode) GetParamCachedContext(ctx context.Context, key string) (interface{}, error) {
+	name := node.nameResolver.remap(key)
+	value, subscribed, valid := node.paramCache.get(name)
+	if !subscribed {
+		if err := node.SubscribeParamContext(ctx, name, nil); err != nil {
+			return nil, err
+		}
+		value, _, valid = node.paramCache.get(name)
+	}
+	if !valid {
+		// A namespace which changed since it was cached.
+		var err error
+		if value, err = callRosAPIContext(ctx, node.masterURI, "getParam", node.qualifiedName, name); err != nil {
+			return nil, err
+		}
+		node.paramCache.set(name, value)
+	}
+	if isParamUnset(value) {
+		return nil, fmt.Errorf("parameter [%s] is not set", name)
+	}
+	return value, nil
+}
+
 func (node *defaultNode) Logger() *modular.ModuleLogger {
 	return &node.logger
 }
@@ -0,0 +1,264 @@
+package ros
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	modular "github.com/edwinhayes/logrus-modular"
+	"github.com/sirupsen/logrus"
+)
+
+// NodeOptions are the settings of a node.  NewNode takes them from remapping arguments such as __master:= and
+// __ns:=, NewNodeWithOptions from options; a setting which is given neither way comes from the environment
+// variables, like in roscpp.
+type NodeOptions struct {
+	// MasterURI is the URI of the master; ROS_MASTER_URI by default.
+	MasterURI string
+	// Namespace is the namespace of the node; ROS_NAMESPACE by default, or else the namespace of its name.
+	Namespace string
+	// Hostname is the host name or IP which the node advertises; ROS_HOSTNAME or ROS_IP by default, or else
+	// the name of the host.
+	Hostname string
+	// ListenIP is the IP on which the node accepts connections; 127.0.0.1 by default if Hostname is a
+	// loopback address, or else 0.0.0.0.
+	ListenIP string
+	// Remappings maps names used by the node to the names they stand for.
+	Remappings NameMap
+	// LogLevel is the level of the entries which the node writes to the console; fatal by default.
+	LogLevel logrus.Level
+	// SignalHandling stops the node on an interrupt signal; true by default.
+	SignalHandling bool
+	// Logger is the logger of the node; nil is a logger of its own.
+	Logger modular.ModuleLogger
+	// PrivateParams are set as private parameters of the node when it starts.
+	PrivateParams map[string]interface{}
+	// LogFileMaxSize is the size in bytes beyond which the log file of the node is rotated; 10 MiB by default.
+	LogFileMaxSize int64
+	// LogFileMaxBackups is the number of rotated log files which are kept; 5 by default.
+	LogFileMaxBackups int
+
+	// Settings which only arguments give.
+	name           string
+	logDir         string
+	rosoutLevel    logrus.Level
+	loggerServices bool
+	masterWatchdog bool
+	nonRosArgs     []string
+}
+
+// OptionNode changes the settings of a node.
+type OptionNode func(*NodeOptions) error
+
+// OptionNodeArgs takes settings from command line arguments, like NewNode: remapping arguments such as
+// __master:=, private parameters such as _rate:=, and remappings.  Other arguments are left to NonRosArgs.
+func OptionNodeArgs(args []string) OptionNode {
+	return func(opts *NodeOptions) error {
+		remapping, params, specials, rest := processArguments(args)
+		if value, ok := specials["__name"]; ok {
+			opts.name = value
+		}
+		if value, ok := specials["__ns"]; ok {
+			opts.Namespace = value
+		}
+		if value, ok := specials["__master"]; ok {
+			opts.MasterURI = value
+		}
+		if value, ok := specials["__hostname"]; ok {
+			opts.Hostname = value
+		} else if value, ok := specials["__ip"]; ok {
+			opts.Hostname = value
+		}
+		if value, ok := specials["__ll"]; ok {
+			opts.LogLevel = logrus.InfoLevel
+			if level, err := parseLogLevel(value); err == nil {
+				opts.LogLevel = level
+			}
+		}
+		if value, ok := specials["__rosout_ll"]; ok {
+			if level, err := parseLogLevel(value); err == nil {
+				opts.rosoutLevel = level
+			}
+		}
+		if value, ok := specials["__si"]; ok {
+			if enable, err := strconv.ParseBool(value); err == nil {
+				opts.SignalHandling = enable
+			}
+		}
+		if value, ok := specials["__log"]; ok {
+			opts.logDir = value
+		}
+		if value, ok := specials["__log_max_size"]; ok {
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil && size > 0 {
+				opts.LogFileMaxSize = size
+			}
+		}
+		if value, ok := specials["__log_max_backups"]; ok {
+			if backups, err := strconv.Atoi(value); err == nil && backups >= 0 {
+				opts.LogFileMaxBackups = backups
+			}
+		}
+		if value, ok := specials["__logger_services"]; ok {
+			opts.loggerServices = value != "false"
+		}
+		if value, ok := specials["__master_watchdog"]; ok {
+			opts.masterWatchdog = value != "false"
+		}
+		for k, v := range remapping {
+			opts.Remappings[k] = v
+		}
+		for k, v := range params {
+			opts.PrivateParams[k] = v
+		}
+		opts.nonRosArgs = append(opts.nonRosArgs, rest...)
+		return nil
+	}
+}
+
+// OptionNodeMasterURI sets the URI of the master, like __master:=.
+func OptionNodeMasterURI(uri string) OptionNode {
+	return func(opts *NodeOptions) error {
+		if u, err := url.Parse(uri); err != nil || u.Host == "" {
+			return fmt.Errorf("invalid master URI %q", uri)
+		}
+		opts.MasterURI = uri
+		return nil
+	}
+}
+
+// OptionNodeNamespace sets the namespace of the node, like __ns:=.
+func OptionNodeNamespace(namespace string) OptionNode {
+	return func(opts *NodeOptions) error {
+		if isPrivateName(namespace) || !isValidName(namespace) {
+			return fmt.Errorf("invalid namespace %q", namespace)
+		}
+		opts.Namespace = namespace
+		return nil
+	}
+}
+
+// OptionNodeHostname sets the host name or IP which the node advertises, like __hostname:= or __ip:=.  The IP
+// on which the node listens follows it, unless set by OptionNodeListenIP.
+func OptionNodeHostname(hostname string) OptionNode {
+	return func(opts *NodeOptions) error {
+		if hostname == "" {
+			return fmt.Errorf("empty hostname")
+		}
+		opts.Hostname = hostname
+		return nil
+	}
+}
+
+// OptionNodeListenIP sets the IP on which the node accepts connections.
+func OptionNodeListenIP(ip string) OptionNode {
+	return func(opts *NodeOptions) error {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid listen IP %q", ip)
+		}
+		opts.ListenIP = ip
+		return nil
+	}
+}
+
+// OptionNodeRemap remaps the name from to the name to, like the argument from:=to.
+func OptionNodeRemap(from, to string) OptionNode {
+	return func(opts *NodeOptions) error {
+		if !isValidName(from) || !isValidName(to) {
+			return fmt.Errorf("invalid remapping %s%s%s", from, Remap, to)
+		}
+		opts.Remappings[from] = to
+		return nil
+	}
+}
+
+// OptionNodeLogLevel sets the level of the entries which the node writes to the console, like __ll:=.
+func OptionNodeLogLevel(level logrus.Level) OptionNode {
+	return func(opts *NodeOptions) error {
+		opts.LogLevel = level
+		return nil
+	}
+}
+
+// OptionNodeSignalHandling sets whether an interrupt signal stops the node, like __si:=.
+func OptionNodeSignalHandling(enable bool) OptionNode {
+	return func(opts *NodeOptions) error {
+		opts.SignalHandling = enable
+		return nil
+	}
+}
+
+// OptionNodeLogger makes the node log to logger, like NewNodeWithLogs.  The entries of logger are published to
+// /rosout and written to the log file of the node, but not to the console.
+func OptionNodeLogger(logger modular.ModuleLogger) OptionNode {
+	return func(opts *NodeOptions) error {
+		opts.Logger = logger
+		return nil
+	}
+}
+
+// OptionNodePrivateParam sets the private parameter ~key of the node when it starts, like _key:=value.
+func OptionNodePrivateParam(key string, value interface{}) OptionNode {
+	return func(opts *NodeOptions) error {
+		if isGlobalName(key) || isPrivateName(key) || !isValidName(key) {
+			return fmt.Errorf("invalid private parameter %q", key)
+		}
+		opts.PrivateParams[key] = value
+		return nil
+	}
+}
+
+// OptionNodeLogFile sets the size in bytes beyond which the log file of the node is rotated, and the number of
+// rotated files which are kept, like __log_max_size:= and __log_max_backups:=.
+func OptionNodeLogFile(maxSize int64, maxBackups int) OptionNode {
+	return func(opts *NodeOptions) error {
+		if maxSize < 1 {
+			return fmt.Errorf("log file size must be at least 1 byte, not %d", maxSize)
+		}
+		if maxBackups < 0 {
+			return fmt.Errorf("negative number of log file backups %d", maxBackups)
+		}
+		opts.LogFileMaxSize = maxSize
+		opts.LogFileMaxBackups = maxBackups
+		return nil
+	}
+}
+
+// isLoopbackHost reports whether hostname only reaches the local host.
+func isLoopbackHost(hostname string) bool {
+	return hostname == "localhost" || hostname == "::1" || strings.HasPrefix(hostname, "127.")
+}
+
+func newNodeOptions(options []OptionNode) (*NodeOptions, error) {
+	opts := &NodeOptions{
+		MasterURI:         os.Getenv("ROS_MASTER_URI"),
+		Remappings:        make(NameMap),
+		LogLevel:          logrus.FatalLevel,
+		SignalHandling:    defaultInterrupts,
+		PrivateParams:     make(map[string]interface{}),
+		LogFileMaxSize:    defaultLogFileMaxSize,
+		LogFileMaxBackups: defaultLogFileMaxBackups,
+		rosoutLevel:       logrus.InfoLevel,
+		loggerServices:    true,
+		masterWatchdog:    true,
+	}
+	if ns := os.Getenv("ROS_NAMESPACE"); len(ns) > 0 {
+		opts.Namespace = ns
+	}
+	opts.Hostname, _ = determineHost()
+	for _, opt := range options {
+		if err := opt(opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ListenIP == "" {
+		if isLoopbackHost(opts.Hostname) {
+			opts.ListenIP = "127.0.0.1"
+		} else {
+			opts.ListenIP = "0.0.0.0"
+		}
+	}
+	return opts, nil
+}
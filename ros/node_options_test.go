@@ -0,0 +1,108 @@
+package ros
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNodeOptionsPrecedence(t *testing.T) {
+	defer os.Setenv("ROS_MASTER_URI", os.Getenv("ROS_MASTER_URI"))
+	defer os.Setenv("ROS_NAMESPACE", os.Getenv("ROS_NAMESPACE"))
+	os.Setenv("ROS_MASTER_URI", "http://env:11311/")
+	os.Setenv("ROS_NAMESPACE", "/env")
+
+	opts, err := newNodeOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.MasterURI != "http://env:11311/" || opts.Namespace != "/env" {
+		t.Errorf("expected the settings of the environment, got %+v", opts)
+	}
+	if opts.LogLevel != logrus.FatalLevel || !opts.SignalHandling {
+		t.Errorf("unexpected defaults %+v", opts)
+	}
+
+	// Options override arguments before them, which override the environment.
+	opts, err = newNodeOptions([]OptionNode{
+		OptionNodeArgs([]string{"__master:=http://args:11311/", "__ns:=/args", "__ll:=5", "__si:=false",
+			"__hostname:=localhost", "__log_max_size:=1024", "__log_max_backups:=0", "_rate:=10", "chatter:=news", "rest"}),
+		OptionNodeMasterURI("http://option:11311/"),
+		OptionNodePrivateParam("mode", "fast"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.MasterURI != "http://option:11311/" || opts.Namespace != "/args" || opts.LogLevel != logrus.DebugLevel ||
+		opts.SignalHandling {
+		t.Errorf("unexpected settings %+v", opts)
+	}
+	if opts.LogFileMaxSize != 1024 || opts.LogFileMaxBackups != 0 {
+		t.Errorf("unexpected log file limits %d, %d", opts.LogFileMaxSize, opts.LogFileMaxBackups)
+	}
+	if opts.Hostname != "localhost" || opts.ListenIP != "127.0.0.1" {
+		t.Errorf("expected to listen on the loopback address, got %s for %s", opts.ListenIP, opts.Hostname)
+	}
+	if opts.Remappings["chatter"] != "news" || opts.PrivateParams["rate"] != "10" || opts.PrivateParams["mode"] != "fast" {
+		t.Errorf("unexpected remappings %v and parameters %v", opts.Remappings, opts.PrivateParams)
+	}
+	if len(opts.nonRosArgs) != 1 || opts.nonRosArgs[0] != "rest" {
+		t.Errorf("unexpected arguments %v", opts.nonRosArgs)
+	}
+
+	// The listen IP is kept whatever the hostname.
+	opts, err = newNodeOptions([]OptionNode{OptionNodeListenIP("0.0.0.0"), OptionNodeHostname("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ListenIP != "0.0.0.0" {
+		t.Errorf("expected the listen IP of the option, got %s", opts.ListenIP)
+	}
+}
+
+func TestNodeOptionsValidation(t *testing.T) {
+	for _, option := range []OptionNode{
+		OptionNodeMasterURI("localhost"),
+		OptionNodeNamespace("~private"),
+		OptionNodeNamespace("not a name"),
+		OptionNodeHostname(""),
+		OptionNodeListenIP("localhost"),
+		OptionNodeRemap("chatter", "no news"),
+		OptionNodePrivateParam("/global", 1),
+		OptionNodeLogFile(0, 1),
+		OptionNodeLogFile(1024, -1),
+	} {
+		if _, err := newNodeOptions([]OptionNode{option}); err == nil {
+			t.Errorf("expected an error for %#v", option)
+		}
+	}
+}
+
+func TestNewNodeWithOptions(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node, err := newDefaultNodeWithOptions("talker",
+		OptionNodeMasterURI(m.URI()),
+		OptionNodeNamespace("/robot"),
+		OptionNodeHostname("127.0.0.1"),
+		OptionNodeRemap("chatter", "news"),
+		OptionNodeSignalHandling(false),
+		OptionNodePrivateParam("rate", 10.0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Shutdown()
+	if node.QualifiedName() != "/robot/talker" {
+		t.Errorf("unexpected name %s", node.QualifiedName())
+	}
+	if value, err := node.GetParam("/robot/talker/rate"); err != nil || value != 10.0 {
+		t.Errorf("expected the private parameter, got %v, %v", value, err)
+	}
+	if _, err := node.NewPublisher("chatter", msgTypeGoalID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := node.publishers.Load("/robot/news"); !ok {
+		t.Error("expected the topic to be remapped")
+	}
+}
@@ -1,9 +1,50 @@
 package ros
 
 import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
 	"testing"
+	"time"
+
+	"github.com/edwinhayes/rosgo/master"
 )
 
+// TestMain keeps the log files of the test nodes out of the home directory.
+func TestMain(m *testing.M) {
+	logDir, err := ioutil.TempDir("", "rosgo-log")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("ROS_LOG_DIR", logDir)
+	code := m.Run()
+	os.RemoveAll(logDir)
+	os.Exit(code)
+}
+
+// newTestMaster starts an in-process ROS master, so that tests do not need a roscore.
+func newTestMaster(t *testing.T) *master.Master {
+	m, err := master.NewMaster("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// newTestNode creates a node registered with the master m.
+func newTestNode(t *testing.T, m *master.Master, name string) *defaultNode {
+	node, err := newDefaultNode(name, []string{"__master:=" + m.URI(), "__ip:=127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
 func TestLoadJsonFromString(t *testing.T) {
 	value, err := loadParamFromString("42")
 	if err != nil {
@@ -17,3 +58,120 @@ func TestLoadJsonFromString(t *testing.T) {
 		t.Error(i)
 	}
 }
+
+// hangMaster shuts the master m down, and accepts the connections to its address without ever answering, like a
+// stuck master.  The returned function closes the connections.
+func hangMaster(t *testing.T, m *master.Master) func() {
+	uri, err := url.Parse(m.URI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Shutdown()
+	// Connections kept alive to the master are gone, rather than stuck.
+	http.DefaultClient.CloseIdleConnections()
+	listener, err := net.Listen("tcp", uri.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	return func() {
+		listener.Close()
+		close(conns)
+		for conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+func TestNodeContext(t *testing.T) {
+	m := newTestMaster(t)
+	node := newTestNode(t, m, "/node")
+	if err := node.SetParamContext(context.Background(), "/rate", 10.0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.NewSubscriberContext(context.Background(), "/chatter", msgTypeGoalID, func(*goalIDMessage) {}); err != nil {
+		t.Fatal(err)
+	}
+	defer hangMaster(t, m)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := node.GetParamContext(ctx, "/rate"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	if _, err := node.GetParamCachedContext(ctx, "/rate"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	if _, err := node.NewPublisherWithCallbacksContext(ctx, "/news", msgTypeGoalID, nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	if _, err := node.NewServiceServerContext(ctx, "/echo", srvTypeEcho, echoHandler); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	// Unregistering from the stuck master is given up.
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := node.ShutdownContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the shutdown to stop at its deadline, took %v", elapsed)
+	}
+}
+
+func TestShutdownContext(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/node")
+	if _, err := node.NewPublisher("/chatter", msgTypeGoalID); err != nil {
+		t.Fatal(err)
+	}
+	if node.NewServiceServer("/echo", srvTypeEcho, echoHandler) == nil {
+		t.Fatal("failed to create the service server")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := node.ShutdownContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := callRosAPI(m.URI(), "lookupNode", "/test", "/node"); err == nil {
+		t.Error("expected the node to be unregistered")
+	}
+}
+
+func TestShutdownContextClosesConnections(t *testing.T) {
+	m := newTestMaster(t)
+	node := newTestNode(t, m, "/node")
+	pub, err := node.NewPublisher("/chatter", msgTypeGoalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A subscriber which connects, but never sends its connection header.
+	conn, err := net.Dial("tcp", pub.(*defaultPublisher).listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	defer hangMaster(t, m)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := node.ShutdownContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the connection to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package ros
+
+import (
+	"strings"
+	"sync"
+)
+
+// paramSubscription is a parameter subscribed to with the master's subscribeParam.  The master reports
+// changes of the parameter, and of anything inside it, with the paramUpdate slave API.
+type paramSubscription struct {
+	value     interface{}
+	valid     bool
+	callbacks []func(key string, value interface{})
+}
+
+// paramCache holds the values of subscribed parameters, which are kept up to date by paramUpdate.  Keys are
+// resolved names without a trailing slash.
+type paramCache struct {
+	mutex         sync.Mutex
+	subscriptions map[string]*paramSubscription
+}
+
+func newParamCache() *paramCache {
+	return &paramCache{subscriptions: make(map[string]*paramSubscription)}
+}
+
+// isParamUnset reports whether value stands for a parameter which is not set; the master reports those as
+// an empty dictionary.
+func isParamUnset(value interface{}) bool {
+	m, ok := value.(map[string]interface{})
+	return ok && len(m) == 0
+}
+
+// cleanParamKey removes the trailing slash with which the master reports parameter keys.
+func cleanParamKey(key string) string {
+	if key != GlobalNS {
+		key = strings.TrimSuffix(key, GlobalNS)
+	}
+	return key
+}
+
+// isParamKeyInside reports whether key is in the namespace ns.
+func isParamKeyInside(key, ns string) bool {
+	if ns == GlobalNS {
+		return key != GlobalNS
+	}
+	return strings.HasPrefix(key, ns+GlobalNS)
+}
+
+func (c *paramCache) get(key string) (interface{}, bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s, subscribed := c.subscriptions[key]
+	if !subscribed || !s.valid {
+		return nil, subscribed, false
+	}
+	return s.value, true, true
+}
+
+// subscribe adds a subscription for key, reporting whether it is a new one.
+func (c *paramCache) subscribe(key string, callback func(string, interface{})) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s, ok := c.subscriptions[key]
+	if !ok {
+		s = &paramSubscription{}
+		c.subscriptions[key] = s
+	}
+	if callback != nil {
+		s.callbacks = append(s.callbacks, callback)
+	}
+	return !ok
+}
+
+func (c *paramCache) unsubscribe(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, ok := c.subscriptions[key]
+	delete(c.subscriptions, key)
+	return ok
+}
+
+func (c *paramCache) set(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if s, ok := c.subscriptions[key]; ok {
+		s.value = value
+		s.valid = true
+	}
+}
+
+func (c *paramCache) keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	keys := make([]string, 0, len(c.subscriptions))
+	for k := range c.subscriptions {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// update applies a paramUpdate from the master, returning the callbacks to call.  A subscribed namespace
+// which contains the updated key is invalidated, to be fetched again on its next use.
+func (c *paramCache) update(key string, value interface{}) []func() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var calls []func()
+	for subKey, s := range c.subscriptions {
+		if subKey == key {
+			s.value = value
+			s.valid = true
+		} else if isParamKeyInside(key, subKey) {
+			s.valid = false
+		} else {
+			continue
+		}
+		for _, callback := range s.callbacks {
+			cb := callback
+			calls = append(calls, func() { cb(key, value) })
+		}
+	}
+	return calls
+}
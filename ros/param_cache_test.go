@@ -0,0 +1,177 @@
+package ros
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParamCacheUpdate(t *testing.T) {
+	c := newParamCache()
+	var updates []string
+	callback := func(key string, value interface{}) { updates = append(updates, key) }
+	c.subscribe("/robot", callback)
+	c.subscribe("/robot/speed", callback)
+	c.subscribe("/other", nil)
+	c.set("/robot", map[string]interface{}{"speed": 1.0})
+	c.set("/robot/speed", 1.0)
+	c.set("/other", 2.0)
+
+	for _, call := range c.update("/robot/speed", 2.0) {
+		call()
+	}
+	if len(updates) != 2 || updates[0] != "/robot/speed" || updates[1] != "/robot/speed" {
+		t.Errorf("unexpected callbacks %v", updates)
+	}
+	if value, _, valid := c.get("/robot/speed"); !valid || value != 2.0 {
+		t.Errorf("expected cached value 2.0, got %v", value)
+	}
+	if _, subscribed, valid := c.get("/robot"); !subscribed || valid {
+		t.Error("namespace containing the updated parameter should be invalidated")
+	}
+	if value, _, valid := c.get("/other"); !valid || value != 2.0 {
+		t.Errorf("unrelated parameter changed to %v", value)
+	}
+	if _, subscribed, _ := c.get("/missing"); subscribed {
+		t.Error("parameter which was never subscribed is reported as subscribed")
+	}
+}
+
+func TestCleanParamKey(t *testing.T) {
+	if key := cleanParamKey("/robot/speed/"); key != "/robot/speed" {
+		t.Error(key)
+	}
+	if key := cleanParamKey("/"); key != "/" {
+		t.Error(key)
+	}
+	if !isParamKeyInside("/robot/speed", "/robot") || isParamKeyInside("/robotic", "/robot") || !isParamKeyInside("/a", "/") {
+		t.Error("isParamKeyInside")
+	}
+}
+
+func TestSubscribeParam(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/controller")
+	defer node.Shutdown()
+	tuner := newTestNode(t, m, "/tuner")
+	defer tuner.Shutdown()
+
+	if err := tuner.SetParam("/controller/gain", 1.5); err != nil {
+		t.Fatal(err)
+	}
+	updates := make(chan interface{}, 10)
+	if err := node.SubscribeParam("~gain", func(key string, value interface{}) {
+		if key != "/controller/gain" {
+			t.Errorf("update of unexpected key %s", key)
+		}
+		updates <- value
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := node.GetParamCached("~gain"); err != nil || value != 1.5 {
+		t.Errorf("GetParamCached returned %v, %v", value, err)
+	}
+
+	expectUpdate := func(expected interface{}) {
+		timeout := time.After(5 * time.Second)
+		for {
+			node.SpinOnce()
+			select {
+			case value := <-updates:
+				if isParamUnset(expected) {
+					if !isParamUnset(value) {
+						t.Errorf("expected deletion, got %v", value)
+					}
+				} else if value != expected {
+					t.Errorf("expected %v, got %v", expected, value)
+				}
+				return
+			case <-timeout:
+				t.Fatalf("timed out waiting for %v", expected)
+			default:
+			}
+		}
+	}
+
+	if err := tuner.SetParam("/controller/gain", 2.5); err != nil {
+		t.Fatal(err)
+	}
+	expectUpdate(2.5)
+	if value, err := node.GetParamCached("~gain"); err != nil || value != 2.5 {
+		t.Errorf("GetParamCached returned %v, %v", value, err)
+	}
+
+	if err := tuner.DeleteParam("/controller/gain"); err != nil {
+		t.Fatal(err)
+	}
+	expectUpdate(map[string]interface{}{})
+	if value, err := node.GetParamCached("~gain"); err == nil {
+		t.Errorf("GetParamCached returned %v for a deleted parameter", value)
+	}
+
+	// Namespaces are fetched again after a parameter inside them changed.
+	if err := tuner.SetParam("/limits/speed", 1.0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.GetParamCached("/limits"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tuner.SetParam("/limits/speed", 3.0); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		value, err := node.GetParamCached("/limits")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value.(map[string]interface{})["speed"] == 3.0 {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("cached namespace was not updated: %v", value)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestParamUpdateDoesNotWait(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/controller")
+	defer node.Shutdown()
+	if err := node.SetParam("~gain", 1.5); err != nil {
+		t.Fatal(err)
+	}
+	updates := make(chan interface{}, 10)
+	if err := node.SubscribeParam("~gain", func(key string, value interface{}) { updates <- value }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The callback queue is full until the node spins.
+	for len(node.queue.jobChan) < cap(node.queue.jobChan) {
+		node.queue.jobChan <- callbackJob{nil, func() {}}
+	}
+	start := time.Now()
+	for _, value := range []float64{2.5, 3.5} {
+		if _, err := callRosAPI(node.xmlrpcURI, "paramUpdate", "/master", "/controller/gain", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("paramUpdate waited %v for the callback queue", elapsed)
+	}
+
+	spinFor(node.CallbackQueue(), 100*time.Millisecond)
+	for _, expected := range []float64{2.5, 3.5} {
+		select {
+		case value := <-updates:
+			if value != expected {
+				t.Errorf("expected %v, got %v", expected, value)
+			}
+		default:
+			t.Fatalf("expected the callback for %v", expected)
+		}
+	}
+}
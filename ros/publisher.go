@@ -3,11 +3,14 @@ package ros
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	modular "github.com/edwinhayes/logrus-modular"
@@ -22,7 +25,48 @@ func (e *remoteSubscriberSessionError) Error() string {
 	return fmt.Sprintf("remoteSubscriberSession %v error: %v", e.session, e.err)
 }
 
+// PublisherOptions are the settings of a publisher, changed by the options passed to NewPublisher.
+type PublisherOptions struct {
+	// Latch keeps the last published message, and sends it to every subscriber when it connects.
+	Latch bool
+	// Queue is the queue of messages waiting to be sent to each subscriber.
+	Queue QueueOptions
+}
+
+// OptionPublisher changes the settings of a publisher.
+type OptionPublisher func(*PublisherOptions) error
+
+// OptionLatch makes a publisher latched, like a static map or robot_description publisher.
+func OptionLatch(latch bool) OptionPublisher {
+	return func(opts *PublisherOptions) error {
+		opts.Latch = latch
+		return nil
+	}
+}
+
+// OptionPublisherQueue sets the size and overflow policy of the queue of each subscriber connection.  The
+// default queue holds 100 messages, and drops the oldest.
+func OptionPublisherQueue(size int, policy OverflowPolicy, timeout time.Duration) OptionPublisher {
+	return func(opts *PublisherOptions) error {
+		opts.Queue = QueueOptions{Size: size, Policy: policy, Timeout: timeout}
+		return opts.Queue.validate()
+	}
+}
+
+func newPublisherOptions(options []OptionPublisher) (*PublisherOptions, error) {
+	opts := &PublisherOptions{
+		Queue: QueueOptions{Size: 100, Policy: QueueDropOldest},
+	}
+	for _, opt := range options {
+		if err := opt(opts); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
 type defaultPublisher struct {
+	messageDataSent    uint64 // First, to keep it 64-bit aligned for atomic access.
 	node               *defaultNode
 	topic              string
 	msgType            MessageType
@@ -35,15 +79,23 @@ type defaultPublisher struct {
 	listener           net.Listener
 	connectCallback    func(SingleSubscriberPublisher)
 	disconnectCallback func(SingleSubscriberPublisher)
+	connStats          connectionStatsMap
+	latch              bool
+	lastMsg            []byte // Last published message of a latched publisher, owned by the publisher goroutine.
+	hasLastMsg         bool   // Whether lastMsg holds a message, which may be empty.
+	queue              QueueOptions
 }
 
 func newDefaultPublisher(node *defaultNode,
 	topic string, msgType MessageType,
-	connectCallback, disconnectCallback func(SingleSubscriberPublisher)) *defaultPublisher {
+	connectCallback, disconnectCallback func(SingleSubscriberPublisher),
+	options *PublisherOptions) *defaultPublisher {
 	pub := new(defaultPublisher)
 	pub.node = node
 	pub.topic = topic
 	pub.msgType = msgType
+	pub.latch = options.Latch
+	pub.queue = options.Queue
 	pub.shutdownChan = make(chan struct{}, 10)
 	pub.msgChan = make(chan []byte, 10)
 	pub.listenerErrorChan = make(chan error, 10)
@@ -76,9 +128,12 @@ func (pub *defaultPublisher) start(wg *sync.WaitGroup) {
 		select {
 		case msg := <-pub.msgChan:
 			logger.Debug("Receive msgChan")
+			if pub.latch {
+				pub.lastMsg = msg
+				pub.hasLastMsg = true
+			}
 			for e := pub.sessions.Front(); e != nil; e = e.Next() {
-				session := e.Value.(*remoteSubscriberSession)
-				session.msgChan <- msg
+				e.Value.(*remoteSubscriberSession).enqueue(msg)
 			}
 		case err := <-pub.listenerErrorChan:
 			logger.Debugf("Listener closed unexpectedly: %s", err)
@@ -86,13 +141,21 @@ func (pub *defaultPublisher) start(wg *sync.WaitGroup) {
 			return
 		case s := <-pub.sessionChan:
 			pub.sessions.PushBack(s)
+			if pub.hasLastMsg {
+				// The queue of a new session is empty, so the latched message goes first.
+				s.msgChan <- pub.lastMsg
+			}
 			go s.start()
+			if s.pendingChan != nil {
+				go s.forward()
+			}
 		case err := <-pub.sessionErrorChan:
 			logger.Error(err)
 			if sessionError, ok := err.(*remoteSubscriberSessionError); ok {
 				for e := pub.sessions.Front(); e != nil; e = e.Next() {
 					if e.Value == sessionError.session {
 						pub.sessions.Remove(e)
+						pub.connStats.remove(sessionError.session)
 						break
 					}
 				}
@@ -101,13 +164,15 @@ func (pub *defaultPublisher) start(wg *sync.WaitGroup) {
 			logger.Debug("defaultPublisher.start Receive shutdownChan")
 			pub.listener.Close()
 			logger.Debug("defaultPublisher.start closed listener")
-			_, err := callRosAPI(pub.node.masterURI, "unregisterPublisher", pub.node.qualifiedName, pub.topic, pub.node.xmlrpcURI)
+			_, err := callRosAPIContext(pub.node.closeCtx, pub.node.masterURI, "unregisterPublisher", pub.node.qualifiedName, pub.topic, pub.node.xmlrpcURI)
 			if err != nil {
 				logger.Warn(err)
 			}
 			for e := pub.sessions.Front(); e != nil; e = e.Next() {
 				session := e.Value.(*remoteSubscriberSession)
-				session.quitChan <- struct{}{}
+				// A session which already ended, but whose error is not received yet, isn't waiting.
+				close(session.quitChan)
+				pub.connStats.remove(session)
 			}
 			pub.sessions.Init() // Clear all sessions
 			return
@@ -134,17 +199,71 @@ func (pub *defaultPublisher) listenRemoteSubscriber() {
 		}
 
 		logger.Debugf("Connected %s", conn.RemoteAddr().String())
-		session := newRemoteSubscriberSession(pub, conn)
+		session := newRemoteSubscriberSession(pub, conn, protocolTCPROS)
+		pub.connStats.add(session, session.stats)
 		pub.sessionChan <- session
 	}
 }
 
+// acceptUDPROS accepts the UDPROS protocol params of a requestTopic call, and starts a session sending to
+// the subscriber.  It returns the params of the response.
+func (pub *defaultPublisher) acceptUDPROS(params []interface{}) ([]interface{}, error) {
+	if len(params) != 5 {
+		return nil, fmt.Errorf("expected 5 UDPROS params, got %d", len(params))
+	}
+	headers, ok1 := params[1].([]byte)
+	host, ok2 := params[2].(string)
+	port, ok3 := params[3].(int32)
+	maxDatagramSize, ok4 := params[4].(int32)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return nil, fmt.Errorf("invalid UDPROS params %v", params[1:])
+	}
+	headerMap, err := decodeHeaderFields(headers)
+	if err != nil {
+		return nil, err
+	}
+	if headerMap["type"] != pub.msgType.Name() && headerMap["type"] != "*" {
+		return nil, fmt.Errorf("incompatible message type: does not match for topic %s: %s vs %s",
+			pub.topic, pub.msgType.Name(), headerMap["type"])
+	}
+	if headerMap["md5sum"] != pub.msgType.MD5Sum() && headerMap["md5sum"] != "*" {
+		return nil, fmt.Errorf("incompatible message md5: does not match for topic %s: %s vs %s",
+			pub.topic, pub.msgType.MD5Sum(), headerMap["md5sum"])
+	}
+	if maxDatagramSize <= udprosHeaderSize {
+		maxDatagramSize = udprosDefaultMaxDatagramSize
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		return nil, err
+	}
+
+	session := newRemoteSubscriberSession(pub, conn, protocolUDPROS)
+	session.subName = headerMap["callerid"]
+	session.udp = &udprosSender{connectionID: uint32(session.stats.id), maxDatagramSize: int(maxDatagramSize)}
+	pub.connStats.add(session, session.stats)
+	pub.sessionChan <- session
+
+	return []interface{}{protocolUDPROS, pub.node.hostname, conn.LocalAddr().(*net.UDPAddr).Port,
+		session.stats.id, maxDatagramSize, encodeHeaderFields(session.responseHeaders())}, nil
+}
+
 func (pub *defaultPublisher) Publish(msg Message) {
 	var buf bytes.Buffer
 	_ = msg.Serialize(&buf)
+	atomic.AddUint64(&pub.messageDataSent, uint64(buf.Len()))
 	pub.msgChan <- buf.Bytes()
 }
 
+// GetConnectionStats returns the statistics of the connections to the subscribers of the topic.
+func (pub *defaultPublisher) GetConnectionStats() []ConnectionStats {
+	return pub.connStats.snapshot()
+}
+
+func (pub *defaultPublisher) getBusStats() interface{} {
+	return buildPublisherBusStats(pub.topic, atomic.LoadUint64(&pub.messageDataSent), pub.connStats.snapshot())
+}
+
 func (pub *defaultPublisher) Shutdown() {
 	pub.shutdownChan <- struct{}{}
 }
@@ -167,14 +286,22 @@ type remoteSubscriberSession struct {
 	md5sum             string
 	typeName           string
 	quitChan           chan struct{}
+	closeCtx           context.Context // Done when shutting the node down is forced, which closes conn.
+	doneChan           chan struct{}   // Closed when the session ends.
 	msgChan            chan []byte
+	pendingChan        chan []byte // Messages waiting for room in msgChan under QueueBlock, nil otherwise.
+	queue              QueueOptions
 	errorChan          chan error
 	logger             *modular.ModuleLogger
 	connectCallback    func(SingleSubscriberPublisher)
 	disconnectCallback func(SingleSubscriberPublisher)
+	stats              *connectionStats
+	latch              bool
+	udp                *udprosSender // Sends the messages of a UDPROS session, nil for TCPROS.
+	subName            string        // Caller id of the subscriber of a UDPROS session, from its request header.
 }
 
-func newRemoteSubscriberSession(pub *defaultPublisher, conn net.Conn) *remoteSubscriberSession {
+func newRemoteSubscriberSession(pub *defaultPublisher, conn net.Conn, protocol string) *remoteSubscriberSession {
 	session := new(remoteSubscriberSession)
 	session.conn = conn
 	session.nodeID = pub.node.qualifiedName
@@ -183,11 +310,19 @@ func newRemoteSubscriberSession(pub *defaultPublisher, conn net.Conn) *remoteSub
 	session.md5sum = pub.msgType.MD5Sum()
 	session.typeName = pub.msgType.Name()
 	session.quitChan = make(chan struct{})
-	session.msgChan = make(chan []byte, 10)
+	session.closeCtx = pub.node.closeCtx
+	session.doneChan = make(chan struct{})
+	session.msgChan = make(chan []byte, pub.queue.Size)
+	session.queue = pub.queue
+	if pub.queue.Policy == QueueBlock {
+		session.pendingChan = make(chan []byte, pub.queue.Size)
+	}
 	session.errorChan = pub.sessionErrorChan
 	session.logger = &pub.node.logger
 	session.connectCallback = pub.connectCallback
 	session.disconnectCallback = pub.disconnectCallback
+	session.stats = newConnectionStats(pub.topic, ConnectionDirectionOutbound, protocol, "")
+	session.latch = pub.latch
 	return session
 }
 
@@ -221,8 +356,12 @@ func (session *remoteSubscriberSession) start() {
 		// callerId is filled in after header gets read later in this function.
 	}
 
+	defer closeWhenDone(session.closeCtx, session.conn)()
 	defer func() {
 		logger.Debug("remoteSubscriberSession.start exit")
+		close(session.doneChan)
+		session.conn.Close()
+		session.stats.setDisconnected()
 
 		if session.disconnectCallback != nil {
 			session.disconnectCallback(ssp)
@@ -241,73 +380,39 @@ func (session *remoteSubscriberSession) start() {
 			session.errorChan <- &remoteSubscriberSessionError{session, e}
 		}
 	}()
-	// 1. Read connection header
-	headers, err := readConnectionHeader(session.conn)
-	if err != nil {
-		logger.Error("failed to read connection header")
-		return
-	}
-	logger.Debug("TCPROS Connection Header:")
-	headerMap := make(map[string]string)
-	for _, h := range headers {
-		headerMap[h.key] = h.value
-		logger.Debugf("  `%s` = `%s`", h.key, h.value)
-	}
-
-	if headerMap["type"] != session.typeName && headerMap["type"] != "*" {
-		logger.Errorf("incompatible message type: does not match for topic %s: %s vs %s",
-			session.topic, session.typeName, headerMap["type"])
-		return
-	}
-
-	if headerMap["md5sum"] != session.md5sum && headerMap["md5sum"] != "*" {
-		logger.Errorf("incompatible message md5: does not match for topic %s: %s vs %s",
-			session.topic, session.md5sum, headerMap["md5sum"])
-		return
+	if session.udp == nil {
+		subName, ok := session.acceptTCPROS(logger)
+		if !ok {
+			return
+		}
+		ssp.subName = subName
+	} else {
+		ssp.subName = session.subName
+		session.stats.setConnected(ssp.subName, fmt.Sprintf("UDPROS connection on port %d to [%s]",
+			session.conn.LocalAddr().(*net.UDPAddr).Port, session.conn.RemoteAddr().String()))
 	}
-
-	ssp.subName = headerMap["callerid"]
 	if session.connectCallback != nil {
 		go session.connectCallback(ssp)
 	}
 
-	// 2. Return reponse header
-	var resHeaders []header
-	resHeaders = append(resHeaders, header{"message_definition", session.typeText})
-	resHeaders = append(resHeaders, header{"callerid", session.nodeID})
-	resHeaders = append(resHeaders, header{"latching", "0"})
-	resHeaders = append(resHeaders, header{"md5sum", session.md5sum})
-	resHeaders = append(resHeaders, header{"topic", session.topic})
-	resHeaders = append(resHeaders, header{"type", session.typeName})
-	logger.Debug("TCPROS Response Header")
-	for _, h := range resHeaders {
-		logger.Debugf("  `%s` = `%s`", h.key, h.value)
-	}
-	err = writeConnectionHeader(resHeaders, session.conn)
-	if err != nil {
-		logger.Error("failed to write response header")
-		return
-	}
-
 	// 3. Start sending message
 	logger.Debug("Start sending messages...")
-	queueMaxSize := 100
-	queue := make(chan []byte, queueMaxSize)
 	for {
 		//logger.Debug("session.remoteSubscriberSession")
 		select {
-		case msg := <-session.msgChan:
-			logger.Debug("Receive msgChan")
-			if len(queue) == queueMaxSize {
-				<-queue
-			}
-			queue <- msg
-
 		case <-session.quitChan:
 			logger.Debug("Receive quitChan")
 			return
 
-		case msg := <-queue:
+		case msg := <-session.msgChan:
+			if session.udp != nil {
+				if err := session.udp.send(session.conn, msg); err != nil {
+					logger.Error(err)
+					return
+				}
+				session.stats.addMessage(len(msg))
+				continue
+			}
 			logger.Debug("writing")
 			logger.Debug(hex.EncodeToString(msg))
 			session.conn.SetDeadline(time.Now().Add(30 * time.Millisecond))
@@ -334,6 +439,103 @@ func (session *remoteSubscriberSession) start() {
 				}
 			}
 			logger.Debug(hex.EncodeToString(msg))
+			session.stats.addMessage(len(msg))
+		}
+	}
+}
+
+// enqueue puts msg in the queue of the session following the queue policy of the publisher.  Under QueueBlock
+// the session waits for room from a goroutine of its own, forward, so that a slow subscriber doesn't hold up
+// the publisher goroutine and the other subscribers.
+func (session *remoteSubscriberSession) enqueue(msg []byte) {
+	if session.pendingChan == nil {
+		enqueueBytes(session.msgChan, msg, session.queue, session.stats.addDrop)
+		return
+	}
+	select {
+	case session.pendingChan <- msg:
+	default:
+		session.stats.addDrop()
+	}
+}
+
+// forward moves the messages waiting in pendingChan to msgChan, waiting up to the timeout of the queue for
+// room, until the session ends.
+func (session *remoteSubscriberSession) forward() {
+	for {
+		select {
+		case msg := <-session.pendingChan:
+			enqueueBytes(session.msgChan, msg, session.queue, session.stats.addDrop)
+		case <-session.doneChan:
+			return
+		}
+	}
+}
+
+// responseHeaders returns the connection header of the publisher.
+func (session *remoteSubscriberSession) responseHeaders() []header {
+	var resHeaders []header
+	resHeaders = append(resHeaders, header{"message_definition", session.typeText})
+	resHeaders = append(resHeaders, header{"callerid", session.nodeID})
+	if session.latch {
+		resHeaders = append(resHeaders, header{"latching", "1"})
+	} else {
+		resHeaders = append(resHeaders, header{"latching", "0"})
+	}
+	resHeaders = append(resHeaders, header{"md5sum", session.md5sum})
+	resHeaders = append(resHeaders, header{"topic", session.topic})
+	resHeaders = append(resHeaders, header{"type", session.typeName})
+	return resHeaders
+}
+
+// acceptTCPROS reads the connection header of the subscriber and writes the response, returning the caller id
+// of the subscriber, and whether the connection succeeded.
+func (session *remoteSubscriberSession) acceptTCPROS(logger modular.ModuleLogger) (string, bool) {
+	// 1. Read connection header
+	headers, err := readConnectionHeader(session.conn)
+	if err != nil {
+		logger.Error("failed to read connection header")
+		return "", false
+	}
+	logger.Debug("TCPROS Connection Header:")
+	headerMap := make(map[string]string)
+	for _, h := range headers {
+		headerMap[h.key] = h.value
+		logger.Debugf("  `%s` = `%s`", h.key, h.value)
+	}
+
+	if headerMap["type"] != session.typeName && headerMap["type"] != "*" {
+		logger.Errorf("incompatible message type: does not match for topic %s: %s vs %s",
+			session.topic, session.typeName, headerMap["type"])
+		return "", false
+	}
+
+	if headerMap["md5sum"] != session.md5sum && headerMap["md5sum"] != "*" {
+		logger.Errorf("incompatible message md5: does not match for topic %s: %s vs %s",
+			session.topic, session.md5sum, headerMap["md5sum"])
+		return "", false
+	}
+
+	// Go disables Nagle's algorithm by default; like roscpp, only do so for subscribers which ask for it.
+	if tcpConn, ok := session.conn.(tcpSocket); ok {
+		if err := tcpConn.SetNoDelay(headerMap["tcp_nodelay"] == "1"); err != nil {
+			logger.Warn(err)
 		}
 	}
+
+	subName := headerMap["callerid"]
+	session.stats.setConnected(subName, fmt.Sprintf("TCPROS connection on %s to [%s]",
+		session.conn.LocalAddr().String(), session.conn.RemoteAddr().String()))
+
+	// 2. Return reponse header
+	resHeaders := session.responseHeaders()
+	logger.Debug("TCPROS Response Header")
+	for _, h := range resHeaders {
+		logger.Debugf("  `%s` = `%s`", h.key, h.value)
+	}
+	if err := writeConnectionHeader(resHeaders, session.conn); err != nil {
+		logger.Error("failed to write response header")
+		return "", false
+	}
+	return subName, true
 }
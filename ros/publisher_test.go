@@ -0,0 +1,136 @@
+package ros
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// emptyMessage is std_msgs/Empty, which serializes to no bytes at all.
+type emptyMessage struct{}
+
+var msgTypeEmpty = &builtinMessageType{
+	name:       "std_msgs/Empty",
+	md5sum:     "d41d8cd98f00b204e9800998ecf8427e",
+	newMessage: func() Message { return new(emptyMessage) },
+}
+
+func (m *emptyMessage) Type() MessageType                   { return msgTypeEmpty }
+func (m *emptyMessage) Serialize(buf *bytes.Buffer) error   { return nil }
+func (m *emptyMessage) Deserialize(buf *bytes.Reader) error { return nil }
+
+// receiveOne subscribes node to topic, and spins it until a message is received.
+func receiveOne(t *testing.T, node *defaultNode, topic string) (*goalIDMessage, MessageEvent) {
+	t.Helper()
+	type received struct {
+		msg   *goalIDMessage
+		event MessageEvent
+	}
+	receivedChan := make(chan received, 10)
+	if _, err := node.NewSubscriber(topic, msgTypeGoalID, func(msg *goalIDMessage, event MessageEvent) {
+		receivedChan <- received{msg, event}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		node.SpinOnce()
+		select {
+		case r := <-receivedChan:
+			return r.msg, r.event
+		case <-timeout:
+			t.Fatalf("timed out waiting for a message on %s", topic)
+		default:
+		}
+	}
+}
+
+func TestLatchedPublisher(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	talker := newTestNode(t, m, "/talker")
+	defer talker.Shutdown()
+
+	pub, err := talker.NewPublisher("/map", msgTypeGoalID, OptionLatch(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.Publish(&goalIDMessage{GoalID{ID: "first"}})
+	pub.Publish(&goalIDMessage{GoalID{ID: "latched"}})
+
+	// Every subscriber gets the last message when it connects.
+	for _, name := range []string{"/listener1", "/listener2"} {
+		listener := newTestNode(t, m, name)
+		defer listener.Shutdown()
+		msg, event := receiveOne(t, listener, "/map")
+		if msg.ID != "latched" {
+			t.Errorf("%s: expected the latched message, got %s", name, msg.ID)
+		}
+		if event.ConnectionHeader["latching"] != "1" {
+			t.Errorf("%s: expected latching header 1, got %v", name, event.ConnectionHeader)
+		}
+	}
+}
+
+func TestLatchedEmptyMessage(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	talker := newTestNode(t, m, "/talker")
+	defer talker.Shutdown()
+	listener := newTestNode(t, m, "/listener")
+	defer listener.Shutdown()
+
+	pub, err := talker.NewPublisher("/ready", msgTypeEmpty, OptionLatch(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.Publish(&emptyMessage{})
+
+	receivedChan := make(chan struct{}, 1)
+	if _, err := listener.NewSubscriber("/ready", msgTypeEmpty, func(*emptyMessage) {
+		receivedChan <- struct{}{}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		listener.SpinOnce()
+		select {
+		case <-receivedChan:
+			return
+		case <-timeout:
+			t.Fatal("timed out waiting for the latched empty message")
+		default:
+		}
+	}
+}
+
+func TestPublisherIsNotLatchedByDefault(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	talker := newTestNode(t, m, "/talker")
+	defer talker.Shutdown()
+	listener := newTestNode(t, m, "/listener")
+	defer listener.Shutdown()
+
+	pub, err := talker.NewPublisher("/chatter", msgTypeGoalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				pub.Publish(&goalIDMessage{GoalID{ID: "live"}})
+			}
+		}
+	}()
+	_, event := receiveOne(t, listener, "/chatter")
+	if event.ConnectionHeader["latching"] != "0" {
+		t.Errorf("expected latching header 0, got %v", event.ConnectionHeader)
+	}
+}
@@ -0,0 +1,120 @@
+package ros
+
+import (
+	"fmt"
+	"time"
+)
+
+// OverflowPolicy decides what happens to a message which arrives at a full queue.
+type OverflowPolicy int
+
+const (
+	// QueueDropOldest drops the oldest message in the queue to make room for the new one.
+	QueueDropOldest OverflowPolicy = iota
+	// QueueDropNewest drops the new message.
+	QueueDropNewest
+	// QueueBlock waits for room in the queue, and drops the new message if there is none before the timeout.
+	QueueBlock
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case QueueDropOldest:
+		return "drop-oldest"
+	case QueueDropNewest:
+		return "drop-newest"
+	case QueueBlock:
+		return "block"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+// QueueOptions are the size and overflow policy of the message queue of a publisher or subscriber connection.
+// Dropped messages are counted in the Drops of the ConnectionStats of the connection.
+type QueueOptions struct {
+	Size   int
+	Policy OverflowPolicy
+	// Timeout is how long QueueBlock waits for room in the queue.
+	Timeout time.Duration
+}
+
+func (q QueueOptions) validate() error {
+	if q.Size < 1 {
+		return fmt.Errorf("queue size must be at least 1, not %d", q.Size)
+	}
+	switch q.Policy {
+	case QueueDropOldest, QueueDropNewest:
+	case QueueBlock:
+		if q.Timeout <= 0 {
+			return fmt.Errorf("queue policy %v needs a positive timeout", q.Policy)
+		}
+	default:
+		return fmt.Errorf("unknown queue policy %v", q.Policy)
+	}
+	return nil
+}
+
+// enqueueBytes puts msg in queue following the policy of q, calling drop for every message it drops.
+func enqueueBytes(queue chan []byte, msg []byte, q QueueOptions, drop func()) {
+	switch q.Policy {
+	case QueueDropNewest:
+		select {
+		case queue <- msg:
+		default:
+			drop()
+		}
+	case QueueBlock:
+		select {
+		case queue <- msg:
+		case <-time.After(q.Timeout):
+			drop()
+		}
+	default:
+		for {
+			select {
+			case queue <- msg:
+				return
+			default:
+			}
+			// The queue may be drained by its reader meanwhile, so don't block here.
+			select {
+			case <-queue:
+				drop()
+			default:
+			}
+		}
+	}
+}
+
+// enqueueMessageEvent puts msg in queue following the policy of q.  A dropped message is counted in the
+// statistics of the connection it came from.
+func enqueueMessageEvent(queue chan messageEvent, msg messageEvent, q QueueOptions) {
+	switch q.Policy {
+	case QueueDropNewest:
+		select {
+		case queue <- msg:
+		default:
+			msg.stats.addDrop()
+		}
+	case QueueBlock:
+		select {
+		case queue <- msg:
+		case <-time.After(q.Timeout):
+			msg.stats.addDrop()
+		}
+	default:
+		for {
+			select {
+			case queue <- msg:
+				return
+			default:
+			}
+			select {
+			case old := <-queue:
+				old.stats.addDrop()
+			default:
+			}
+		}
+	}
+}
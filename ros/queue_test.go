@@ -0,0 +1,260 @@
+package ros
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueOptionsValidate(t *testing.T) {
+	valid := []QueueOptions{
+		{Size: 1, Policy: QueueDropOldest},
+		{Size: 10, Policy: QueueDropNewest},
+		{Size: 10, Policy: QueueBlock, Timeout: time.Millisecond},
+	}
+	for _, q := range valid {
+		if err := q.validate(); err != nil {
+			t.Errorf("%+v: %v", q, err)
+		}
+	}
+	invalid := []QueueOptions{
+		{Size: 0, Policy: QueueDropOldest},
+		{Size: 10, Policy: QueueBlock},
+		{Size: 10, Policy: OverflowPolicy(42)},
+	}
+	for _, q := range invalid {
+		if err := q.validate(); err == nil {
+			t.Errorf("%+v: expected an error", q)
+		}
+	}
+}
+
+func fillQueue(q QueueOptions, msgs ...string) ([]string, int) {
+	queue := make(chan []byte, q.Size)
+	drops := 0
+	for _, msg := range msgs {
+		enqueueBytes(queue, []byte(msg), q, func() { drops++ })
+	}
+	close(queue)
+	var result []string
+	for msg := range queue {
+		result = append(result, string(msg))
+	}
+	return result, drops
+}
+
+func TestEnqueueBytes(t *testing.T) {
+	tests := []struct {
+		q        QueueOptions
+		expected []string
+	}{
+		{QueueOptions{Size: 2, Policy: QueueDropOldest}, []string{"c", "d"}},
+		{QueueOptions{Size: 2, Policy: QueueDropNewest}, []string{"a", "b"}},
+		{QueueOptions{Size: 2, Policy: QueueBlock, Timeout: time.Millisecond}, []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		result, drops := fillQueue(test.q, "a", "b", "c", "d")
+		if len(result) != len(test.expected) || result[0] != test.expected[0] || result[1] != test.expected[1] {
+			t.Errorf("%v: expected %v, got %v", test.q.Policy, test.expected, result)
+		}
+		if drops != 2 {
+			t.Errorf("%v: expected 2 drops, got %d", test.q.Policy, drops)
+		}
+	}
+}
+
+func TestEnqueueBlockWaits(t *testing.T) {
+	queue := make(chan []byte, 1)
+	queue <- []byte("a")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-queue
+	}()
+	dropped := false
+	enqueueBytes(queue, []byte("b"), QueueOptions{Size: 1, Policy: QueueBlock, Timeout: 5 * time.Second}, func() { dropped = true })
+	if dropped || string(<-queue) != "b" {
+		t.Error("expected the blocked message to be queued")
+	}
+}
+
+func TestEnqueueMessageEventCountsDrops(t *testing.T) {
+	stats := newConnectionStats("/chatter", ConnectionDirectionInbound, "TCPROS", "")
+	queue := make(chan messageEvent, 1)
+	q := QueueOptions{Size: 1, Policy: QueueDropOldest}
+	for _, msg := range []string{"a", "b", "c"} {
+		enqueueMessageEvent(queue, messageEvent{bytes: []byte(msg), stats: stats}, q)
+	}
+	if msg := <-queue; string(msg.bytes) != "c" {
+		t.Errorf("expected the newest message, got %s", msg.bytes)
+	}
+	if drops := stats.snapshot().Drops; drops != 2 {
+		t.Errorf("expected 2 drops, got %d", drops)
+	}
+}
+
+func TestSubscriberQueueOption(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	talker := newTestNode(t, m, "/talker")
+	defer talker.Shutdown()
+	listener := newTestNode(t, m, "/listener")
+	defer listener.Shutdown()
+
+	if _, err := listener.NewSubscriber("/chatter", msgTypeGoalID, func(*goalIDMessage) {},
+		OptionSubscriberQueue(0, QueueDropOldest, 0)); err == nil {
+		t.Error("expected an error for a queue of size 0")
+	}
+	sub, err := listener.NewSubscriber("/chatter", msgTypeGoalID, func(*goalIDMessage) {},
+		OptionSubscriberQueue(1, QueueDropNewest, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := talker.NewPublisher("/chatter", msgTypeGoalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing spins the listener, so the callbacks back up and its queue overflows.
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		pub.Publish(&goalIDMessage{GoalID{ID: "flood"}})
+		if stats := sub.GetConnectionStats(); len(stats) == 1 && stats[0].Drops > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("expected dropped messages, got %+v", sub.GetConnectionStats())
+}
+
+func TestBlockedSubscriberServesUpdates(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	talker := newTestNode(t, m, "/talker")
+	defer talker.Shutdown()
+	listener := newTestNode(t, m, "/listener")
+	defer listener.Shutdown()
+
+	sub, err := listener.NewSubscriber("/chatter", msgTypeGoalID, func(*goalIDMessage) {},
+		OptionSubscriberQueue(1, QueueBlock, 10*time.Millisecond),
+		OptionSubscriberCallbackQueue(NewCallbackQueue(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := talker.NewPublisher("/chatter", msgTypeGoalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing calls the callbacks, so the subscriber gives up on the callback queue and drops messages.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pub.Publish(&goalIDMessage{GoalID{ID: "flood"}})
+		if stats := sub.GetConnectionStats(); len(stats) == 1 && stats[0].Drops > 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected dropped messages, got %+v", sub.GetConnectionStats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// More publisher updates than pubListChan holds are served meanwhile.
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 20; i++ {
+			if _, err := callRosAPI(listener.xmlrpcURI, "publisherUpdate", "/master", "/chatter",
+				[]interface{}{talker.xmlrpcURI}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisherUpdate blocked on the subscriber")
+	}
+}
+
+func TestBlockingSessionQueue(t *testing.T) {
+	session := &remoteSubscriberSession{
+		doneChan:    make(chan struct{}),
+		msgChan:     make(chan []byte, 1),
+		pendingChan: make(chan []byte, 1),
+		queue:       QueueOptions{Size: 1, Policy: QueueBlock, Timeout: 50 * time.Millisecond},
+		stats:       newConnectionStats("/chatter", ConnectionDirectionOutbound, "TCPROS", ""),
+	}
+	go session.forward()
+	defer close(session.doneChan)
+
+	// The subscriber never reads, yet the publisher doesn't wait for it.
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		session.enqueue([]byte("msg"))
+	}
+	if elapsed := time.Since(start); elapsed > session.queue.Timeout {
+		t.Errorf("enqueue waited %v for the subscriber", elapsed)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for session.stats.snapshot().Drops < 8 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 8 drops, got %d", session.stats.snapshot().Drops)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if msg := <-session.msgChan; string(msg) != "msg" {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
+
+func TestFullCallbackQueueKeepsSubscriberPolicy(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	talker := newTestNode(t, m, "/talker")
+	defer talker.Shutdown()
+	listener := newTestNode(t, m, "/listener")
+	defer listener.Shutdown()
+
+	queue := NewCallbackQueue(1)
+	var received []string
+	sub, err := listener.NewSubscriber("/chatter", msgTypeGoalID, func(msg *goalIDMessage) {
+		received = append(received, msg.GoalID.ID)
+	}, OptionSubscriberQueue(2, QueueDropOldest, 0), OptionSubscriberCallbackQueue(queue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := talker.NewPublisher("/chatter", msgTypeGoalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pub.GetConnectionStats()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the subscriber to connect")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The callback queue holds the first message and the subscriber the second, so the queue of the
+	// subscriber keeps the last two of the rest and drops the oldest.
+	for i := 0; i < 10; i++ {
+		pub.Publish(&goalIDMessage{GoalID{ID: strconv.Itoa(i)}})
+		time.Sleep(10 * time.Millisecond)
+	}
+	for {
+		if stats := sub.GetConnectionStats(); len(stats) == 1 && stats[0].Drops == 6 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 6 dropped messages, got %+v", sub.GetConnectionStats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for queue.CallOne(100 * time.Millisecond) {
+	}
+	if strings.Join(received, ",") != "0,1,8,9" {
+		t.Errorf("expected messages 0,1,8,9, got %v", received)
+	}
+}
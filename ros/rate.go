@@ -41,6 +41,10 @@ func (r *Rate) Reset() {
 //Sleep pauses go routine for time = expectedCycleTime - (Now - Rate start)
 func (r *Rate) Sleep() error {
 	end := Now()
+	if end.Cmp(r.start) < 0 {
+		// Time jumped backwards.
+		r.start = end
+	}
 	diff := end.Diff(r.start)
 	var remaining Duration
 	if r.expectedCycleTime.Cmp(diff) >= 0 {
@@ -48,6 +52,9 @@ func (r *Rate) Sleep() error {
 	}
 	remaining.Sleep()
 	now := Now()
+	if now.Cmp(r.start) < 0 {
+		r.start = now
+	}
 	r.actualCycleTime = now.Diff(r.start)
 	r.start = now
 	return nil
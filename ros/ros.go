@@ -1,6 +1,7 @@
 package ros
 
 import (
+	"context"
 	"time"
 
 	modular "github.com/edwinhayes/logrus-modular"
@@ -8,24 +9,41 @@ import (
 
 //Node interface which contains functions of a ROS Node
 type Node interface {
-	NewPublisher(topic string, msgType MessageType) (Publisher, error)
+	// Create a publisher; options, such as OptionLatch or OptionPublisherQueue, change its settings.
+	NewPublisher(topic string, msgType MessageType, options ...OptionPublisher) (Publisher, error)
 	// Create a publisher which gives you callbacks when subscribers
 	// connect and disconnect.  The callbacks are called in their own
 	// goroutines, so they don't need to return immediately to let the
 	// connection proceed.
 	NewPublisherWithCallbacks(topic string,
 		msgType MessageType,
-		connectCallback, disconnectCallback func(SingleSubscriberPublisher)) (Publisher, error)
+		connectCallback, disconnectCallback func(SingleSubscriberPublisher),
+		options ...OptionPublisher) (Publisher, error)
 	// callback should be a function which takes 0, 1, or 2 arguments.
 	// If it takes 0 arguments, it will simply be called without the
 	// message.  1-argument functions are the normal case, and the
 	// argument should be of the generated message type.  If the
 	// function takes 2 arguments, the first argument should be of the
 	// generated message type and the second argument should be of
-	// type MessageEvent.
-	NewSubscriber(topic string, msgType MessageType, callback interface{}) (Subscriber, error)
-	NewServiceClient(service string, srvType ServiceType) ServiceClient
-	NewServiceServer(service string, srvType ServiceType, callback interface{}) ServiceServer
+	// type MessageEvent.  Options, such as OptionSubscriberQueue, only
+	// take effect when the first subscriber of the topic is created.
+	NewSubscriber(topic string, msgType MessageType, callback interface{}, options ...OptionSubscriber) (Subscriber, error)
+	// Create a service client; OptionPersistent keeps its connection open between calls.
+	NewServiceClient(service string, srvType ServiceType, options ...OptionServiceClient) ServiceClient
+	// Create a service server.  callback should be a function which takes the
+	// generated service type, and optionally a ServiceEvent, and returns an
+	// error; the server is not created if it doesn't.  Options, such as
+	// OptionServiceConcurrency or OptionHandlerTimeout, change how it is called.
+	NewServiceServer(service string, srvType ServiceType, callback interface{}, options ...OptionServiceServer) ServiceServer
+	// ProbeService asks a running service for its type, md5sum and the
+	// node serving it, without calling it.
+	ProbeService(service string) (*ServiceInfo, error)
+	// Create a started timer, which calls callback from the callback queue
+	// of the node every period of ROS time, or once if oneshot is set.
+	NewTimer(period Duration, callback func(TimerEvent), oneshot bool) Timer
+	// Create a started timer, which follows the wall clock even when the
+	// node follows simulated time.
+	NewWallTimer(period Duration, callback func(TimerEvent), oneshot bool) Timer
 
 	RemoveSubscriber(topic string)
 	RemovePublisher(topic string)
@@ -33,6 +51,9 @@ type Node interface {
 	OK() bool
 	SpinOnce() bool
 	Spin()
+	// CallbackQueue returns the queue of the callbacks called by Spin and
+	// SpinOnce; an AsyncSpinner may call them instead.
+	CallbackQueue() *CallbackQueue
 	Shutdown()
 	Name() string
 	Namespace() string
@@ -43,6 +64,17 @@ type Node interface {
 	HasParam(name string) (bool, error)
 	SearchParam(name string) (string, error)
 	DeleteParam(name string) error
+	// SubscribeParam subscribes to changes of a parameter; callback may be nil, otherwise it is called
+	// from the spin thread with the key and new value of each parameter which changed.
+	SubscribeParam(name string, callback func(key string, value interface{})) error
+	UnsubscribeParam(name string) error
+	// GetParamCached returns the value of a parameter, subscribing to it on first use so that later calls
+	// are served from a local cache.
+	GetParamCached(name string) (interface{}, error)
+	// SubscribeMasterState adds a callback, called from the spin thread, which
+	// observes the connection to the master.  The node registers everything
+	// with the master again when roscore restarts.
+	SubscribeMasterState(callback func(MasterEvent))
 
 	GetPublishedTopics(subgraph string) ([]interface{}, error)
 	GetTopicTypes() []interface{}
@@ -50,6 +82,29 @@ type Node interface {
 	Logger() *modular.ModuleLogger
 
 	NonRosArgs() []string
+
+	// Variants of the calls above which talk to the master or to other
+	// nodes, and give up when ctx is done.
+	NewPublisherContext(ctx context.Context, topic string, msgType MessageType, options ...OptionPublisher) (Publisher, error)
+	NewPublisherWithCallbacksContext(ctx context.Context, topic string, msgType MessageType, connectCallback, disconnectCallback func(SingleSubscriberPublisher), options ...OptionPublisher) (Publisher, error)
+	NewSubscriberContext(ctx context.Context, topic string, msgType MessageType, callback interface{}, options ...OptionSubscriber) (Subscriber, error)
+	NewServiceServerContext(ctx context.Context, service string, srvType ServiceType, callback interface{}, options ...OptionServiceServer) (ServiceServer, error)
+	ProbeServiceContext(ctx context.Context, service string) (*ServiceInfo, error)
+	GetParamContext(ctx context.Context, name string) (interface{}, error)
+	GetParamCachedContext(ctx context.Context, name string) (interface{}, error)
+	SetParamContext(ctx context.Context, name string, value interface{}) error
+	HasParamContext(ctx context.Context, name string) (bool, error)
+	SearchParamContext(ctx context.Context, name string) (string, error)
+	DeleteParamContext(ctx context.Context, name string) error
+	SubscribeParamContext(ctx context.Context, name string, callback func(key string, value interface{})) error
+	UnsubscribeParamContext(ctx context.Context, name string) error
+	GetPublishedTopicsContext(ctx context.Context, subgraph string) ([]interface{}, error)
+	GetTopicTypesContext(ctx context.Context) ([]interface{}, error)
+	// ShutdownContext is Shutdown, which unregisters from the master and
+	// closes the connections of the node, but stops waiting once ctx is
+	// done: what is left is then closed in the background, and ctx.Err()
+	// is returned.
+	ShutdownContext(ctx context.Context) error
 }
 
 //NewNode instantiates a newDefaultNode with name and arguments
@@ -62,9 +117,18 @@ func NewNodeWithLogs(name string, logger *modular.ModuleLogger, args []string) (
 	return newDefaultNodeWithLogs(name, logger, args)
 }
 
+// NewNodeWithOptions instantiates a newDefaultNode configured by options rather than arguments, e.g.
+//   ros.NewNodeWithOptions("talker", ros.OptionNodeMasterURI(uri), ros.OptionNodeRemap("chatter", "news"))
+// OptionNodeArgs takes arguments too, which later options override.
+func NewNodeWithOptions(name string, options ...OptionNode) (Node, error) {
+	return newDefaultNodeWithOptions(name, options...)
+}
+
 //Publisher is interface for publisher and shutdown function
 type Publisher interface {
 	Publish(msg Message)
+	// GetConnectionStats returns the statistics of the connections to the subscribers of the topic.
+	GetConnectionStats() []ConnectionStats
 	Shutdown()
 }
 
@@ -80,6 +144,8 @@ type SingleSubscriberPublisher interface {
 //Subscriber is interface for GetNumPublishers function used in callbacks
 type Subscriber interface {
 	GetNumPublishers() int
+	// GetConnectionStats returns the statistics of the connections to the publishers of the topic.
+	GetConnectionStats() []ConnectionStats
 	Shutdown()
 }
 
@@ -93,6 +159,21 @@ type MessageEvent struct {
 //ServiceHandler is a service handling interface
 type ServiceHandler interface{}
 
+// ServiceEvent is an optional second argument to a service handler.
+type ServiceEvent struct {
+	CallerID         string
+	ReceiptTime      time.Time
+	ConnectionHeader map[string]string
+}
+
+// ServiceInfo describes a running service, as answered to a probe.
+type ServiceInfo struct {
+	Service  string
+	Type     string
+	MD5Sum   string
+	CallerID string
+}
+
 //ServiceFactory is an interface for Name and MD5 sum of service
 type ServiceFactory interface {
 	Name() string
@@ -107,5 +188,65 @@ type ServiceServer interface {
 //ServiceClient is the interface for a service client with service call function
 type ServiceClient interface {
 	Call(srv Service) error
+	// CallContext is Call, giving up when ctx is done.  Failed calls return a *ServiceError.
+	CallContext(ctx context.Context, srv Service) error
+	// WaitForService waits until the service is available, or ctx is done.
+	WaitForService(ctx context.Context) error
 	Shutdown()
 }
+
+// Timer is the interface for a timer created by NewTimer or NewWallTimer
+type Timer interface {
+	Start()
+	Stop()
+	// SetPeriod changes the period; a started timer is due a new period
+	// from now.
+	SetPeriod(period Duration)
+	HasStarted() bool
+}
+
+//ActionServer is the interface for an actionlib action server with shutdown
+type ActionServer interface {
+	Shutdown()
+}
+
+// ServerGoalHandle is the server side handle of a goal sent to an ActionServer.
+// The Set* functions move the goal through the actionlib state machine; they
+// return an error if the transition is not allowed from the current state.
+// The result may be nil, in which case an empty result is sent.
+type ServerGoalHandle interface {
+	GetGoal() Message
+	GetGoalID() GoalID
+	GetGoalStatus() GoalStatus
+	SetAccepted(text string) error
+	SetRejected(result Message, text string) error
+	SetCanceled(result Message, text string) error
+	SetSucceeded(result Message, text string) error
+	SetAborted(result Message, text string) error
+	PublishFeedback(feedback Message)
+}
+
+//ActionClient is the interface for an actionlib action client
+type ActionClient interface {
+	// SendGoal sends a goal to the action server.  transitionCallback is called whenever the comm
+	// state of the goal changes, and feedbackCallback with every feedback message for the goal;
+	// either may be nil.
+	SendGoal(goal Message, transitionCallback func(ClientGoalHandle), feedbackCallback func(ClientGoalHandle, Message)) (ClientGoalHandle, error)
+	CancelAllGoals()
+	CancelAllGoalsBeforeTime(stamp Time)
+	WaitForServer(timeout Duration) bool
+	Shutdown()
+}
+
+// ClientGoalHandle is the client side handle of a goal sent by an ActionClient.
+type ClientGoalHandle interface {
+	GetGoalID() GoalID
+	GetCommState() CommState
+	GetGoalStatus() GoalStatus
+	// GetTerminalState returns the final goal status once the comm state is CommStateDone.
+	GetTerminalState() (uint8, error)
+	// GetResult returns the result message of the goal, or nil if no result was received.
+	GetResult() Message
+	Cancel() error
+	Resend() error
+}
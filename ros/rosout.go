@@ -0,0 +1,250 @@
+package ros
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Severity levels of rosgraph_msgs/Log.
+const (
+	logLevelDebug uint8 = 1
+	logLevelInfo  uint8 = 2
+	logLevelWarn  uint8 = 4
+	logLevelError uint8 = 8
+	logLevelFatal uint8 = 16
+)
+
+// logMessage is the rosgraph_msgs/Log message published on /rosout.
+type logMessage struct {
+	Header   msgHeader
+	Level    uint8
+	Name     string
+	Msg      string
+	File     string
+	Function string
+	Line     uint32
+	Topics   []string
+}
+
+const logText = `byte DEBUG=1
+byte INFO=2
+byte WARN=4
+byte ERROR=8
+byte FATAL=16
+Header header
+byte level
+string name
+string msg
+string file
+string function
+uint32 line
+string[] topics
+`
+
+var msgTypeLog = &builtinMessageType{
+	name:       "rosgraph_msgs/Log",
+	text:       logText + messageDefinitionSeparator + "MSG: std_msgs/Header\n" + msgHeaderText,
+	md5sum:     "acffd30cd6b6de30f120938c17c593fb",
+	newMessage: func() Message { return new(logMessage) },
+}
+
+func (m *logMessage) Type() MessageType {
+	return msgTypeLog
+}
+
+func (m *logMessage) Serialize(buf *bytes.Buffer) error {
+	m.Header.serialize(buf)
+	buf.WriteByte(m.Level)
+	writeString(buf, m.Name)
+	writeString(buf, m.Msg)
+	writeString(buf, m.File)
+	writeString(buf, m.Function)
+	binary.Write(buf, binary.LittleEndian, m.Line)
+	binary.Write(buf, binary.LittleEndian, uint32(len(m.Topics)))
+	for _, topic := range m.Topics {
+		writeString(buf, topic)
+	}
+	return nil
+}
+
+func (m *logMessage) Deserialize(buf *bytes.Reader) error {
+	if err := m.Header.deserialize(buf); err != nil {
+		return err
+	}
+	var err error
+	if m.Level, err = buf.ReadByte(); err != nil {
+		return err
+	}
+	for _, s := range []*string{&m.Name, &m.Msg, &m.File, &m.Function} {
+		if *s, err = readString(buf); err != nil {
+			return err
+		}
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &m.Line); err != nil {
+		return err
+	}
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return err
+	}
+	m.Topics = nil
+	for i := uint32(0); i < count; i++ {
+		topic, err := readString(buf)
+		if err != nil {
+			return err
+		}
+		m.Topics = append(m.Topics, topic)
+	}
+	return nil
+}
+
+func rosoutLevel(level logrus.Level) uint8 {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return logLevelFatal
+	case logrus.ErrorLevel:
+		return logLevelError
+	case logrus.WarnLevel:
+		return logLevelWarn
+	case logrus.InfoLevel:
+		return logLevelInfo
+	default:
+		return logLevelDebug
+	}
+}
+
+// Packages whose frames are skipped to find the caller of a log entry, and the package of rosgo itself.
+var (
+	loggingPackages = []string{"github.com/sirupsen/logrus.", "github.com/edwinhayes/logrus-modular."}
+	rosPackage      = reflect.TypeOf(defaultNode{}).PkgPath() + "."
+)
+
+// logCaller returns the frame which logged an entry, skipping the frames of the logging packages.
+func logCaller() runtime.Frame {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		logging := false
+		for _, prefix := range loggingPackages {
+			if strings.HasPrefix(frame.Function, prefix) {
+				logging = true
+			}
+		}
+		if !logging || !more {
+			return frame
+		}
+	}
+}
+
+// rosoutHook is a logrus hook which publishes the entries of a logger at or above its level to /rosout.  The
+// debug entries of rosgo itself are not published, since publishing logs them.
+type rosoutHook struct {
+	node      *defaultNode
+	pub       Publisher
+	level     uint32
+	msgChan   chan *logMessage
+	closeOnce sync.Once
+	quitChan  chan struct{}
+	doneChan  chan struct{}
+}
+
+func newRosoutHook(node *defaultNode, level logrus.Level) (*rosoutHook, error) {
+	pub, err := node.NewPublisher("/rosout", msgTypeLog)
+	if err != nil {
+		return nil, err
+	}
+	h := &rosoutHook{
+		node:     node,
+		pub:      pub,
+		level:    uint32(level),
+		msgChan:  make(chan *logMessage, 100),
+		quitChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+	go h.run()
+	return h, nil
+}
+
+func (h *rosoutHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire queues the entry, which is published from the goroutine of the hook; the logger is locked while hooks
+// fire, so publishing here could wait on a goroutine which is logging.  Entries are dropped when the queue
+// is full.
+func (h *rosoutHook) Fire(entry *logrus.Entry) error {
+	if entry.Level > h.getLevel() {
+		return nil
+	}
+	caller := logCaller()
+	if entry.Level >= logrus.DebugLevel && strings.HasPrefix(caller.Function, rosPackage) {
+		return nil
+	}
+	msg := &logMessage{
+		Level:    rosoutLevel(entry.Level),
+		Name:     h.node.qualifiedName,
+		Msg:      entry.Message,
+		File:     caller.File,
+		Function: caller.Function,
+		Line:     uint32(caller.Line),
+	}
+	select {
+	case <-h.quitChan:
+	case h.msgChan <- msg:
+	default:
+	}
+	return nil
+}
+
+func (h *rosoutHook) getLevel() logrus.Level {
+	return logrus.Level(atomic.LoadUint32(&h.level))
+}
+
+func (h *rosoutHook) setLevel(level logrus.Level) {
+	atomic.StoreUint32(&h.level, uint32(level))
+}
+
+func (h *rosoutHook) run() {
+	defer close(h.doneChan)
+	var seq uint32
+	for {
+		select {
+		case msg := <-h.msgChan:
+			seq++
+			msg.Header.Seq = seq
+			msg.Header.Stamp = Now()
+			msg.Topics = h.publishedTopics()
+			h.pub.Publish(msg)
+		case <-h.quitChan:
+			return
+		}
+	}
+}
+
+// publishedTopics returns the sorted topics which the node publishes.
+func (h *rosoutHook) publishedTopics() []string {
+	var topics []string
+	h.node.publishers.Range(func(topic interface{}, _ interface{}) bool {
+		topics = append(topics, topic.(string))
+		return true
+	})
+	sort.Strings(topics)
+	return topics
+}
+
+// close stops publishing; later entries are dropped.
+func (h *rosoutHook) close() {
+	h.closeOnce.Do(func() {
+		close(h.quitChan)
+		<-h.doneChan
+	})
+}
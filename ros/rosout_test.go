@@ -0,0 +1,93 @@
+package ros
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edwinhayes/rosgo/libgengo"
+)
+
+func TestBuiltinMessageMD5(t *testing.T) {
+	ctx, err := libgengo.NewMsgContext([]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.LoadMsgFromString(msgHeaderText, "std_msgs/Header"); err != nil {
+		t.Fatal(err)
+	}
+	for _, msgType := range []*builtinMessageType{msgTypeLog, msgTypeClock} {
+		text := strings.SplitN(msgType.Text(), messageDefinitionSeparator, 2)[0]
+		spec, err := ctx.LoadMsgFromString(text, msgType.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.MD5Sum != msgType.MD5Sum() {
+			t.Errorf("%s: expected md5sum %s, computed %s", msgType.Name(), msgType.MD5Sum(), spec.MD5Sum)
+		}
+	}
+}
+
+func TestLogMessageSerialization(t *testing.T) {
+	msg := &logMessage{msgHeader{1, NewTime(2, 3), ""}, logLevelWarn, "/node", "text", "file.go", "main.f", 42, []string{"/a", "/b"}}
+	var buf bytes.Buffer
+	if err := msg.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	result := new(logMessage)
+	if err := result.Deserialize(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if result.Header != msg.Header || result.Level != msg.Level || result.Name != msg.Name || result.Msg != msg.Msg ||
+		result.File != msg.File || result.Function != msg.Function || result.Line != msg.Line ||
+		strings.Join(result.Topics, ",") != "/a,/b" {
+		t.Errorf("expected %+v, got %+v", msg, result)
+	}
+}
+
+func TestRosout(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/talker")
+	defer node.Shutdown()
+	if _, err := node.NewPublisher("/chatter", msgTypeGoalID); err != nil {
+		t.Fatal(err)
+	}
+	listener := newTestNode(t, m, "/listener")
+	defer listener.Shutdown()
+
+	received := make(chan *logMessage, 10)
+	if _, err := listener.NewSubscriber("/rosout", msgTypeLog, func(msg *logMessage) {
+		if msg.Name == "/talker" {
+			received <- msg
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Log until the subscriber is connected; debug entries are below the default level of /rosout.
+	var msg *logMessage
+	timeout := time.After(5 * time.Second)
+	for msg == nil {
+		node.logger.Debug("not published")
+		node.logger.Warn("published")
+		listener.SpinOnce()
+		select {
+		case msg = <-received:
+		case <-timeout:
+			t.Fatal("timed out waiting for /rosout")
+		default:
+		}
+	}
+	if msg.Level != logLevelWarn || msg.Msg != "published" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if filepath.Base(msg.File) != "rosout_test.go" || !strings.HasSuffix(msg.Function, ".TestRosout") || msg.Line == 0 {
+		t.Errorf("unexpected caller %s:%d %s", msg.File, msg.Line, msg.Function)
+	}
+	if strings.Join(msg.Topics, ",") != "/chatter,/rosout" {
+		t.Errorf("unexpected topics %v", msg.Topics)
+	}
+}
@@ -2,81 +2,283 @@ package ros
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/url"
+	"sync"
 	"time"
 
 	modular "github.com/edwinhayes/logrus-modular"
 )
 
+// ServiceClientOptions are the settings of a service client, changed by the options passed to NewServiceClient.
+type ServiceClientOptions struct {
+	// Persistent keeps the connection to the service open, and reuses it for later calls.
+	Persistent bool
+}
+
+// OptionServiceClient changes the settings of a service client.
+type OptionServiceClient func(*ServiceClientOptions) error
+
+// OptionPersistent makes a service client persistent.  A persistent client which fails a call closes its
+// connection, and connects again on the next call.
+func OptionPersistent(persistent bool) OptionServiceClient {
+	return func(opts *ServiceClientOptions) error {
+		opts.Persistent = persistent
+		return nil
+	}
+}
+
+func newServiceClientOptions(options []OptionServiceClient) (*ServiceClientOptions, error) {
+	opts := &ServiceClientOptions{}
+	for _, opt := range options {
+		if err := opt(opts); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// ServicePhase is the phase of a service call.
+type ServicePhase int
+
+const (
+	// ServicePhaseLookup asks the master for the address of the service.
+	ServicePhaseLookup ServicePhase = iota
+	// ServicePhaseConnect connects to the service.
+	ServicePhaseConnect
+	// ServicePhaseHandshake exchanges connection headers with the service.
+	ServicePhaseHandshake
+	// ServicePhaseCall sends the request and receives the response.
+	ServicePhaseCall
+	// ServicePhaseResponse is the service reporting that it failed to handle the request.
+	ServicePhaseResponse
+)
+
+func (p ServicePhase) String() string {
+	switch p {
+	case ServicePhaseLookup:
+		return "lookup"
+	case ServicePhaseConnect:
+		return "connect"
+	case ServicePhaseHandshake:
+		return "handshake"
+	case ServicePhaseCall:
+		return "call"
+	case ServicePhaseResponse:
+		return "response"
+	default:
+		return fmt.Sprintf("ServicePhase(%d)", int(p))
+	}
+}
+
+// ServiceError is the error of a failed service call, or of WaitForService.  Err is context.Canceled or
+// context.DeadlineExceeded when the context of the call ended it.
+type ServiceError struct {
+	Service string
+	Phase   ServicePhase
+	Err     error
+}
+
+func (e *ServiceError) Error() string {
+	if e.Phase == ServicePhaseResponse {
+		return fmt.Sprintf("service %s failed: %v", e.Service, e.Err)
+	}
+	return fmt.Sprintf("service %s: %v failed: %v", e.Service, e.Phase, e.Err)
+}
+
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the call failed because a deadline passed.
+func (e *ServiceError) Timeout() bool {
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(e.Err, &netErr) && netErr.Timeout()
+}
+
 type defaultServiceClient struct {
-	logger    *modular.ModuleLogger
-	service   string
-	srvType   ServiceType
-	masterURI string
-	nodeID    string
+	logger     *modular.ModuleLogger
+	service    string
+	srvType    ServiceType
+	masterURI  string
+	nodeID     string
+	persistent bool
+	mutex      sync.Mutex
+	conn       net.Conn // Connection of a persistent client, nil until the first call.
 }
 
-func newDefaultServiceClient(log *modular.ModuleLogger, nodeID string, masterURI string, service string, srvType ServiceType) *defaultServiceClient {
+func newDefaultServiceClient(log *modular.ModuleLogger, nodeID string, masterURI string, service string, srvType ServiceType, options *ServiceClientOptions) *defaultServiceClient {
 	client := new(defaultServiceClient)
 	client.logger = log
 	client.service = service
 	client.srvType = srvType
 	client.masterURI = masterURI
 	client.nodeID = nodeID
+	client.persistent = options.Persistent
 	return client
 }
 
+// Call calls the service, waiting for the response without a timeout.
 func (c *defaultServiceClient) Call(srv Service) error {
-	logger := *c.logger
+	return c.CallContext(context.Background(), srv)
+}
+
+// CallContext calls the service, giving up when ctx is done.
+func (c *defaultServiceClient) CallContext(ctx context.Context, srv Service) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	conn := c.conn
+	c.conn = nil
+	if conn == nil {
+		var err error
+		if conn, _, err = c.connect(ctx, false); err != nil {
+			return err
+		}
+	}
+	err := c.call(ctx, conn, srv)
+	if serviceErr, ok := err.(*ServiceError); c.persistent && (err == nil || ok && serviceErr.Phase == ServicePhaseResponse) {
+		c.conn = conn
+	} else {
+		conn.Close()
+	}
+	return err
+}
+
+// WaitForService waits until the service is registered with the master and accepts connections.
+func (c *defaultServiceClient) WaitForService(ctx context.Context) error {
+	for {
+		conn, _, err := c.connect(ctx, true)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return &ServiceError{c.service, err.(*ServiceError).Phase, ctx.Err()}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// fail returns the error of a call in phase; if ctx is done, that's the cause of err.
+func (c *defaultServiceClient) fail(ctx context.Context, phase ServicePhase, err error) error {
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		// The deadline of the connection may pass just before the context notices.
+		err = context.DeadlineExceeded
+	}
+	return &ServiceError{c.service, phase, err}
+}
 
-	result, err := callRosAPI(c.masterURI, "lookupService", c.nodeID, c.service)
+// watchContext makes the I/O on conn fail when ctx is done, until the returned function is called.
+func watchContext(ctx context.Context, conn net.Conn) func() {
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// lookup asks the master for the address of the service.
+func (c *defaultServiceClient) lookup(ctx context.Context) (string, error) {
+	result, err := callRosAPIContext(ctx, c.masterURI, "lookupService", c.nodeID, c.service)
 	if err != nil {
-		return err
+		return "", c.fail(ctx, ServicePhaseLookup, err)
 	}
 
 	serviceRawURL, converted := result.(string)
 	if !converted {
-		return fmt.Errorf("Result of 'lookupService' is not a string")
+		return "", c.fail(ctx, ServicePhaseLookup, fmt.Errorf("Result of 'lookupService' is not a string"))
+	}
+	serviceURL, err := url.Parse(serviceRawURL)
+	if err != nil {
+		return "", c.fail(ctx, ServicePhaseLookup, err)
+	}
+	return serviceURL.Host, nil
+}
+
+// probe looks up the service and returns the connection header it answers a probe with.
+func (c *defaultServiceClient) probe(ctx context.Context) (map[string]string, error) {
+	conn, resHeaderMap, err := c.connect(ctx, true)
+	if err != nil {
+		return nil, err
 	}
-	var serviceURL *url.URL
-	serviceURL, err = url.Parse(serviceRawURL)
+	conn.Close()
+	return resHeaderMap, nil
+}
+
+// connect looks up the service, connects to it and exchanges headers, which it returns the response header
+// of; a probe connection only checks that the service answers.  A client without a service type accepts any.
+func (c *defaultServiceClient) connect(ctx context.Context, probe bool) (net.Conn, map[string]string, error) {
+	logger := *c.logger
+
+	host, err := c.lookup(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	var conn net.Conn
-	conn, err = net.Dial("tcp", serviceURL.Host)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
 	if err != nil {
-		return err
+		return nil, nil, c.fail(ctx, ServicePhaseConnect, err)
 	}
+	stopWatching := watchContext(ctx, conn)
+	defer stopWatching()
 
 	// 1. Write connection header
 	var headers []header
-	md5sum := c.srvType.MD5Sum()
-	msgType := c.srvType.Name()
+	md5sum, msgType := "*", "*"
+	if c.srvType != nil {
+		md5sum = c.srvType.MD5Sum()
+		msgType = c.srvType.Name()
+	}
 	headers = append(headers, header{"service", c.service})
 	headers = append(headers, header{"md5sum", md5sum})
 	headers = append(headers, header{"type", msgType})
 	headers = append(headers, header{"callerid", c.nodeID})
+	if probe {
+		headers = append(headers, header{"probe", "1"})
+	} else if c.persistent {
+		headers = append(headers, header{"persistent", "1"})
+	}
 	logger.Debug("TCPROS Connection Header")
 	for _, h := range headers {
 		logger.Debugf("  `%s` = `%s`", h.key, h.value)
 	}
-	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
 	if err := writeConnectionHeader(headers, conn); err != nil {
-		return err
+		conn.Close()
+		return nil, nil, c.fail(ctx, ServicePhaseHandshake, err)
 	}
 
 	// 2. Read reponse header
-	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
 	resHeaders, err := readConnectionHeader(conn)
 	if err != nil {
-		return err
+		conn.Close()
+		return nil, nil, c.fail(ctx, ServicePhaseHandshake, err)
 	}
 	logger.Debug("TCPROS Response Header:")
 	resHeaderMap := make(map[string]string)
@@ -84,66 +286,71 @@ func (c *defaultServiceClient) Call(srv Service) error {
 		resHeaderMap[h.key] = h.value
 		logger.Debugf("  `%s` = `%s`", h.key, h.value)
 	}
-	if resHeaderMap["type"] != msgType || resHeaderMap["md5sum"] != md5sum {
-		err = errors.New("incompatible message type")
-		return err
+	if c.srvType != nil && (resHeaderMap["type"] != msgType || resHeaderMap["md5sum"] != md5sum) {
+		conn.Close()
+		return nil, nil, c.fail(ctx, ServicePhaseHandshake, errors.New("incompatible message type"))
 	}
-	logger.Debug("Start receiving messages...")
+	return conn, resHeaderMap, nil
+}
+
+// call sends the request of srv over conn, and reads its response.
+func (c *defaultServiceClient) call(ctx context.Context, conn net.Conn, srv Service) error {
+	logger := *c.logger
+	stopWatching := watchContext(ctx, conn)
+	defer stopWatching()
 
 	// 3. Send request
 	var buf bytes.Buffer
 	_ = srv.ReqMessage().Serialize(&buf)
 	reqMsg := buf.Bytes()
 	size := uint32(len(reqMsg))
-	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
 	if err := binary.Write(conn, binary.LittleEndian, size); err != nil {
-		return err
+		return c.fail(ctx, ServicePhaseCall, err)
 	}
 	logger.Debug(len(reqMsg))
-	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
 	if _, err := conn.Write(reqMsg); err != nil {
-		return err
+		return c.fail(ctx, ServicePhaseCall, err)
 	}
 
 	// 4. Read OK byte
 	var ok byte
-	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
 	if err := binary.Read(conn, binary.LittleEndian, &ok); err != nil {
-		return err
+		return c.fail(ctx, ServicePhaseCall, err)
 	}
 	if ok == 0 {
 		var size uint32
-		conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
 		if err := binary.Read(conn, binary.LittleEndian, &size); err != nil {
-			return err
+			return c.fail(ctx, ServicePhaseCall, err)
 		}
 		errMsg := make([]byte, int(size))
-		conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
 		if _, err := io.ReadFull(conn, errMsg); err != nil {
-			return err
+			return c.fail(ctx, ServicePhaseCall, err)
 		}
-		return errors.New(string(errMsg))
-
+		return &ServiceError{c.service, ServicePhaseResponse, errors.New(string(errMsg))}
 	}
 
 	// 5. Receive response
-	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
-	//logger.Debug("Reading message size...")
 	var msgSize uint32
 	if err := binary.Read(conn, binary.LittleEndian, &msgSize); err != nil {
-		return err
+		return c.fail(ctx, ServicePhaseCall, err)
 	}
 	logger.Debugf("  %d", msgSize)
 	resBuffer := make([]byte, int(msgSize))
-	//logger.Debug("Reading message body...")
-	if _, err = io.ReadFull(conn, resBuffer); err != nil {
-		return err
+	if _, err := io.ReadFull(conn, resBuffer); err != nil {
+		return c.fail(ctx, ServicePhaseCall, err)
 	}
 	resReader := bytes.NewReader(resBuffer)
 	if err := srv.ResMessage().Deserialize(resReader); err != nil {
-		return err
+		return c.fail(ctx, ServicePhaseCall, err)
 	}
 	return nil
 }
 
-func (*defaultServiceClient) Shutdown() {}
+func (c *defaultServiceClient) Shutdown() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
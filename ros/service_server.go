@@ -3,11 +3,13 @@ package ros
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
 	"reflect"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,12 +18,120 @@ type serviceResult struct {
 	err error
 }
 
+// ServiceConcurrency decides where the handler of a service server runs.
+type ServiceConcurrency int
+
+const (
+	// ServiceOnSpinThread runs the handler from its callback queue, like subscriber callbacks.
+	ServiceOnSpinThread ServiceConcurrency = iota
+	// ServiceWorkerPool runs the handler in a pool of worker goroutines of the server.
+	ServiceWorkerPool
+	// ServiceGoroutinePerRequest runs the handler in a new goroutine for every request.
+	ServiceGoroutinePerRequest
+)
+
+// ServiceServerOptions are the settings of a service server, changed by the options passed to NewServiceServer.
+type ServiceServerOptions struct {
+	Concurrency ServiceConcurrency
+	// Workers is the size of the pool of ServiceWorkerPool.
+	Workers int
+	// HandlerTimeout is how long a request waits for the handler before the client is sent a failure;
+	// zero waits without a timeout.
+	HandlerTimeout time.Duration
+	// CallbackQueue is the queue of the handler of ServiceOnSpinThread; nil is the queue of the node.
+	CallbackQueue *CallbackQueue
+}
+
+// OptionServiceServer changes the settings of a service server.
+type OptionServiceServer func(*ServiceServerOptions) error
+
+// OptionServiceConcurrency sets where the handler runs; workers is only used by ServiceWorkerPool.  Handlers
+// which do not run on the spin thread must be safe to call concurrently with the node's callbacks, and,
+// except in a pool of one worker, with themselves.
+func OptionServiceConcurrency(concurrency ServiceConcurrency, workers int) OptionServiceServer {
+	return func(opts *ServiceServerOptions) error {
+		switch concurrency {
+		case ServiceOnSpinThread, ServiceGoroutinePerRequest:
+		case ServiceWorkerPool:
+			if workers < 1 {
+				return fmt.Errorf("a service worker pool needs at least 1 worker, not %d", workers)
+			}
+		default:
+			return fmt.Errorf("unknown service concurrency %d", concurrency)
+		}
+		opts.Concurrency = concurrency
+		opts.Workers = workers
+		return nil
+	}
+}
+
+// OptionHandlerTimeout sets how long a request waits for the handler; zero disables the timeout.  The
+// default is 1 second.
+func OptionHandlerTimeout(timeout time.Duration) OptionServiceServer {
+	return func(opts *ServiceServerOptions) error {
+		if timeout < 0 {
+			return fmt.Errorf("negative handler timeout %v", timeout)
+		}
+		opts.HandlerTimeout = timeout
+		return nil
+	}
+}
+
+// OptionServiceCallbackQueue makes the handler of a ServiceOnSpinThread server wait in queue rather than
+// in the queue of the node.
+func OptionServiceCallbackQueue(queue *CallbackQueue) OptionServiceServer {
+	return func(opts *ServiceServerOptions) error {
+		opts.CallbackQueue = queue
+		return nil
+	}
+}
+
+func newServiceServerOptions(options []OptionServiceServer) (*ServiceServerOptions, error) {
+	opts := &ServiceServerOptions{HandlerTimeout: 1000 * time.Millisecond}
+	for _, opt := range options {
+		if err := opt(opts); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+var (
+	serviceEventType = reflect.TypeOf(ServiceEvent{})
+	errorType        = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// validateServiceHandler checks that handler is a function taking the service of srvType, and optionally a
+// ServiceEvent, and returning an error.
+func validateServiceHandler(handler interface{}, srvType ServiceType) error {
+	fun := reflect.TypeOf(handler)
+	if fun == nil || fun.Kind() != reflect.Func {
+		return fmt.Errorf("service handler must be a function, not %v", fun)
+	}
+	if fun.NumIn() < 1 || fun.NumIn() > 2 {
+		return fmt.Errorf("service handler must take 1 or 2 arguments, not %d", fun.NumIn())
+	}
+	if srv := reflect.TypeOf(srvType.NewService()); !srv.AssignableTo(fun.In(0)) {
+		return fmt.Errorf("first argument of service handler must be %v, not %v", srv, fun.In(0))
+	}
+	if fun.NumIn() == 2 && fun.In(1) != serviceEventType {
+		return fmt.Errorf("second argument of service handler must be %v, not %v", serviceEventType, fun.In(1))
+	}
+	if fun.NumOut() != 1 || fun.Out(0) != errorType {
+		return fmt.Errorf("service handler must return error")
+	}
+	return nil
+}
+
 type remoteClientSessionCloseEvent struct {
 	session *remoteClientSession
 	err     error
 }
 
 type defaultServiceServer struct {
+	numRequests      uint64 // First, to keep the counters 64-bit aligned for atomic access.
+	bytesReceived    uint64
+	bytesSent        uint64
 	node             *defaultNode
 	service          string
 	srvType          ServiceType
@@ -31,20 +141,24 @@ type defaultServiceServer struct {
 	sessions         *list.List
 	shutdownChan     chan struct{}
 	sessionCloseChan chan *remoteClientSessionCloseEvent
+	options          ServiceServerOptions
+	workerChan       chan func()   // Jobs of the worker pool.
+	doneChan         chan struct{} // Closed when the server shuts down.
 }
 
-func newDefaultServiceServer(node *defaultNode, service string, srvType ServiceType, handler interface{}) *defaultServiceServer {
+func newDefaultServiceServer(ctx context.Context, node *defaultNode, service string, srvType ServiceType, handler interface{}, options *ServiceServerOptions) (*defaultServiceServer, error) {
 	logger := node.logger
 	server := new(defaultServiceServer)
 	if listener, err := listenRandomPort(node.listenIP, 10); err != nil {
 		logger.Errorf("failed to listen to random port : %v", err)
-		return nil
+		return nil, err
 	} else {
 		if tcpListener, ok := listener.(*net.TCPListener); ok {
 			server.listener = tcpListener
 		} else {
 			logger.Errorf("Server listener is not TCPListener")
-			return nil
+			listener.Close()
+			return nil, fmt.Errorf("server listener is not TCPListener")
 		}
 	}
 	server.node = node
@@ -54,15 +168,17 @@ func newDefaultServiceServer(node *defaultNode, service string, srvType ServiceT
 	server.sessions = list.New()
 	server.shutdownChan = make(chan struct{}, 10)
 	server.sessionCloseChan = make(chan *remoteClientSessionCloseEvent, 10)
+	server.options = *options
+	server.doneChan = make(chan struct{})
 	_, port, err := net.SplitHostPort(server.listener.Addr().String())
 	if err != nil {
 		// Not reached
 		logger.Errorf("failed to split host port : %v", err)
-		return nil
+		return nil, err
 	}
 	server.rosrpcAddr = fmt.Sprintf("rosrpc://%s:%s", node.hostname, port)
 	logger.Debugf("ServiceServer listen %s", server.rosrpcAddr)
-	_, err = callRosAPI(node.masterURI, "registerService",
+	_, err = callRosAPIContext(ctx, node.masterURI, "registerService",
 		node.qualifiedName,
 		service,
 		server.rosrpcAddr,
@@ -70,10 +186,60 @@ func newDefaultServiceServer(node *defaultNode, service string, srvType ServiceT
 	if err != nil {
 		logger.Errorf("Failed to register service %s", service)
 		server.listener.Close()
-		return nil
+		return nil, err
+	}
+	if server.options.Concurrency == ServiceWorkerPool {
+		server.workerChan = make(chan func(), server.options.Workers)
+		for i := 0; i < server.options.Workers; i++ {
+			go server.work()
+		}
 	}
 	go server.start()
-	return server
+	return server, nil
+}
+
+// work runs the jobs of the worker pool until the server shuts down.
+func (s *defaultServiceServer) work() {
+	for {
+		select {
+		case job := <-s.workerChan:
+			job()
+		case <-s.doneChan:
+			return
+		}
+	}
+}
+
+// dispatch runs the handler job of a request where the options of the server say.  Waiting for room in the
+// callback queue or for a free worker is given up when timeoutChan is closed or the server shuts down.
+func (s *defaultServiceServer) dispatch(job func(), timeoutChan <-chan struct{}) {
+	switch s.options.Concurrency {
+	case ServiceWorkerPool:
+		select {
+		case s.workerChan <- job:
+		case <-timeoutChan:
+		case <-s.doneChan:
+		}
+	case ServiceGoroutinePerRequest:
+		go job()
+	default:
+		queue := s.options.CallbackQueue
+		if queue == nil {
+			queue = s.node.queue
+		}
+		select {
+		case queue.jobChan <- callbackJob{s, job}:
+		case <-timeoutChan:
+		case <-s.doneChan:
+		}
+	}
+}
+
+// countRequest adds a request of received bytes, answered with sent bytes, to the statistics of the server.
+func (s *defaultServiceServer) countRequest(received int, sent int) {
+	atomic.AddUint64(&s.numRequests, 1)
+	atomic.AddUint64(&s.bytesReceived, uint64(received))
+	atomic.AddUint64(&s.bytesSent, uint64(sent))
 }
 
 func (s *defaultServiceServer) Shutdown() {
@@ -123,15 +289,17 @@ func (s *defaultServiceServer) start() {
 			logger.Debug("defaultServiceServer.start Receive shutdownChan")
 			s.listener.Close()
 			logger.Debug("defaultServiceServer.start closed listener")
-			_, err := callRosAPI(s.node.masterURI, "unregisterService",
+			_, err := callRosAPIContext(s.node.closeCtx, s.node.masterURI, "unregisterService",
 				s.node.qualifiedName, s.service, s.rosrpcAddr)
 			if err != nil {
 				logger.Warnf("Failed unregisterService(%s): %v", s.service, err)
 			}
 			logger.Debugf("Called unregisterService(%s)", s.service)
+			close(s.doneChan)
+			// Closing the connections ends the sessions, persistent ones included.
 			for e := s.sessions.Front(); e != nil; e = e.Next() {
 				session := e.Value.(*remoteClientSession)
-				session.quitChan <- struct{}{}
+				session.conn.Close()
 			}
 			s.sessions.Init() // Clear all sessions
 			logger.Debug("defaultServiceServer.start session cleared")
@@ -143,19 +311,15 @@ func (s *defaultServiceServer) start() {
 }
 
 type remoteClientSession struct {
-	server       *defaultServiceServer
-	conn         net.Conn
-	quitChan     chan struct{}
-	responseChan chan []byte
-	errorChan    chan error
+	server    *defaultServiceServer
+	conn      net.Conn
+	reqHeader map[string]string // Connection header of the client.
 }
 
 func newRemoteClientSession(s *defaultServiceServer, conn net.Conn) *remoteClientSession {
 	session := new(remoteClientSession)
 	session.server = s
 	session.conn = conn
-	session.responseChan = make(chan []byte)
-	session.errorChan = make(chan error)
 	return session
 }
 
@@ -166,10 +330,10 @@ func (s *remoteClientSession) start() {
 	service := s.server.service
 	md5sum := s.server.srvType.MD5Sum()
 	srvType := s.server.srvType.Name()
-	var err error
 	logger.Debugf("remoteClientSession.start '%s'", s.server.service)
 	defer func() {
 		logger.Debug("remoteClientSession.start exit")
+		conn.Close()
 	}()
 	defer func() {
 		if err := recover(); err != nil {
@@ -224,56 +388,88 @@ func (s *remoteClientSession) start() {
 		return
 	}
 
-	// 3. Read request
-	logger.Debug("Reading message size...")
-	var msgSize uint32
-	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
-	if err := binary.Read(conn, binary.LittleEndian, &msgSize); err != nil {
-		panic(err)
+	s.reqHeader = reqHeaderMap
+
+	// A persistent session serves requests until the client disconnects.
+	persistent := reqHeaderMap["persistent"] == "1"
+	for {
+		// 3. Read request
+		logger.Debug("Reading message size...")
+		if persistent {
+			conn.SetDeadline(time.Time{})
+		} else {
+			conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
+		}
+		var msgSize uint32
+		if err := binary.Read(conn, binary.LittleEndian, &msgSize); err != nil {
+			if persistent && err == io.EOF {
+				logger.Debug("Persistent session closed by client")
+				return
+			}
+			panic(err)
+		}
+		s.serveRequest(msgSize)
+		if !persistent {
+			return
+		}
 	}
+}
+
+// serveRequest reads a request of msgSize bytes, has the handler called and writes the response.
+func (s *remoteClientSession) serveRequest(msgSize uint32) {
+	logger := s.server.node.logger
+	conn := s.conn
 	logger.Debugf("  %d", msgSize)
 	resBuffer := make([]byte, int(msgSize))
 	logger.Debug("Reading message body...")
 	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
-	if _, err = io.ReadFull(conn, resBuffer); err != nil {
+	if _, err := io.ReadFull(conn, resBuffer); err != nil {
 		panic(err)
 	}
+	event := ServiceEvent{
+		CallerID:         s.reqHeader["callerid"],
+		ReceiptTime:      time.Now(),
+		ConnectionHeader: s.reqHeader,
+	}
+
+	// The timeout runs from here on, so that it covers waiting for the handler to be dispatched.
+	var timeoutChan <-chan struct{}
+	if s.server.options.HandlerTimeout > 0 {
+		timeout := make(chan struct{})
+		timer := time.AfterFunc(s.server.options.HandlerTimeout, func() { close(timeout) })
+		defer timer.Stop()
+		timeoutChan = timeout
+	}
 
-	s.server.node.jobChan <- func() {
+	// Buffered, so that the job doesn't block the spin thread after the timeout.
+	responseChan := make(chan []byte, 1)
+	errorChan := make(chan error, 1)
+	s.server.dispatch(func() {
 		srv := s.server.srvType.NewService()
 		reader := bytes.NewReader(resBuffer)
 		err := srv.ReqMessage().Deserialize(reader)
 		if err != nil {
-			s.errorChan <- err
+			errorChan <- err
+			return
 		}
-		args := []reflect.Value{reflect.ValueOf(srv)}
+		// The signature of the handler was checked by validateServiceHandler.
+		args := []reflect.Value{reflect.ValueOf(srv), reflect.ValueOf(event)}
 		fun := reflect.ValueOf(s.server.handler)
-		results := fun.Call(args)
+		results := fun.Call(args[:fun.Type().NumIn()])
 
-		if len(results) != 1 {
-			logger.Debug("Service callback return type must be 'error'")
-			s.errorChan <- err
-			return
-		}
-		result := results[0]
-		if result.IsNil() {
+		if err, _ := results[0].Interface().(error); err != nil {
+			logger.Debug("Service callback failure")
+			errorChan <- err
+		} else {
 			logger.Debug("Service callback success")
 			var buf bytes.Buffer
 			_ = srv.ResMessage().Serialize(&buf)
-			s.responseChan <- buf.Bytes()
-		} else {
-			logger.Debug("Service callback failure")
-			if err, ok := result.Interface().(error); ok {
-				s.errorChan <- err
-			} else {
-				s.errorChan <- fmt.Errorf("Service handler has invalid signature")
-			}
+			responseChan <- buf.Bytes()
 		}
-	}
+	}, timeoutChan)
 
-	timeoutChan := time.After(1000 * time.Millisecond)
 	select {
-	case resMsg := <-s.responseChan:
+	case resMsg := <-responseChan:
 		// 4. Write OK byte
 		var ok byte = 1
 		conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
@@ -291,25 +487,37 @@ func (s *remoteClientSession) start() {
 		if _, err := conn.Write(resMsg); err != nil {
 			panic(err)
 		}
-	case err := <-s.errorChan:
+		s.server.countRequest(4+len(resBuffer), 5+len(resMsg))
+	case err := <-errorChan:
 		logger.Error(err)
-		// 4. Write OK byte
-		var ok byte = 0
-		conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
-		if err := binary.Write(conn, binary.LittleEndian, &ok); err != nil {
-			panic(err)
-		}
-		errMsg := err.Error()
-		size := uint32(len(errMsg))
-		conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
-		if err := binary.Write(conn, binary.LittleEndian, size); err != nil {
-			panic(err)
-		}
-		conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
-		if _, err := conn.Write([]byte(errMsg)); err != nil {
-			panic(err)
-		}
+		s.writeFailure(err.Error())
+		s.server.countRequest(4+len(resBuffer), 5+len(err.Error()))
 	case <-timeoutChan:
-		panic(fmt.Errorf("service callback timeout"))
+		logger.Errorf("service %s callback timeout", s.server.service)
+		errMsg := fmt.Sprintf("service callback timeout after %v", s.server.options.HandlerTimeout)
+		s.writeFailure(errMsg)
+		s.server.countRequest(4+len(resBuffer), 5+len(errMsg))
+	case <-s.server.doneChan:
+		panic(fmt.Errorf("service server shut down"))
+	}
+}
+
+// writeFailure sends the client a failure response with the message errMsg.
+func (s *remoteClientSession) writeFailure(errMsg string) {
+	conn := s.conn
+	// 4. Write OK byte
+	var ok byte = 0
+	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	if err := binary.Write(conn, binary.LittleEndian, &ok); err != nil {
+		panic(err)
+	}
+	size := uint32(len(errMsg))
+	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	if err := binary.Write(conn, binary.LittleEndian, size); err != nil {
+		panic(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := conn.Write([]byte(errMsg)); err != nil {
+		panic(err)
 	}
 }
@@ -0,0 +1,388 @@
+package ros
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/edwinhayes/rosgo/master"
+)
+
+// echoService is a service for tests, whose request and response are both a GoalID.
+type echoService struct {
+	req goalIDMessage
+	res goalIDMessage
+}
+
+func (s *echoService) ReqMessage() Message { return &s.req }
+func (s *echoService) ResMessage() Message { return &s.res }
+
+type echoServiceType struct{}
+
+func (echoServiceType) MD5Sum() string            { return msgTypeGoalID.MD5Sum() }
+func (echoServiceType) Name() string              { return "rosgo_tests/Echo" }
+func (echoServiceType) RequestType() MessageType  { return msgTypeGoalID }
+func (echoServiceType) ResponseType() MessageType { return msgTypeGoalID }
+func (echoServiceType) NewService() Service       { return new(echoService) }
+
+var srvTypeEcho echoServiceType
+
+// echoHandler answers with the request, fails for the request "fail", and takes its time for "slow".
+func echoHandler(srv *echoService) error {
+	switch srv.req.ID {
+	case "fail":
+		return errors.New("failed on request")
+	case "slow":
+		time.Sleep(200 * time.Millisecond)
+	}
+	srv.res = srv.req
+	return nil
+}
+
+// newEchoServer starts a spinning node serving the echo service as /echo.
+func newEchoServer(t *testing.T, m *master.Master) *defaultNode {
+	node := newEchoServerWithOptions(t, m)
+	go node.Spin()
+	return node
+}
+
+// newEchoServerWithOptions starts a node serving the echo service as /echo, without spinning it.
+func newEchoServerWithOptions(t *testing.T, m *master.Master, options ...OptionServiceServer) *defaultNode {
+	node, err := newDefaultNode("/server", []string{"__master:=" + m.URI(), "__ip:=127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.NewServiceServer("/echo", srvTypeEcho, echoHandler, options...) == nil {
+		t.Fatal("failed to create the service server")
+	}
+	return node
+}
+
+func callEcho(client ServiceClient, id string) (string, error) {
+	srv := new(echoService)
+	srv.req.ID = id
+	err := client.Call(srv)
+	return srv.res.ID, err
+}
+
+func TestServiceCall(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	server := newEchoServer(t, m)
+	defer server.Shutdown()
+	node := newTestNode(t, m, "/client")
+	defer node.Shutdown()
+
+	client := node.NewServiceClient("/echo", srvTypeEcho)
+	defer client.Shutdown()
+	if id, err := callEcho(client, "hello"); err != nil || id != "hello" {
+		t.Errorf("unexpected response %q, %v", id, err)
+	}
+	_, err := callEcho(client, "fail")
+	if serviceErr, ok := err.(*ServiceError); !ok || serviceErr.Phase != ServicePhaseResponse || serviceErr.Err.Error() != "failed on request" {
+		t.Errorf("expected the failure of the handler, got %v", err)
+	}
+	if client.(*defaultServiceClient).conn != nil {
+		t.Error("expected a non-persistent client to close its connection")
+	}
+
+	// Both requests are counted, whether or not the handler failed.
+	result, err := callRosAPI(server.xmlrpcURI, "getBusStats", "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := result.([]interface{})[2].([]interface{})
+	if len(stats) != 3 || stats[0] != int32(2) || stats[1].(int32) == 0 || stats[2].(int32) == 0 {
+		t.Errorf("unexpected service stats %v", stats)
+	}
+}
+
+func TestPersistentServiceCall(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	server := newEchoServer(t, m)
+	node := newTestNode(t, m, "/client")
+	defer node.Shutdown()
+
+	client := node.NewServiceClient("/echo", srvTypeEcho, OptionPersistent(true))
+	defer client.Shutdown()
+	var conn net.Conn
+	for i, id := range []string{"a", "fail", "b", "c"} {
+		res, err := callEcho(client, id)
+		if id == "fail" {
+			if err == nil {
+				t.Error("expected the failure of the handler")
+			}
+		} else if err != nil || res != id {
+			t.Errorf("unexpected response %q, %v", res, err)
+		}
+		if i == 0 {
+			conn = client.(*defaultServiceClient).conn
+		} else if client.(*defaultServiceClient).conn != conn {
+			t.Errorf("call %d: expected the connection to be reused", i)
+		}
+	}
+
+	// A new server makes the connection fail, after which the client connects again.
+	server.Shutdown()
+	server = newEchoServer(t, m)
+	defer server.Shutdown()
+	if _, err := callEcho(client, "lost"); err == nil {
+		t.Error("expected the call over the closed connection to fail")
+	}
+	if res, err := callEcho(client, "again"); err != nil || res != "again" {
+		t.Errorf("unexpected response %q, %v", res, err)
+	}
+}
+
+func TestServiceCallContext(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/client")
+	defer node.Shutdown()
+	client := node.NewServiceClient("/echo", srvTypeEcho)
+
+	expectError := func(err error, phase ServicePhase, cause error) {
+		t.Helper()
+		serviceErr, ok := err.(*ServiceError)
+		if !ok || serviceErr.Phase != phase || (cause != nil && !errors.Is(err, cause)) {
+			t.Errorf("expected a %v error caused by %v, got %v", phase, cause, err)
+		}
+	}
+	expectError(client.Call(new(echoService)), ServicePhaseLookup, nil)
+
+	server := newEchoServer(t, m)
+	defer server.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	srv := new(echoService)
+	srv.req.ID = "slow"
+	err := client.CallContext(ctx, srv)
+	expectError(err, ServicePhaseCall, context.DeadlineExceeded)
+	if serviceErr, ok := err.(*ServiceError); !ok || !serviceErr.Timeout() {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	expectError(client.CallContext(ctx, srv), ServicePhaseCall, context.Canceled)
+
+	// Slow servers are fine without a deadline.
+	if id, err := callEcho(client, "slow"); err != nil || id != "slow" {
+		t.Errorf("unexpected response %q, %v", id, err)
+	}
+
+	// A service of another type fails the handshake.
+	other := node.NewServiceClient("/echo", &dynamicEchoType{echoServiceType{}, "0123456789abcdef0123456789abcdef"})
+	expectError(other.Call(new(echoService)), ServicePhaseHandshake, nil)
+}
+
+// dynamicEchoType is the echo service with another md5sum.
+type dynamicEchoType struct {
+	echoServiceType
+	md5sum string
+}
+
+func (t *dynamicEchoType) MD5Sum() string { return t.md5sum }
+
+func TestWaitForService(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/client")
+	defer node.Shutdown()
+	client := node.NewServiceClient("/echo", srvTypeEcho)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := client.WaitForService(ctx)
+	if serviceErr, ok := err.(*ServiceError); !ok || serviceErr.Phase != ServicePhaseLookup || !serviceErr.Timeout() {
+		t.Errorf("expected a lookup timeout, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- client.WaitForService(ctx)
+	}()
+	time.Sleep(150 * time.Millisecond)
+	server := newEchoServer(t, m)
+	defer server.Shutdown()
+	if err := <-done; err != nil {
+		t.Errorf("expected the service to become available, got %v", err)
+	}
+}
+
+func TestServiceConcurrency(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/client")
+	defer node.Shutdown()
+
+	tests := []OptionServiceServer{
+		OptionServiceConcurrency(ServiceWorkerPool, 2),
+		OptionServiceConcurrency(ServiceGoroutinePerRequest, 0),
+	}
+	for i, option := range tests {
+		// The server doesn't spin, so the handler must run elsewhere.
+		server := newEchoServerWithOptions(t, m, option)
+		start := time.Now()
+		results := make(chan error, 2)
+		for j := 0; j < 2; j++ {
+			go func() {
+				_, err := callEcho(node.NewServiceClient("/echo", srvTypeEcho), "slow")
+				results <- err
+			}()
+		}
+		for j := 0; j < 2; j++ {
+			if err := <-results; err != nil {
+				t.Errorf("%d: %v", i, err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed > 350*time.Millisecond {
+			t.Errorf("%d: expected concurrent handlers, took %v", i, elapsed)
+		}
+		server.Shutdown()
+	}
+
+	if node.NewServiceServer("/pool", srvTypeEcho, echoHandler, OptionServiceConcurrency(ServiceWorkerPool, 0)) != nil {
+		t.Error("expected a worker pool without workers to be refused")
+	}
+	if node.NewServiceServer("/timeout", srvTypeEcho, echoHandler, OptionHandlerTimeout(-time.Second)) != nil {
+		t.Error("expected a negative handler timeout to be refused")
+	}
+}
+
+func TestServiceHandlerTimeout(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	server := newEchoServerWithOptions(t, m, OptionHandlerTimeout(50*time.Millisecond))
+	go server.Spin()
+	defer server.Shutdown()
+	node := newTestNode(t, m, "/client")
+	defer node.Shutdown()
+
+	client := node.NewServiceClient("/echo", srvTypeEcho, OptionPersistent(true))
+	defer client.Shutdown()
+	_, err := callEcho(client, "slow")
+	if serviceErr, ok := err.(*ServiceError); !ok || serviceErr.Phase != ServicePhaseResponse {
+		t.Errorf("expected the handler to time out, got %v", err)
+	}
+	// The session survives the timeout.
+	time.Sleep(200 * time.Millisecond)
+	if id, err := callEcho(client, "fast"); err != nil || id != "fast" {
+		t.Errorf("unexpected response %q, %v", id, err)
+	}
+}
+
+func TestServiceHandlerTimeoutBlockedQueue(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	// Nothing calls the callbacks, so after the first request the queue is full.
+	server := newEchoServerWithOptions(t, m, OptionHandlerTimeout(50*time.Millisecond),
+		OptionServiceCallbackQueue(NewCallbackQueue(1)))
+	defer server.Shutdown()
+	node := newTestNode(t, m, "/client")
+	defer node.Shutdown()
+
+	client := node.NewServiceClient("/echo", srvTypeEcho)
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		srv := new(echoService)
+		srv.req.ID = "fast"
+		err := client.CallContext(ctx, srv)
+		cancel()
+		if serviceErr, ok := err.(*ServiceError); !ok || serviceErr.Phase != ServicePhaseResponse {
+			t.Errorf("%d: expected the handler to time out, got %v", i, err)
+		}
+	}
+}
+
+func TestServiceEvent(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	server := newTestNode(t, m, "/server")
+	defer server.Shutdown()
+	go server.Spin()
+	node := newTestNode(t, m, "/client")
+	defer node.Shutdown()
+
+	events := make(chan ServiceEvent, 1)
+	if server.NewServiceServer("/whoami", srvTypeEcho, func(srv *echoService, event ServiceEvent) error {
+		events <- event
+		srv.res.ID = event.CallerID
+		return nil
+	}) == nil {
+		t.Fatal("failed to create the service server")
+	}
+	before := time.Now()
+	id, err := callEcho(node.NewServiceClient("/whoami", srvTypeEcho, OptionPersistent(true)), "")
+	if err != nil || id != "/client" {
+		t.Errorf("unexpected response %q, %v", id, err)
+	}
+	event := <-events
+	if event.ConnectionHeader["persistent"] != "1" || event.ReceiptTime.Before(before) {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestValidateServiceHandler(t *testing.T) {
+	valid := []interface{}{
+		echoHandler,
+		func(srv *echoService, event ServiceEvent) error { return nil },
+		func(srv Service) error { return nil },
+	}
+	for _, handler := range valid {
+		if err := validateServiceHandler(handler, srvTypeEcho); err != nil {
+			t.Errorf("%T: %v", handler, err)
+		}
+	}
+	invalid := []interface{}{
+		nil,
+		"handler",
+		func() error { return nil },
+		func(srv *echoService) {},
+		func(srv *echoService) bool { return true },
+		func(srv *goalIDMessage) error { return nil },
+		func(srv *echoService, event *ServiceEvent) error { return nil },
+		func(srv *echoService, event ServiceEvent, x int) error { return nil },
+	}
+	for _, handler := range invalid {
+		if err := validateServiceHandler(handler, srvTypeEcho); err == nil {
+			t.Errorf("%T: expected an error", handler)
+		}
+	}
+
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/server")
+	defer node.Shutdown()
+	if node.NewServiceServer("/echo", srvTypeEcho, func(srv *echoService) {}) != nil {
+		t.Error("expected an invalid handler to be refused")
+	}
+}
+
+func TestProbeService(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/client")
+	defer node.Shutdown()
+
+	if _, err := node.ProbeService("/echo"); err == nil {
+		t.Error("expected probing a missing service to fail")
+	}
+
+	server := newEchoServer(t, m)
+	defer server.Shutdown()
+	info, err := node.ProbeService("/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ServiceInfo{"/echo", srvTypeEcho.Name(), srvTypeEcho.MD5Sum(), "/server"}
+	if *info != expected {
+		t.Errorf("expected %+v, got %+v", expected, *info)
+	}
+}
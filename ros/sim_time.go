@@ -0,0 +1,144 @@
+package ros
+
+import (
+	"bytes"
+	"sync"
+)
+
+// clockMessage is the rosgraph_msgs/Clock message published on /clock by simulators and rosbag.
+type clockMessage struct {
+	Clock Time
+}
+
+var msgTypeClock = &builtinMessageType{
+	name:       "rosgraph_msgs/Clock",
+	text:       "time clock\n",
+	md5sum:     "a9c97c1d230cfc112e270351a944ee47",
+	newMessage: func() Message { return new(clockMessage) },
+}
+
+func (m *clockMessage) Type() MessageType {
+	return msgTypeClock
+}
+
+func (m *clockMessage) Serialize(buf *bytes.Buffer) error {
+	writeTime(buf, m.Clock)
+	return nil
+}
+
+func (m *clockMessage) Deserialize(buf *bytes.Reader) error {
+	var err error
+	m.Clock, err = readTime(buf)
+	return err
+}
+
+// simClock is the simulated time of the process.  While a node with /use_sim_time set is running, Now,
+// Duration.Sleep, Rate.Sleep and the timers of the node follow it rather than the wall clock; it is zero
+// until the first message on /clock.
+type simClock struct {
+	mutex   sync.Mutex
+	users   int // Nodes following the simulated time.
+	time    Time
+	updated chan struct{} // Closed, and replaced, whenever the time or its users change.
+}
+
+var simTime = &simClock{updated: make(chan struct{})}
+
+// state returns the simulated time, whether it is followed, and a channel which is closed when either
+// changes.
+func (c *simClock) state() (Time, bool, <-chan struct{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.time, c.users > 0, c.updated
+}
+
+func (c *simClock) notify() {
+	close(c.updated)
+	c.updated = make(chan struct{})
+}
+
+func (c *simClock) set(t Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.time = t
+	c.notify()
+}
+
+// use makes the process follow the simulated time until release is called as often.
+func (c *simClock) use() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.users++
+	c.notify()
+}
+
+func (c *simClock) release() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.users--
+	if c.users == 0 {
+		c.time = Time{}
+	}
+	c.notify()
+}
+
+func (c *simClock) now() Time {
+	t, _, _ := c.state()
+	return t
+}
+
+// after returns a channel which is closed once the simulated time reaches t, or jumps backwards.
+func (c *simClock) after(t Time) (<-chan struct{}, func()) {
+	reached := make(chan struct{})
+	released := make(chan struct{})
+	start := c.now()
+	go func() {
+		for {
+			now, _, updated := c.state()
+			if now.Cmp(t) >= 0 || now.Cmp(start) < 0 {
+				close(reached)
+				return
+			}
+			select {
+			case <-updated:
+			case <-released:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return reached, func() { once.Do(func() { close(released) }) }
+}
+
+// sleep waits for d of simulated time, or less if the time jumps backwards or stops being followed.
+func (c *simClock) sleep(d Duration) {
+	start := c.now()
+	end := start.Add(d)
+	for {
+		now, active, updated := c.state()
+		if now.Cmp(end) >= 0 || now.Cmp(start) < 0 || !active {
+			return
+		}
+		<-updated
+	}
+}
+
+// followSimTime subscribes to /clock, and makes the node follow the simulated time.  The messages are
+// handled on a queue of their own, so the time advances while the spin thread sleeps.
+func (node *defaultNode) followSimTime() error {
+	queue := NewCallbackQueue(10)
+	spinner, err := NewAsyncSpinner(queue, 1)
+	if err != nil {
+		return err
+	}
+	if _, err := node.NewSubscriber("/clock", msgTypeClock, func(msg *clockMessage) {
+		simTime.set(msg.Clock)
+	}, OptionSubscriberCallbackQueue(queue)); err != nil {
+		return err
+	}
+	spinner.Start()
+	node.clockSpinner = spinner
+	node.clock = simTime
+	simTime.use()
+	return nil
+}
@@ -0,0 +1,99 @@
+package ros
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimTime(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	sim := newTestNode(t, m, "/sim")
+	defer sim.Shutdown()
+	if err := sim.SetParam("/use_sim_time", true); err != nil {
+		t.Fatal(err)
+	}
+	clockPub, err := sim.NewPublisher("/clock", msgTypeClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := newTestNode(t, m, "/node")
+	defer node.Shutdown()
+	if now := Now(); !now.IsZero() {
+		t.Errorf("expected the time to be zero before the first clock message, got %v", now)
+	}
+
+	// setTime publishes the time until the node follows it.
+	setTime := func(sec uint32) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for Now() != NewTime(sec, 0) {
+			clockPub.Publish(&clockMessage{NewTime(sec, 0)})
+			select {
+			case <-timeout:
+				t.Fatalf("timed out waiting for the time %d", sec)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	setTime(100)
+
+	// Sleeping waits for the simulated time, which the spin thread need not advance.
+	slept := make(chan Time, 1)
+	go func() {
+		d := NewDuration(5, 0)
+		d.Sleep()
+		slept <- Now()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-slept:
+		t.Fatal("expected the sleep to wait for the simulated time")
+	default:
+	}
+	setTime(103)
+	setTime(105)
+	if now := <-slept; now != NewTime(105, 0) {
+		t.Errorf("expected to wake at 105, got %v", now)
+	}
+
+	// A jump backwards ends a sleep.
+	go func() {
+		d := NewDuration(5, 0)
+		d.Sleep()
+		slept <- Now()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	setTime(50)
+	select {
+	case <-slept:
+	case <-time.After(time.Second):
+		t.Error("expected a jump backwards to end the sleep")
+	}
+
+	// Timers follow the simulated time, wall timers don't.
+	events := make(chan TimerEvent, 10)
+	node.NewTimer(NewDuration(10, 0), func(event TimerEvent) { events <- event }, false)
+	wallCalls := 0
+	node.NewWallTimer(newTestDuration(10*time.Millisecond), func(TimerEvent) { wallCalls++ }, true)
+	spinFor(node.CallbackQueue(), 50*time.Millisecond)
+	if len(events) != 0 || wallCalls != 1 {
+		t.Errorf("expected only the wall timer to be called, got %d and %d calls", len(events), wallCalls)
+	}
+	setTime(65)
+	spinFor(node.CallbackQueue(), 50*time.Millisecond)
+	select {
+	case event := <-events:
+		if event.CurrentExpected != NewTime(60, 0) || event.CurrentReal != NewTime(65, 0) {
+			t.Errorf("unexpected event %+v", event)
+		}
+	default:
+		t.Error("expected the timer to be called")
+	}
+
+	node.Shutdown()
+	if now, wall := Now(), time.Now().Unix(); int64(now.Sec) < wall-1 {
+		t.Errorf("expected the wall clock once no node follows the simulated time, got %v", now)
+	}
+}
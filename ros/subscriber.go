@@ -2,6 +2,7 @@ package ros
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -16,6 +17,50 @@ import (
 type messageEvent struct {
 	bytes []byte
 	event MessageEvent
+	stats *connectionStats
+}
+
+// SubscriberOptions are the settings of a subscriber, changed by the options passed to NewSubscriber.
+type SubscriberOptions struct {
+	// Queue is the queue of received messages waiting for their callbacks.
+	Queue QueueOptions
+	// TransportHints are the preferred transports of the connections to publishers.
+	TransportHints TransportHints
+	// CallbackQueue is the queue of the callbacks; nil is the queue of the node.
+	CallbackQueue *CallbackQueue
+}
+
+// OptionSubscriber changes the settings of a subscriber.
+type OptionSubscriber func(*SubscriberOptions) error
+
+// OptionSubscriberQueue sets the size and overflow policy of the queue of received messages.  The default
+// queue holds 10 messages, and drops a new message if there is no room for it within 30 ms.
+func OptionSubscriberQueue(size int, policy OverflowPolicy, timeout time.Duration) OptionSubscriber {
+	return func(opts *SubscriberOptions) error {
+		opts.Queue = QueueOptions{Size: size, Policy: policy, Timeout: timeout}
+		return opts.Queue.validate()
+	}
+}
+
+// OptionSubscriberCallbackQueue makes the callbacks of the subscriber wait in queue rather than in the
+// queue of the node.
+func OptionSubscriberCallbackQueue(queue *CallbackQueue) OptionSubscriber {
+	return func(opts *SubscriberOptions) error {
+		opts.CallbackQueue = queue
+		return nil
+	}
+}
+
+func newSubscriberOptions(options []OptionSubscriber) (*SubscriberOptions, error) {
+	opts := &SubscriberOptions{
+		Queue: QueueOptions{Size: 10, Policy: QueueBlock, Timeout: 30 * time.Millisecond},
+	}
+	for _, opt := range options {
+		if err := opt(opts); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
 }
 
 // The subscription object runs in own goroutine (startSubscription).
@@ -31,13 +76,22 @@ type defaultSubscriber struct {
 	shutdownChan     chan struct{}
 	connections      map[string]chan struct{}
 	disconnectedChan chan string
+	connStats        connectionStatsMap
+	queue            QueueOptions
+	transportHints   TransportHints
+	callbackQueue    *CallbackQueue
+	hostname         string // Advertised, and listenIP bound, for UDPROS connections.
+	listenIP         string
 }
 
-func newDefaultSubscriber(topic string, msgType MessageType, callback interface{}) *defaultSubscriber {
+func newDefaultSubscriber(topic string, msgType MessageType, callback interface{}, options *SubscriberOptions) *defaultSubscriber {
 	sub := new(defaultSubscriber)
 	sub.topic = topic
 	sub.msgType = msgType
-	sub.msgChan = make(chan messageEvent, 10)
+	sub.queue = options.Queue
+	sub.transportHints = options.TransportHints
+	sub.callbackQueue = options.CallbackQueue
+	sub.msgChan = make(chan messageEvent, sub.queue.Size)
 	sub.pubListChan = make(chan []string, 10)
 	sub.addCallbackChan = make(chan interface{}, 10)
 	sub.shutdownChan = make(chan struct{}, 10)
@@ -47,7 +101,7 @@ func newDefaultSubscriber(topic string, msgType MessageType, callback interface{
 	return sub
 }
 
-func (sub *defaultSubscriber) start(wg *sync.WaitGroup, nodeID string, nodeAPIURI string, masterURI string, jobChan chan func(), log *modular.ModuleLogger) {
+func (sub *defaultSubscriber) start(ctx context.Context, wg *sync.WaitGroup, nodeID string, nodeAPIURI string, masterURI string, log *modular.ModuleLogger) {
 	logger := *log
 	logger.Debugf("Subscriber goroutine for %s started.", sub.topic)
 	wg.Add(1)
@@ -55,7 +109,16 @@ func (sub *defaultSubscriber) start(wg *sync.WaitGroup, nodeID string, nodeAPIUR
 	defer func() {
 		logger.Debug(sub.topic, " : defaultSubscriber.start exit")
 	}()
+	// While the callback queue is full the job of the last message waits in pending, and no more messages are
+	// taken from msgChan, so that the queue of the subscriber holds or drops them by its own policy.
+	var pending callbackJob
 	for {
+		msgChan := sub.msgChan
+		var jobChan chan callbackJob
+		if pending.call != nil {
+			msgChan = nil
+			jobChan = sub.callbackQueue.jobChan
+		}
 		select {
 		case list := <-sub.pubListChan:
 			logger.Debug(sub.topic, " : Receive pubListChan")
@@ -67,47 +130,20 @@ func (sub *defaultSubscriber) start(wg *sync.WaitGroup, nodeID string, nodeAPIUR
 				quitChan := sub.connections[pub]
 				quitChan <- struct{}{}
 				delete(sub.connections, pub)
+				sub.connStats.remove(pub)
 			}
 			for _, pub := range newPubs {
-				protocols := []interface{}{[]interface{}{"TCPROS"}}
-				result, err := callRosAPI(pub, "requestTopic", nodeID, sub.topic, protocols)
-				if err != nil {
-					logger.Error(sub.topic, " : ", err)
-					continue
-				}
-				protocolParams := result.([]interface{})
-				for _, x := range protocolParams {
-					logger.Debug(sub.topic, " : ", x)
-				}
-				name := protocolParams[0].(string)
-				if name == "TCPROS" {
-					addr := protocolParams[1].(string)
-					port := protocolParams[2].(int32)
-					uri := fmt.Sprintf("%s:%d", addr, port)
-					quitChan := make(chan struct{}, 10)
-					sub.connections[pub] = quitChan
-					go startRemotePublisherConn(log,
-						uri, sub.topic,
-						sub.msgType.MD5Sum(),
-						sub.msgType.Name(), nodeID,
-						sub.msgChan,
-						quitChan,
-						sub.disconnectedChan,
-						sub.msgType)
-				} else {
-					logger.Warn(sub.topic, " : rosgo does not support protocol: ", name)
-				}
+				sub.connectPublisher(ctx, pub, nodeID, log)
 			}
 		case callback := <-sub.addCallbackChan:
 			logger.Debug(sub.topic, " : Receive addCallbackChan")
 			sub.callbacks = append(sub.callbacks, callback)
-		case msgEvent := <-sub.msgChan:
+		case msgEvent := <-msgChan:
 			// Pop received message then bind callbacks and enqueue to the job channle.
 			logger.Debug(sub.topic, " : Receive msgChan")
 			callbacks := make([]interface{}, len(sub.callbacks))
 			copy(callbacks, sub.callbacks)
-			select {
-			case jobChan <- func() {
+			job := callbackJob{sub, func() {
 				m := sub.msgType.NewMessage()
 				reader := bytes.NewReader(msgEvent.bytes)
 				if err := m.Deserialize(reader); err != nil {
@@ -122,14 +158,21 @@ func (sub *defaultSubscriber) start(wg *sync.WaitGroup, nodeID string, nodeAPIUR
 						fun.Call(args[0:numArgsNeeded])
 					}
 				}
-			}:
+			}}
+			select {
+			case sub.callbackQueue.jobChan <- job:
 				logger.Debug(sub.topic, " : Callback job enqueued.")
-			case <-time.After(time.Duration(3) * time.Second):
-				logger.Debug(sub.topic, " : Callback job timed out.")
+			default:
+				logger.Debug(sub.topic, " : Callback queue full, holding the job.")
+				pending = job
 			}
+		case jobChan <- pending:
+			logger.Debug(sub.topic, " : Callback job enqueued.")
+			pending = callbackJob{}
 		case pubURI := <-sub.disconnectedChan:
 			logger.Debug(sub.topic, " : Connection disconnected to ", pubURI)
 			delete(sub.connections, pubURI)
+			sub.connStats.remove(pubURI)
 		case <-sub.shutdownChan:
 			// Shutdown subscription goroutine
 			logger.Debug(sub.topic, " : Receive shutdownChan")
@@ -137,7 +180,7 @@ func (sub *defaultSubscriber) start(wg *sync.WaitGroup, nodeID string, nodeAPIUR
 				closeChan <- struct{}{}
 				close(closeChan)
 			}
-			_, err := callRosAPI(masterURI, "unregisterSubscriber", nodeID, sub.topic, nodeAPIURI)
+			_, err := callRosAPIContext(ctx, masterURI, "unregisterSubscriber", nodeID, sub.topic, nodeAPIURI)
 			if err != nil {
 				logger.Warn(sub.topic, " : ", err)
 			}
@@ -146,18 +189,134 @@ func (sub *defaultSubscriber) start(wg *sync.WaitGroup, nodeID string, nodeAPIUR
 	}
 }
 
-func startRemotePublisherConn(log *modular.ModuleLogger,
-	pubURI string, topic string, md5sum string,
+// connectPublisher requests the topic from the publisher with slave API pub, offering the protocols of the
+// transport hints, and starts receiving messages over the protocol it selects.  The request, and a TCPROS
+// connection, are given up once ctx is done.
+func (sub *defaultSubscriber) connectPublisher(ctx context.Context, pub string, nodeID string, log *modular.ModuleLogger) {
+	logger := *log
+	var udpConn *net.UDPConn
+	protocols := []interface{}{}
+	for _, protocol := range sub.transportHints.getProtocols() {
+		switch protocol {
+		case protocolTCPROS:
+			protocols = append(protocols, []interface{}{protocolTCPROS})
+		case protocolUDPROS:
+			if udpConn != nil {
+				continue
+			}
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(sub.listenIP)})
+			if err != nil {
+				logger.Error(sub.topic, " : ", err)
+				continue
+			}
+			udpConn = conn
+			if err := sub.transportHints.applyBuffers(udpConn); err != nil {
+				logger.Warn(sub.topic, " : ", err)
+			}
+			headers := encodeHeaderFields([]header{
+				{"topic", sub.topic},
+				{"md5sum", sub.msgType.MD5Sum()},
+				{"type", sub.msgType.Name()},
+				{"callerid", nodeID},
+			})
+			port := udpConn.LocalAddr().(*net.UDPAddr).Port
+			protocols = append(protocols, []interface{}{protocolUDPROS, headers, sub.hostname, port, sub.transportHints.getMaxDatagramSize()})
+		}
+	}
+	closeUDP := func() {
+		if udpConn != nil {
+			udpConn.Close()
+		}
+	}
+
+	result, err := callRosAPIContext(ctx, pub, "requestTopic", nodeID, sub.topic, protocols)
+	if err != nil {
+		logger.Error(sub.topic, " : ", err)
+		closeUDP()
+		return
+	}
+	protocolParams, ok := result.([]interface{})
+	if !ok || len(protocolParams) == 0 {
+		logger.Error(sub.topic, " : ", pub, " selected no protocol")
+		closeUDP()
+		return
+	}
+	for _, x := range protocolParams {
+		logger.Debug(sub.topic, " : ", x)
+	}
+	name, _ := protocolParams[0].(string)
+	switch {
+	case name == protocolTCPROS:
+		closeUDP()
+		addr := protocolParams[1].(string)
+		port := protocolParams[2].(int32)
+		uri := fmt.Sprintf("%s:%d", addr, port)
+		quitChan := make(chan struct{}, 10)
+		sub.connections[pub] = quitChan
+		stats := newConnectionStats(sub.topic, ConnectionDirectionInbound, protocolTCPROS, pub)
+		sub.connStats.add(pub, stats)
+		go startRemotePublisherConn(ctx, log,
+			pub, uri, sub.topic,
+			sub.msgType.MD5Sum(),
+			sub.msgType.Name(), nodeID,
+			sub.msgChan,
+			sub.queue,
+			quitChan,
+			sub.disconnectedChan,
+			sub.msgType,
+			sub.transportHints,
+			stats)
+	case name == protocolUDPROS && udpConn != nil && len(protocolParams) == 6:
+		connectionID, _ := protocolParams[3].(int32)
+		headers, _ := protocolParams[5].([]byte)
+		resHeaderMap, err := decodeHeaderFields(headers)
+		if err != nil {
+			logger.Error(sub.topic, " : Failed to read UDPROS header: ", err)
+			closeUDP()
+			return
+		}
+		if resHeaderMap["type"] != sub.msgType.Name() || resHeaderMap["md5sum"] != sub.msgType.MD5Sum() {
+			logger.Error("Incompatible message type for ", sub.topic, ": ", resHeaderMap["type"], ":", sub.msgType.Name(), " ", resHeaderMap["md5sum"], ":", sub.msgType.MD5Sum())
+			closeUDP()
+			return
+		}
+		quitChan := make(chan struct{}, 10)
+		sub.connections[pub] = quitChan
+		stats := newConnectionStats(sub.topic, ConnectionDirectionInbound, protocolUDPROS, pub)
+		sub.connStats.add(pub, stats)
+		go startRemotePublisherUDP(log,
+			pub, udpConn, uint32(connectionID),
+			sub.topic, resHeaderMap,
+			sub.msgChan,
+			sub.queue,
+			quitChan,
+			stats)
+	default:
+		closeUDP()
+		logger.Warn(sub.topic, " : rosgo does not support protocol: ", name)
+	}
+}
+
+// startRemotePublisherConn receives messages from the publisher with slave API pubAPI, which serves the topic at pubURI.
+func startRemotePublisherConn(ctx context.Context, log *modular.ModuleLogger,
+	pubAPI string, pubURI string, topic string, md5sum string,
 	msgType string, nodeID string,
 	msgChan chan messageEvent,
+	queue QueueOptions,
 	quitChan chan struct{},
-	disconnectedChan chan string, msgTypeProper MessageType) {
+	disconnectedChan chan string, msgTypeProper MessageType,
+	hints TransportHints,
+	stats *connectionStats) {
 
 	logger := *log
 	logger.Debug(topic, " : startRemotePublisherConn()")
 
+	// The connection is closed once ctx is done, even while the publisher doesn't answer.
+	stopClosing := func() {}
 	defer func() {
 		logger.Debug(topic, " : startRemotePublisherConn() exit")
+		stopClosing()
+		stats.setDisconnected()
 	}()
 
 	// Dial loop for a subscriber
@@ -175,6 +334,10 @@ dial:
 			logger.Error(topic, " : Failed to connect to ", pubURI, "- error: ", err)
 			return
 		}
+		stopClosing = closeWhenDone(ctx, conn)
+		if err := hints.applyTCP(conn.(*net.TCPConn)); err != nil {
+			logger.Warn(topic, " : Failed to set socket options - error: ", err)
+		}
 	}
 
 	// 1. Write connection header
@@ -183,6 +346,9 @@ dial:
 	headers = append(headers, header{"md5sum", md5sum})
 	headers = append(headers, header{"type", msgType})
 	headers = append(headers, header{"callerid", nodeID})
+	if hints.tcpNoDelay {
+		headers = append(headers, header{"tcp_nodelay", "1"})
+	}
 	logger.Debug(topic, " : TCPROS Connection Header")
 	for _, h := range headers {
 		logger.Debugf("          `%s` = `%s`", h.key, h.value)
@@ -212,10 +378,15 @@ dial:
 		return
 	}
 	logger.Debug(topic, " : Start receiving messages...")
+	// Publishers which do not send the latching header are not latched.
+	if _, ok := resHeaderMap["latching"]; !ok {
+		resHeaderMap["latching"] = "0"
+	}
 	event := MessageEvent{ // Event struct to be sent with each message.
 		PublisherName:    resHeaderMap["callerid"],
 		ConnectionHeader: resHeaderMap,
 	}
+	stats.setConnected("", fmt.Sprintf("TCPROS connection on %s to [%s]", conn.LocalAddr().String(), conn.RemoteAddr().String()))
 
 	// 3. Start reading messages
 	readingSize := true
@@ -237,7 +408,7 @@ dial:
 						continue
 					} else {
 						logger.Error(topic, " : Failed to read a message size")
-						disconnectedChan <- pubURI
+						disconnectedChan <- pubAPI
 						return
 					}
 				}
@@ -248,6 +419,7 @@ dial:
 				} else {
 					//logger.Debug("tcp cluttered - reconnecting")
 					conn.Close()
+					stopClosing()
 					goto dial
 				}
 			} else {
@@ -258,19 +430,17 @@ dial:
 						// Timed out
 						//logger.Debug(neterr)
 						conn.Close()
+						stopClosing()
 						goto dial
 					} else {
 						logger.Error(topic, " : Failed to read a message body")
-						disconnectedChan <- pubURI
+						disconnectedChan <- pubAPI
 						return
 					}
 				}
 				event.ReceiptTime = time.Now()
-				select {
-				case msgChan <- messageEvent{bytes: buffer, event: event}:
-				case <-time.After(time.Duration(30) * time.Millisecond):
-					//logger.Debug("dropping message")
-				}
+				stats.addMessage(len(buffer))
+				enqueueMessageEvent(msgChan, messageEvent{bytes: buffer, event: event, stats: stats}, queue)
 				readingSize = true
 			}
 		}
@@ -304,3 +474,12 @@ func (sub *defaultSubscriber) Shutdown() {
 func (sub *defaultSubscriber) GetNumPublishers() int {
 	return len(sub.pubList)
 }
+
+// GetConnectionStats returns the statistics of the connections to the publishers of the topic.
+func (sub *defaultSubscriber) GetConnectionStats() []ConnectionStats {
+	return sub.connStats.snapshot()
+}
+
+func (sub *defaultSubscriber) getBusStats() interface{} {
+	return buildSubscriberBusStats(sub.topic, sub.connStats.snapshot())
+}
@@ -15,8 +15,16 @@ func NewTime(sec uint32, nsec uint32) Time {
 	return Time{temporal{sec, nsec}}
 }
 
-//Now creates a Time object of value Now
+//Now creates a Time object of value Now; the simulated time while a node follows /use_sim_time
 func Now() Time {
+	if t, active, _ := simTime.state(); active {
+		return t
+	}
+	return wallNow()
+}
+
+// wallNow returns the time of the wall clock, even when following simulated time.
+func wallNow() Time {
 	var t Time
 	t.FromNSec(uint64(gotime.Now().UnixNano()))
 	return t
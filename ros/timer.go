@@ -0,0 +1,181 @@
+package ros
+
+import (
+	"sync"
+	gotime "time"
+)
+
+// TimerEvent is the argument of a timer callback.  The expected times are when the callback was due, and the
+// real times when it was called; the last times are zero before the first call.
+type TimerEvent struct {
+	LastExpected    Time
+	LastReal        Time
+	CurrentExpected Time
+	CurrentReal     Time
+}
+
+// clock is the time which timers follow.
+type clock interface {
+	now() Time
+	// after returns a channel which is closed once the clock reaches t, or jumps backwards, and a function
+	// which releases it earlier.
+	after(t Time) (<-chan struct{}, func())
+}
+
+// wallClock is the time of the system.
+type wallClock struct{}
+
+func (wallClock) now() Time {
+	return wallNow()
+}
+
+func (wallClock) after(t Time) (<-chan struct{}, func()) {
+	reached := make(chan struct{})
+	var wait gotime.Duration
+	if now := wallNow(); t.Cmp(now) > 0 {
+		d := t.Diff(now)
+		wait = gotime.Duration(d.ToNSec())
+	}
+	timer := gotime.AfterFunc(wait, func() { close(reached) })
+	return reached, func() { timer.Stop() }
+}
+
+// defaultTimer calls its callback from a callback queue.  At most one call is waiting in the queue; a timer
+// whose callback is late skips the calls it missed.
+type defaultTimer struct {
+	queue    *CallbackQueue
+	clock    clock
+	callback func(TimerEvent)
+	oneshot  bool
+	mutex    sync.Mutex
+	period   Duration
+	stopChan chan struct{} // Closed to stop the goroutine of the started timer; nil while stopped.
+}
+
+func newDefaultTimer(queue *CallbackQueue, clock clock, period Duration, callback func(TimerEvent), oneshot bool) *defaultTimer {
+	timer := new(defaultTimer)
+	timer.queue = queue
+	timer.clock = clock
+	timer.callback = callback
+	timer.oneshot = oneshot
+	timer.period = period
+	return timer
+}
+
+// Start starts the timer, whose first call is due a period from now; it does nothing if the timer is
+// started.
+func (t *defaultTimer) Start() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.start()
+}
+
+func (t *defaultTimer) start() {
+	if t.stopChan != nil {
+		return
+	}
+	t.stopChan = make(chan struct{})
+	go t.run(t.stopChan, t.period)
+}
+
+// Stop stops the timer; a call waiting in the queue is skipped.
+func (t *defaultTimer) Stop() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.stop()
+}
+
+func (t *defaultTimer) stop() {
+	if t.stopChan != nil {
+		close(t.stopChan)
+		t.stopChan = nil
+	}
+}
+
+// SetPeriod changes the period of the timer; a started timer starts again, so its next call is due a new
+// period from now.
+func (t *defaultTimer) SetPeriod(period Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.period = period
+	if t.stopChan != nil {
+		t.stop()
+		t.start()
+	}
+}
+
+// HasStarted reports whether the timer is started.
+func (t *defaultTimer) HasStarted() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.stopChan != nil
+}
+
+// running reports whether stopChan is of the started timer.
+func (t *defaultTimer) running(stopChan chan struct{}) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.stopChan == stopChan
+}
+
+// run queues the calls of the timer until stopChan is closed.
+func (t *defaultTimer) run(stopChan chan struct{}, period Duration) {
+	var last TimerEvent
+	now := t.clock.now()
+	expected := now.Add(period)
+	for {
+		reached, release := t.clock.after(expected)
+		select {
+		case <-reached:
+			release()
+		case <-stopChan:
+			release()
+			return
+		}
+		if now = t.clock.now(); now.Cmp(expected) < 0 {
+			// Time jumped backwards; the next call is due a period from the new time.
+			expected = now.Add(period)
+			continue
+		}
+
+		event := TimerEvent{LastExpected: last.CurrentExpected, LastReal: last.CurrentReal, CurrentExpected: expected}
+		calledChan := make(chan TimerEvent, 1)
+		job := func() {
+			if !t.running(stopChan) {
+				close(calledChan)
+				return
+			}
+			event.CurrentReal = t.clock.now()
+			calledChan <- event
+			t.callback(event)
+		}
+		select {
+		case t.queue.jobChan <- callbackJob{t, job}:
+		case <-stopChan:
+			return
+		}
+		select {
+		case called, ok := <-calledChan:
+			if !ok {
+				return
+			}
+			last = called
+		case <-stopChan:
+			return
+		}
+
+		if t.oneshot {
+			t.mutex.Lock()
+			if t.stopChan == stopChan {
+				t.stopChan = nil
+			}
+			t.mutex.Unlock()
+			return
+		}
+		expected = expected.Add(period)
+		// Skip the calls which a late callback missed.
+		if now = t.clock.now(); expected.Cmp(now) < 0 {
+			expected = now.Add(period)
+		}
+	}
+}
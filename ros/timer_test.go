@@ -0,0 +1,93 @@
+package ros
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestDuration(d time.Duration) Duration {
+	var duration Duration
+	duration.FromNSec(uint64(d))
+	return duration
+}
+
+// spinFor calls the callbacks of queue for d.
+func spinFor(queue *CallbackQueue, d time.Duration) {
+	end := time.Now().Add(d)
+	for time.Now().Before(end) {
+		queue.CallOne(time.Millisecond)
+	}
+}
+
+func TestTimer(t *testing.T) {
+	queue := NewCallbackQueue(10)
+	var events []TimerEvent
+	timer := newDefaultTimer(queue, wallClock{}, newTestDuration(20*time.Millisecond), func(event TimerEvent) {
+		events = append(events, event)
+	}, false)
+	timer.Start()
+	spinFor(queue, 110*time.Millisecond)
+	timer.Stop()
+	if timer.HasStarted() {
+		t.Error("expected the timer to be stopped")
+	}
+
+	if len(events) < 3 || len(events) > 6 {
+		t.Fatalf("expected about 5 calls, got %d", len(events))
+	}
+	if !events[0].LastExpected.IsZero() || !events[0].LastReal.IsZero() {
+		t.Errorf("unexpected first event %+v", events[0])
+	}
+	for i, event := range events {
+		if event.CurrentReal.Cmp(event.CurrentExpected) < 0 {
+			t.Errorf("event %d called early: %+v", i, event)
+		}
+		if i > 0 && (event.LastExpected != events[i-1].CurrentExpected || event.LastReal != events[i-1].CurrentReal) {
+			t.Errorf("event %d doesn't follow the last one: %+v", i, event)
+		}
+	}
+
+	// A stopped timer isn't called, and one waiting in the queue is skipped.
+	calls := len(events)
+	spinFor(queue, 50*time.Millisecond)
+	if len(events) != calls {
+		t.Errorf("expected no calls after stopping, got %d", len(events)-calls)
+	}
+
+	// A new period applies from now.
+	timer.SetPeriod(newTestDuration(time.Hour))
+	timer.Start()
+	spinFor(queue, 50*time.Millisecond)
+	timer.SetPeriod(newTestDuration(10 * time.Millisecond))
+	spinFor(queue, 50*time.Millisecond)
+	timer.Stop()
+	if len(events) == calls {
+		t.Error("expected calls after shortening the period")
+	}
+}
+
+func TestOneshotTimer(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/node")
+	defer node.Shutdown()
+
+	calls := 0
+	timer := node.NewWallTimer(newTestDuration(10*time.Millisecond), func(TimerEvent) { calls++ }, true)
+	spinFor(node.CallbackQueue(), 50*time.Millisecond)
+	if calls != 1 || timer.HasStarted() {
+		t.Errorf("expected a single call of a stopped timer, got %d calls", calls)
+	}
+	timer.Start()
+	spinFor(node.CallbackQueue(), 50*time.Millisecond)
+	if calls != 2 {
+		t.Errorf("expected a restarted timer to be called once more, got %d calls", calls)
+	}
+
+	// Shutdown stops the timers of the node.
+	timer = node.NewTimer(newTestDuration(time.Millisecond), func(TimerEvent) {}, false)
+	node.Shutdown()
+	if timer.HasStarted() {
+		t.Error("expected shutdown to stop the timer")
+	}
+}
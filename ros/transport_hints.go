@@ -0,0 +1,147 @@
+package ros
+
+import (
+	"time"
+)
+
+const (
+	protocolTCPROS = "TCPROS"
+	protocolUDPROS = "UDPROS"
+)
+
+// TransportHints are the preferences of a subscriber for the transport of its connections to publishers,
+// passed to NewSubscriber with OptionTransportHints.  Like roscpp's, the hints are built by chaining, e.g.
+//
+//	ros.TransportHints{}.Unreliable().Reliable()
+//
+// prefers UDPROS and falls back to TCPROS.  The zero value uses TCPROS with the default socket options.
+type TransportHints struct {
+	protocols       []string
+	maxDatagramSize int
+	tcpNoDelay      bool
+	readBufferSize  int
+	writeBufferSize int
+	keepAliveSet    bool
+	keepAlive       time.Duration
+}
+
+// Reliable adds TCPROS to the protocols, after the ones already added.
+func (h TransportHints) Reliable() TransportHints {
+	return h.addProtocol(protocolTCPROS)
+}
+
+// Unreliable adds UDPROS to the protocols, after the ones already added.
+func (h TransportHints) Unreliable() TransportHints {
+	return h.addProtocol(protocolUDPROS)
+}
+
+// MaxDatagramSize sets the largest UDPROS datagram, including its header.
+func (h TransportHints) MaxDatagramSize(size int) TransportHints {
+	h.maxDatagramSize = size
+	return h
+}
+
+// TCPNoDelay asks for TCP_NODELAY on both ends of TCPROS connections, so that small messages are sent
+// without waiting for Nagle's algorithm.
+func (h TransportHints) TCPNoDelay(noDelay bool) TransportHints {
+	h.tcpNoDelay = noDelay
+	return h
+}
+
+// ReadBufferSize sets the size of the receive buffer of the sockets of the subscriber.
+func (h TransportHints) ReadBufferSize(bytes int) TransportHints {
+	h.readBufferSize = bytes
+	return h
+}
+
+// WriteBufferSize sets the size of the send buffer of the sockets of the subscriber.
+func (h TransportHints) WriteBufferSize(bytes int) TransportHints {
+	h.writeBufferSize = bytes
+	return h
+}
+
+// KeepAlive sets the period of TCP keepalive probes of TCPROS connections; a negative period disables them.
+func (h TransportHints) KeepAlive(period time.Duration) TransportHints {
+	h.keepAliveSet = true
+	h.keepAlive = period
+	return h
+}
+
+func (h TransportHints) addProtocol(protocol string) TransportHints {
+	protocols := make([]string, 0, len(h.protocols)+1)
+	for _, p := range h.protocols {
+		if p != protocol {
+			protocols = append(protocols, p)
+		}
+	}
+	h.protocols = append(protocols, protocol)
+	return h
+}
+
+// getProtocols returns the protocols in order of preference.
+func (h TransportHints) getProtocols() []string {
+	if len(h.protocols) == 0 {
+		return []string{protocolTCPROS}
+	}
+	return h.protocols
+}
+
+func (h TransportHints) getMaxDatagramSize() int {
+	if h.maxDatagramSize <= udprosHeaderSize {
+		return udprosDefaultMaxDatagramSize
+	}
+	return h.maxDatagramSize
+}
+
+// tcpSocket holds the socket options of a *net.TCPConn set by applyTCP.
+type tcpSocket interface {
+	bufferedConn
+	SetNoDelay(noDelay bool) error
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
+// applyTCP sets the socket options of a TCPROS connection.
+func (h TransportHints) applyTCP(conn tcpSocket) error {
+	if err := conn.SetNoDelay(h.tcpNoDelay); err != nil {
+		return err
+	}
+	if h.keepAliveSet {
+		if err := conn.SetKeepAlive(h.keepAlive >= 0); err != nil {
+			return err
+		}
+		if h.keepAlive > 0 {
+			if err := conn.SetKeepAlivePeriod(h.keepAlive); err != nil {
+				return err
+			}
+		}
+	}
+	return h.applyBuffers(conn)
+}
+
+type bufferedConn interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+func (h TransportHints) applyBuffers(conn bufferedConn) error {
+	if h.readBufferSize > 0 {
+		if err := conn.SetReadBuffer(h.readBufferSize); err != nil {
+			return err
+		}
+	}
+	if h.writeBufferSize > 0 {
+		if err := conn.SetWriteBuffer(h.writeBufferSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OptionTransportHints sets the preferred transports of a subscriber.
+func OptionTransportHints(hints TransportHints) OptionSubscriber {
+	return func(opts *SubscriberOptions) error {
+		opts.TransportHints = hints
+		return nil
+	}
+}
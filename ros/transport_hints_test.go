@@ -0,0 +1,154 @@
+package ros
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	modular "github.com/edwinhayes/logrus-modular"
+	"github.com/sirupsen/logrus"
+)
+
+// socketConn records the socket options set on a connection.
+type socketConn struct {
+	net.Conn
+	noDelay []bool
+}
+
+func (c *socketConn) SetNoDelay(noDelay bool) error {
+	c.noDelay = append(c.noDelay, noDelay)
+	return nil
+}
+
+func (c *socketConn) SetKeepAlive(keepalive bool) error        { return nil }
+func (c *socketConn) SetKeepAlivePeriod(d time.Duration) error { return nil }
+func (c *socketConn) SetReadBuffer(bytes int) error            { return nil }
+func (c *socketConn) SetWriteBuffer(bytes int) error           { return nil }
+
+func TestApplyTCPNoDelay(t *testing.T) {
+	for _, noDelay := range []bool{true, false} {
+		conn := &socketConn{}
+		if err := (TransportHints{}).TCPNoDelay(noDelay).applyTCP(conn); err != nil {
+			t.Fatal(err)
+		}
+		if len(conn.noDelay) != 1 || conn.noDelay[0] != noDelay {
+			t.Errorf("expected TCP_NODELAY to be set to %v, got %v", noDelay, conn.noDelay)
+		}
+	}
+}
+
+func TestAcceptTCPROSNoDelay(t *testing.T) {
+	logger := modular.NewRootLogger(logrus.New())
+	for _, noDelay := range []string{"1", "0"} {
+		local, remote := net.Pipe()
+		conn := &socketConn{Conn: local}
+		session := &remoteSubscriberSession{
+			conn:     conn,
+			topic:    "/chatter",
+			typeName: msgTypeGoalID.Name(),
+			md5sum:   msgTypeGoalID.MD5Sum(),
+			stats:    newConnectionStats("/chatter", ConnectionDirectionOutbound, protocolTCPROS, ""),
+		}
+		go func() {
+			headers := []header{
+				{"callerid", "/listener"},
+				{"md5sum", msgTypeGoalID.MD5Sum()},
+				{"tcp_nodelay", noDelay},
+				{"topic", "/chatter"},
+				{"type", msgTypeGoalID.Name()},
+			}
+			if err := writeConnectionHeader(headers, remote); err != nil {
+				return
+			}
+			_, _ = readConnectionHeader(remote)
+		}()
+		if _, ok := session.acceptTCPROS(logger); !ok {
+			t.Fatal("failed to accept the connection")
+		}
+		if len(conn.noDelay) != 1 || conn.noDelay[0] != (noDelay == "1") {
+			t.Errorf("expected TCP_NODELAY to follow tcp_nodelay %s, got %v", noDelay, conn.noDelay)
+		}
+		local.Close()
+		remote.Close()
+	}
+}
+
+func TestTCPNoDelayHeader(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	headerChan := make(chan map[string]string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		headers, _ := readConnectionHeader(conn)
+		headerMap := make(map[string]string)
+		for _, h := range headers {
+			headerMap[h.key] = h.value
+		}
+		headerChan <- headerMap
+	}()
+
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	node := newTestNode(t, m, "/listener")
+	defer node.Shutdown()
+	logger := node.logger
+	quitChan := make(chan struct{}, 1)
+	defer func() { quitChan <- struct{}{} }()
+	stats := newConnectionStats("/cmd_vel", ConnectionDirectionInbound, protocolTCPROS, "")
+	hints := TransportHints{}.TCPNoDelay(true).ReadBufferSize(1 << 16).KeepAlive(time.Second)
+	go startRemotePublisherConn(context.Background(), &logger, "http://127.0.0.1:1/", listener.Addr().String(), "/cmd_vel",
+		msgTypeGoalID.MD5Sum(), msgTypeGoalID.Name(), "/listener",
+		make(chan messageEvent, 1), QueueOptions{Size: 1}, quitChan, make(chan string, 1), msgTypeGoalID,
+		hints, stats)
+
+	select {
+	case headerMap := <-headerChan:
+		if headerMap["tcp_nodelay"] != "1" {
+			t.Errorf("expected tcp_nodelay 1, got %v", headerMap)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the connection header")
+	}
+}
+
+func TestTransportHints(t *testing.T) {
+	tests := []struct {
+		hints    TransportHints
+		expected []string
+	}{
+		{TransportHints{}, []string{"TCPROS"}},
+		{TransportHints{}.Unreliable(), []string{"UDPROS"}},
+		{TransportHints{}.Unreliable().Reliable(), []string{"UDPROS", "TCPROS"}},
+		{TransportHints{}.Reliable().Unreliable().Reliable(), []string{"UDPROS", "TCPROS"}},
+	}
+	for _, test := range tests {
+		if protocols := test.hints.getProtocols(); strings.Join(protocols, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("expected %v, got %v", test.expected, protocols)
+		}
+	}
+	if size := (TransportHints{}).getMaxDatagramSize(); size != udprosDefaultMaxDatagramSize {
+		t.Errorf("expected the default datagram size, got %d", size)
+	}
+}
+
+func TestTransportHintsOptions(t *testing.T) {
+	hints := TransportHints{}.Unreliable().TCPNoDelay(true).WriteBufferSize(1024).KeepAlive(-1)
+	if !hints.tcpNoDelay || hints.writeBufferSize != 1024 || !hints.keepAliveSet || hints.keepAlive >= 0 {
+		t.Errorf("unexpected hints %+v", hints)
+	}
+	// The hints are values, so deriving new hints leaves the old ones alone.
+	reliable := hints.Reliable()
+	if len(hints.getProtocols()) != 1 || len(reliable.getProtocols()) != 2 {
+		t.Errorf("expected independent hints, got %v and %v", hints.getProtocols(), reliable.getProtocols())
+	}
+}
@@ -0,0 +1,208 @@
+package ros
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	modular "github.com/edwinhayes/logrus-modular"
+)
+
+// UDPROS sends each message, with its length prefix, in one or more datagrams.  Every datagram starts with
+// an 8 byte header: the connection id, an op code, the message id and a block number.  The first datagram
+// of a message is DATA0 and carries the number of blocks in the message; the rest are DATAN and carry
+// their index.  A message missing any block is dropped.
+const (
+	udprosHeaderSize             = 8
+	udprosDefaultMaxDatagramSize = 1500
+	udprosOpData0                = 0
+	udprosOpDataN                = 1
+)
+
+type udprosHeader struct {
+	connectionID uint32
+	opCode       uint8
+	messageID    uint8
+	block        uint16
+}
+
+func (h *udprosHeader) encode(buf []byte) {
+	binary.LittleEndian.PutUint32(buf[0:4], h.connectionID)
+	buf[4] = h.opCode
+	buf[5] = h.messageID
+	binary.LittleEndian.PutUint16(buf[6:8], h.block)
+}
+
+func decodeUDPROSHeader(datagram []byte) (udprosHeader, error) {
+	if len(datagram) < udprosHeaderSize {
+		return udprosHeader{}, fmt.Errorf("UDPROS datagram of %d bytes is too short", len(datagram))
+	}
+	return udprosHeader{
+		connectionID: binary.LittleEndian.Uint32(datagram[0:4]),
+		opCode:       datagram[4],
+		messageID:    datagram[5],
+		block:        binary.LittleEndian.Uint16(datagram[6:8]),
+	}, nil
+}
+
+// fragmentMessage splits msg into datagrams of at most maxDatagramSize bytes.
+func fragmentMessage(connectionID uint32, messageID uint8, msg []byte, maxDatagramSize int) ([][]byte, error) {
+	payload := make([]byte, 4+len(msg))
+	binary.LittleEndian.PutUint32(payload, uint32(len(msg)))
+	copy(payload[4:], msg)
+
+	blockSize := maxDatagramSize - udprosHeaderSize
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("UDPROS datagram size %d is too small", maxDatagramSize)
+	}
+	blocks := (len(payload) + blockSize - 1) / blockSize
+	if blocks > 0xffff {
+		return nil, fmt.Errorf("message of %d bytes needs too many UDPROS datagrams", len(msg))
+	}
+	datagrams := make([][]byte, 0, blocks)
+	for i := 0; i < blocks; i++ {
+		h := udprosHeader{connectionID: connectionID, opCode: udprosOpDataN, messageID: messageID, block: uint16(i)}
+		if i == 0 {
+			h.opCode = udprosOpData0
+			h.block = uint16(blocks)
+		}
+		end := (i + 1) * blockSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		datagram := make([]byte, udprosHeaderSize+end-i*blockSize)
+		h.encode(datagram)
+		copy(datagram[udprosHeaderSize:], payload[i*blockSize:end])
+		datagrams = append(datagrams, datagram)
+	}
+	return datagrams, nil
+}
+
+// udprosReassembler puts together the messages of one UDPROS connection from its datagrams.
+type udprosReassembler struct {
+	connectionID uint32
+	messageID    uint8
+	blocks       int // Number of blocks of the message being put together, 0 if none.
+	next         int
+	buffer       bytes.Buffer
+}
+
+// add adds a datagram, returning the message once it is complete, and whether an incomplete message was
+// dropped.
+func (r *udprosReassembler) add(datagram []byte) ([]byte, bool) {
+	h, err := decodeUDPROSHeader(datagram)
+	if err != nil || h.connectionID != r.connectionID {
+		return nil, false
+	}
+	dropped := false
+	switch h.opCode {
+	case udprosOpData0:
+		dropped = r.blocks != 0
+		r.messageID = h.messageID
+		r.blocks = int(h.block)
+		r.next = 1
+		r.buffer.Reset()
+	case udprosOpDataN:
+		if r.blocks == 0 || h.messageID != r.messageID || int(h.block) != r.next {
+			dropped = r.blocks != 0
+			r.blocks = 0
+			return nil, dropped
+		}
+		r.next++
+	default:
+		return nil, false
+	}
+	r.buffer.Write(datagram[udprosHeaderSize:])
+	if r.next < r.blocks {
+		return nil, dropped
+	}
+	r.blocks = 0
+	payload := r.buffer.Bytes()
+	if len(payload) < 4 || int(binary.LittleEndian.Uint32(payload)) != len(payload)-4 {
+		return nil, true
+	}
+	msg := make([]byte, len(payload)-4)
+	copy(msg, payload[4:])
+	return msg, dropped
+}
+
+// udprosSender sends the messages of a publisher session over UDPROS.
+type udprosSender struct {
+	connectionID    uint32
+	messageID       uint8
+	maxDatagramSize int
+}
+
+func (s *udprosSender) send(conn net.Conn, msg []byte) error {
+	datagrams, err := fragmentMessage(s.connectionID, s.messageID, msg, s.maxDatagramSize)
+	if err != nil {
+		return err
+	}
+	s.messageID++
+	for _, datagram := range datagrams {
+		if _, err := conn.Write(datagram); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startRemotePublisherUDP receives messages from the publisher with slave API pubAPI over the UDPROS
+// connection conn, whose response header is resHeaderMap.
+func startRemotePublisherUDP(log *modular.ModuleLogger,
+	pubAPI string, conn *net.UDPConn, connectionID uint32,
+	topic string, resHeaderMap map[string]string,
+	msgChan chan messageEvent,
+	queue QueueOptions,
+	quitChan chan struct{},
+	stats *connectionStats) {
+
+	logger := *log
+	logger.Debug(topic, " : startRemotePublisherUDP()")
+	defer func() {
+		logger.Debug(topic, " : startRemotePublisherUDP() exit")
+		conn.Close()
+		stats.setDisconnected()
+	}()
+
+	if _, ok := resHeaderMap["latching"]; !ok {
+		resHeaderMap["latching"] = "0"
+	}
+	event := MessageEvent{
+		PublisherName:    resHeaderMap["callerid"],
+		ConnectionHeader: resHeaderMap,
+	}
+	stats.setConnected("", fmt.Sprintf("UDPROS connection on port %d to [%s]",
+		conn.LocalAddr().(*net.UDPAddr).Port, pubAPI))
+
+	reassembler := udprosReassembler{connectionID: connectionID}
+	datagram := make([]byte, 65536)
+	for {
+		select {
+		case <-quitChan:
+			return
+		default:
+			conn.SetReadDeadline(time.Now().Add(1000 * time.Millisecond))
+			n, err := conn.Read(datagram)
+			if err != nil {
+				if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+					continue
+				}
+				logger.Error(topic, " : Failed to read a UDPROS datagram: ", err)
+				return
+			}
+			msg, dropped := reassembler.add(datagram[:n])
+			if dropped {
+				stats.addDrop()
+			}
+			if msg == nil {
+				continue
+			}
+			event.ReceiptTime = time.Now()
+			stats.addMessage(len(msg))
+			enqueueMessageEvent(msgChan, messageEvent{bytes: msg, event: event, stats: stats}, queue)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package ros
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFragmentMessage(t *testing.T) {
+	for _, size := range []int{0, 1, 87, 88, 89, 500} {
+		msg := bytes.Repeat([]byte{0xab}, size)
+		datagrams, err := fragmentMessage(7, 3, msg, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := (size + 4 + 91) / 92; len(datagrams) != expected {
+			t.Errorf("%d bytes: expected %d datagrams, got %d", size, expected, len(datagrams))
+		}
+		for i, datagram := range datagrams {
+			if len(datagram) > 100 {
+				t.Errorf("%d bytes: datagram %d has %d bytes", size, i, len(datagram))
+			}
+			h, _ := decodeUDPROSHeader(datagram)
+			if i == 0 && (h.opCode != udprosOpData0 || int(h.block) != len(datagrams)) {
+				t.Errorf("%d bytes: unexpected first header %+v", size, h)
+			}
+			if i > 0 && (h.opCode != udprosOpDataN || int(h.block) != i) {
+				t.Errorf("%d bytes: unexpected header %+v of datagram %d", size, h, i)
+			}
+		}
+
+		r := udprosReassembler{connectionID: 7}
+		var result []byte
+		for _, datagram := range datagrams {
+			var dropped bool
+			if result, dropped = r.add(datagram); dropped {
+				t.Errorf("%d bytes: unexpected drop", size)
+			}
+		}
+		if result == nil || !bytes.Equal(result, msg) {
+			t.Errorf("%d bytes: reassembled %d bytes", size, len(result))
+		}
+	}
+
+	if _, err := fragmentMessage(1, 0, []byte{1}, udprosHeaderSize); err == nil {
+		t.Error("expected an error for a datagram size without room for data")
+	}
+}
+
+func TestReassemblerDropsIncomplete(t *testing.T) {
+	first, _ := fragmentMessage(1, 0, bytes.Repeat([]byte{1}, 200), 100)
+	second, _ := fragmentMessage(1, 1, bytes.Repeat([]byte{2}, 200), 100)
+	other, _ := fragmentMessage(2, 0, []byte{3}, 100)
+
+	r := udprosReassembler{connectionID: 1}
+	if msg, _ := r.add(other[0]); msg != nil {
+		t.Error("expected a datagram of another connection to be ignored")
+	}
+	// The last block of the first message is lost.
+	r.add(first[0])
+	r.add(first[1])
+	if msg, dropped := r.add(second[0]); msg != nil || !dropped {
+		t.Error("expected the incomplete message to be dropped")
+	}
+	r.add(second[1])
+	// A block out of order drops the message.
+	if msg, dropped := r.add(first[2]); msg != nil || !dropped {
+		t.Error("expected a block of another message to drop the message")
+	}
+	if msg, dropped := r.add(second[2]); msg != nil || dropped {
+		t.Error("expected a stray block to be ignored")
+	}
+}
+
+func TestHeaderFields(t *testing.T) {
+	data := encodeHeaderFields([]header{{"topic", "/chatter"}, {"callerid", "/talker"}})
+	headerMap, err := decodeHeaderFields(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headerMap) != 2 || headerMap["topic"] != "/chatter" || headerMap["callerid"] != "/talker" {
+		t.Errorf("unexpected header %v", headerMap)
+	}
+}
+
+func TestUDPROS(t *testing.T) {
+	m := newTestMaster(t)
+	defer m.Shutdown()
+	talker := newTestNode(t, m, "/talker")
+	defer talker.Shutdown()
+	listener := newTestNode(t, m, "/listener")
+	defer listener.Shutdown()
+
+	pub, err := talker.NewPublisher("/scan", msgTypeGoalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan *goalIDMessage, 10)
+	sub, err := listener.NewSubscriber("/scan", msgTypeGoalID, func(msg *goalIDMessage) { received <- msg },
+		OptionTransportHints(TransportHints{}.Unreliable().Reliable().MaxDatagramSize(100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Large enough to be sent in several datagrams.
+	id := strings.Repeat("x", 1000)
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		pub.Publish(&goalIDMessage{GoalID{ID: id}})
+		listener.SpinOnce()
+		select {
+		case msg := <-received:
+			if msg.ID != id {
+				t.Errorf("unexpected message of %d bytes", len(msg.ID))
+			}
+			done = true
+		case <-timeout:
+			t.Fatal("timed out waiting for a message")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	subStats := sub.GetConnectionStats()
+	pubStats := pub.GetConnectionStats()
+	if len(subStats) != 1 || subStats[0].Transport != "UDPROS" || !subStats[0].Connected {
+		t.Errorf("unexpected subscriber stats %+v", subStats)
+	}
+	if len(pubStats) != 1 || pubStats[0].Transport != "UDPROS" || pubStats[0].RemoteID != "/listener" {
+		t.Errorf("unexpected publisher stats %+v", pubStats)
+	}
+}
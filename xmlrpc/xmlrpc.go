@@ -3,6 +3,7 @@ package xmlrpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/xml"
 	"errors"
@@ -479,16 +480,28 @@ func parseResponse(d *xml.Decoder) (ok bool, result interface{}, e error) {
 // Args:
 //   url string: URL of the remote host
 func Call(url string, method string, args ...interface{}) (res interface{}, e error) {
+	return CallContext(context.Background(), url, method, args...)
+}
+
+// CallContext is Call, giving up when ctx is done; the error then wraps ctx.Err().
+func CallContext(ctx context.Context, url string, method string, args ...interface{}) (res interface{}, e error) {
 	var buffer bytes.Buffer
 	e = emitRequest(&buffer, method, args...)
 	if e != nil {
 		e = fmt.Errorf("Building request failed for %v", e)
 		return
 	}
+	var req *http.Request
+	req, e = http.NewRequestWithContext(ctx, "POST", url, &buffer)
+	if e != nil {
+		e = fmt.Errorf("Building request failed for %v", e)
+		return
+	}
+	req.Header.Set("Content-Type", "text/xml")
 	var r *http.Response
-	r, e = http.Post(url, "text/xml", &buffer)
+	r, e = http.DefaultClient.Do(req)
 	if e != nil {
-		e = fmt.Errorf("Sending request failed for %v", e)
+		e = fmt.Errorf("Sending request failed for %w", e)
 		return
 	}
 	defer r.Body.Close()